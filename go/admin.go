@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// adminToken 管理APIのBearerトークン。空の場合は管理APIを全て拒否する
+var adminToken string
+
+//AdminChair 管理API向けに人気度と在庫も返す椅子
+type AdminChair struct {
+	Chair
+	Popularity int64 `json:"popularity"`
+	Stock      int64 `json:"stock"`
+}
+
+//AdminEstate 管理API向けに人気度も返す物件
+type AdminEstate struct {
+	Estate
+	Popularity int64 `json:"popularity"`
+}
+
+type ChairUpdateRequest struct {
+	ID          int64   `json:"-" param:"id" validate:"required,min=1"`
+	Name        *string `json:"name" validate:"maxlen=64"`
+	Description *string `json:"description" validate:"maxlen=4096"`
+	Thumbnail   *string `json:"thumbnail" validate:"maxlen=128"`
+	Price       *int64  `json:"price" validate:"min=0"`
+	Color       *string `json:"color" validate:"enum=chair.color"`
+	Features    *string `json:"features" validate:"maxlen=64,features=chair.feature"`
+	Kind        *string `json:"kind" validate:"enum=chair.kind"`
+	Popularity  *int64  `json:"popularity" validate:"min=0"`
+	Stock       *int64  `json:"stock" validate:"min=0"`
+}
+
+type EstateUpdateRequest struct {
+	ID          int64   `json:"-" param:"id" validate:"required,min=1"`
+	Name        *string `json:"name" validate:"maxlen=64"`
+	Description *string `json:"description" validate:"maxlen=4096"`
+	Thumbnail   *string `json:"thumbnail" validate:"maxlen=128"`
+	Address     *string `json:"address" validate:"maxlen=128"`
+	Rent        *int64  `json:"rent" validate:"min=0"`
+	Features    *string `json:"features" validate:"maxlen=64,features=estate.feature"`
+	Popularity  *int64  `json:"popularity" validate:"min=0"`
+}
+
+func newAdminChair(chair Chair) AdminChair {
+	return AdminChair{Chair: chair, Popularity: chair.Popularity, Stock: chair.Stock}
+}
+
+func newAdminEstate(estate Estate) AdminEstate {
+	return AdminEstate{Estate: estate, Popularity: estate.Popularity}
+}
+
+func adminAuth() echo.MiddlewareFunc {
+	return middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		if adminToken == "" {
+			return false, nil
+		}
+		return subtle.ConstantTimeCompare([]byte(key), []byte(adminToken)) == 1, nil
+	})
+}
+
+// updateColumns 更新する列のSET句を組み立てる
+type updateColumns struct {
+	sets   []string
+	params []interface{}
+}
+
+func (u *updateColumns) setString(column string, v *string) {
+	if v != nil {
+		u.sets = append(u.sets, column+" = ?")
+		u.params = append(u.params, *v)
+	}
+}
+
+func (u *updateColumns) setInt(column string, v *int64) {
+	if v != nil {
+		u.sets = append(u.sets, column+" = ?")
+		u.params = append(u.params, *v)
+	}
+}
+
+func patchAdminChair(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*ChairUpdateRequest)
+	id := req.ID
+
+	u := updateColumns{}
+	u.setString("name", req.Name)
+	u.setString("description", req.Description)
+	u.setString("thumbnail", req.Thumbnail)
+	u.setInt("price", req.Price)
+	u.setString("color", req.Color)
+	u.setString("features", req.Features)
+	u.setString("kind", req.Kind)
+	u.setInt("popularity", req.Popularity)
+	u.setInt("stock", req.Stock)
+	if len(u.sets) == 0 {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidBody, "no fields to update", nil)
+	}
+
+	tx1, err := db.withState.Beginx()
+	if err != nil {
+		return internalError("failed to begin tx", err)
+	}
+	defer tx1.Rollback()
+	tx2, err := db.noState.Beginx()
+	if err != nil {
+		return internalError("failed to begin tx", err)
+	}
+	defer tx2.Rollback()
+
+	var before Chair
+	err = tx1.GetContext(ctx, &before, "SELECT * FROM chair WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("chair %v not found", id)
+		}
+		return internalError("DB Execution Error: on getting a chair by id", err)
+	}
+
+	query := "UPDATE chair SET " + strings.Join(u.sets, ", ") + " WHERE id = ?"
+	params := append(u.params, id)
+	if _, err := tx1.ExecContext(ctx, query, params...); err != nil {
+		return internalError("failed to update chair", err)
+	}
+	if _, err := tx2.ExecContext(ctx, query, params...); err != nil {
+		return internalError("failed to update chair", err)
+	}
+
+	var after Chair
+	if err := tx1.GetContext(ctx, &after, "SELECT * FROM chair WHERE id = ?", id); err != nil {
+		return internalError("DB Execution Error: on getting a chair by id", err)
+	}
+
+	if err := tx1.Commit(); err != nil {
+		return internalError("failed to commit tx", err)
+	}
+	if err := tx2.Commit(); err != nil {
+		return internalError("failed to commit tx", err)
+	}
+
+	invalidateChairCache(ctx, before, after)
+	if before.Stock <= 0 && after.Stock > 0 {
+		go notifyRestock(context.Background(), []int64{after.ID})
+	}
+
+	return c.JSON(http.StatusOK, newAdminChair(after))
+}
+
+func patchAdminEstate(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*EstateUpdateRequest)
+	id := req.ID
+
+	u := updateColumns{}
+	u.setString("name", req.Name)
+	u.setString("description", req.Description)
+	u.setString("thumbnail", req.Thumbnail)
+	u.setString("address", req.Address)
+	u.setInt("rent", req.Rent)
+	u.setString("features", req.Features)
+	u.setInt("popularity", req.Popularity)
+	if len(u.sets) == 0 {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidBody, "no fields to update", nil)
+	}
+
+	tx1, err := db.withState.Beginx()
+	if err != nil {
+		return internalError("failed to begin tx", err)
+	}
+	defer tx1.Rollback()
+	tx2, err := db.noState.Beginx()
+	if err != nil {
+		return internalError("failed to begin tx", err)
+	}
+	defer tx2.Rollback()
+
+	var before Estate
+	err = tx2.GetContext(ctx, &before, "SELECT * FROM estate WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("estate %v not found", id)
+		}
+		return internalError("DB Execution Error: on getting an estate by id", err)
+	}
+
+	query := "UPDATE estate SET " + strings.Join(u.sets, ", ") + " WHERE id = ?"
+	params := append(u.params, id)
+	if _, err := tx1.ExecContext(ctx, query, params...); err != nil {
+		return internalError("failed to update estate", err)
+	}
+	if _, err := tx2.ExecContext(ctx, query, params...); err != nil {
+		return internalError("failed to update estate", err)
+	}
+
+	var after Estate
+	if err := tx2.GetContext(ctx, &after, "SELECT * FROM estate WHERE id = ?", id); err != nil {
+		return internalError("DB Execution Error: on getting an estate by id", err)
+	}
+
+	if err := tx1.Commit(); err != nil {
+		return internalError("failed to commit tx", err)
+	}
+	if err := tx2.Commit(); err != nil {
+		return internalError("failed to commit tx", err)
+	}
+
+	invalidateEstateCache(ctx, before, after)
+
+	return c.JSON(http.StatusOK, newAdminEstate(after))
+}
+
+// getCacheStats 名前空間ごとのヒット数・ミス数・追い出し数を返す
+func getCacheStats(c echo.Context) error {
+	stats := append(chairCache.Stats(), estateCache.Stats()...)
+	return c.JSON(http.StatusOK, stats)
+}
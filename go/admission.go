@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// admissionConfig ルートごとの同時実行数と待ち行列。Concurrencyが0なら制限しない
+type admissionConfig struct {
+	Concurrency int
+	Queue       int
+	MaxWait     time.Duration
+}
+
+// admissionDefaults ADMISSION_<ルート名>が無い場合の設定。DBの接続数に合わせて重いルートほど絞る
+var admissionDefaults = map[string]admissionConfig{
+	"chair_detail":       {Concurrency: 64, Queue: 256, MaxWait: 500 * time.Millisecond},
+	"chair_search":       {Concurrency: 16, Queue: 128, MaxWait: 500 * time.Millisecond},
+	"chair_low_priced":   {Concurrency: 32, Queue: 128, MaxWait: 500 * time.Millisecond},
+	"chair_buy":          {Concurrency: 8, Queue: 64, MaxWait: time.Second},
+	"chair_import":       {Concurrency: 2, Queue: 4, MaxWait: 5 * time.Second},
+	"estate_detail":      {Concurrency: 64, Queue: 256, MaxWait: 500 * time.Millisecond},
+	"estate_search":      {Concurrency: 16, Queue: 128, MaxWait: 500 * time.Millisecond},
+	"estate_low_priced":  {Concurrency: 32, Queue: 128, MaxWait: 500 * time.Millisecond},
+	"estate_nazotte":     {Concurrency: 4, Queue: 32, MaxWait: time.Second},
+	"estate_import":      {Concurrency: 2, Queue: 4, MaxWait: 5 * time.Second},
+	"recommended_estate": {Concurrency: 8, Queue: 64, MaxWait: 500 * time.Millisecond},
+}
+
+// parseAdmissionConfig "同時実行数/待ち行列の長さ/最大の待ち時間" の形式を読む。"off"は制限しない
+func parseAdmissionConfig(s string) (admissionConfig, error) {
+	if s == "off" {
+		return admissionConfig{}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return admissionConfig{}, fmt.Errorf("expected concurrency/queue/maxWait, got %q", s)
+	}
+	concurrency, err := strconv.Atoi(parts[0])
+	if err != nil || concurrency < 0 {
+		return admissionConfig{}, fmt.Errorf("invalid concurrency %q", parts[0])
+	}
+	queue, err := strconv.Atoi(parts[1])
+	if err != nil || queue < 0 {
+		return admissionConfig{}, fmt.Errorf("invalid queue length %q", parts[1])
+	}
+	maxWait, err := time.ParseDuration(parts[2])
+	if err != nil || maxWait < 0 {
+		return admissionConfig{}, fmt.Errorf("invalid max wait %q", parts[2])
+	}
+	return admissionConfig{Concurrency: concurrency, Queue: queue, MaxWait: maxWait}, nil
+}
+
+// admissionLimiter 同時実行数を超えたリクエストを待ち行列で待たせ、溢れたか待ちきれなかったものは503で断る
+type admissionLimiter struct {
+	name       string
+	slots      chan struct{}
+	queue      chan struct{}
+	maxWait    time.Duration
+	retryAfter string
+}
+
+func newAdmissionLimiter(name string, cfg admissionConfig) *admissionLimiter {
+	return &admissionLimiter{
+		name:       name,
+		slots:      make(chan struct{}, cfg.Concurrency),
+		queue:      make(chan struct{}, cfg.Queue),
+		maxWait:    cfg.MaxWait,
+		retryAfter: strconv.Itoa(int(math.Max(1, math.Ceil(cfg.MaxWait.Seconds())))),
+	}
+}
+
+// admissions 起動時にルートを登録する間だけ使う。同じ名前のルートはHTTPでもgRPCでも1つの制限を共有する。
+// 制限しないルート名はnilで覚える
+var admissions = map[string]*admissionLimiter{}
+
+// admission ルート名ごとの設定で同時実行数を制限するミドルウェア
+func admission(name string) echo.MiddlewareFunc {
+	l := admissionFor(name)
+	if l == nil {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	return l.middleware
+}
+
+func admissionFor(name string) *admissionLimiter {
+	if l, ok := admissions[name]; ok {
+		return l
+	}
+	l := newAdmission(name)
+	admissions[name] = l
+	return l
+}
+
+func newAdmission(name string) *admissionLimiter {
+	cfg := admissionDefaults[name]
+	if v := getEnv("ADMISSION_"+strings.ToUpper(name), ""); v != "" {
+		parsed, err := parseAdmissionConfig(v)
+		if err != nil {
+			log.Errorf("ADMISSION_%s is ignored : %v", strings.ToUpper(name), err)
+		} else {
+			cfg = parsed
+		}
+	}
+	if cfg.Concurrency == 0 {
+		return nil
+	}
+	return newAdmissionLimiter(name, cfg)
+}
+
+func (l *admissionLimiter) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !l.acquire(c.Request().Context()) {
+			c.Response().Header().Set("Retry-After", l.retryAfter)
+			return l.rejected()
+		}
+		defer l.release()
+		return next(c)
+	}
+}
+
+func (l *admissionLimiter) rejected() *APIError {
+	return newAPIError(http.StatusServiceUnavailable, errCodeOverloaded, "server is overloaded", fmt.Errorf("admission %v rejected a request", l.name))
+}
+
+func (l *admissionLimiter) release() {
+	<-l.slots
+}
+
+// acquire 空きがあればすぐに、無ければ待ち行列に並んでmaxWaitまで空きを待つ
+func (l *admissionLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseAdmissionConfig(t *testing.T) {
+	cases := []struct {
+		in    string
+		want  admissionConfig
+		valid bool
+	}{
+		{"8/64/1s", admissionConfig{Concurrency: 8, Queue: 64, MaxWait: time.Second}, true},
+		{"2/0/250ms", admissionConfig{Concurrency: 2, MaxWait: 250 * time.Millisecond}, true},
+		{"off", admissionConfig{}, true},
+		{"0/0/0s", admissionConfig{}, true},
+		{"8/64", admissionConfig{}, false},
+		{"8/64/1s/1", admissionConfig{}, false},
+		{"x/64/1s", admissionConfig{}, false},
+		{"-1/64/1s", admissionConfig{}, false},
+		{"8/-1/1s", admissionConfig{}, false},
+		{"8/64/soon", admissionConfig{}, false},
+		{"8/64/-1s", admissionConfig{}, false},
+		{"OFF", admissionConfig{}, false},
+	}
+	for _, tc := range cases {
+		got, err := parseAdmissionConfig(tc.in)
+		if (err == nil) != tc.valid {
+			t.Errorf("%q: err %v, want valid %v", tc.in, err, tc.valid)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNewAdmissionEnvOverride(t *testing.T) {
+	cases := []struct {
+		name        string
+		env         string
+		concurrency int // 0なら制限しない
+		queue       int
+	}{
+		{"override", "2/1/100ms", 2, 1},
+		{"off", "off", 0, 0},
+		// 読めない設定は無視して既定値を使う
+		{"invalid", "2/1", admissionDefaults["chair_buy"].Concurrency, admissionDefaults["chair_buy"].Queue},
+		{"unset", "", admissionDefaults["chair_buy"].Concurrency, admissionDefaults["chair_buy"].Queue},
+	}
+	defer os.Unsetenv("ADMISSION_CHAIR_BUY")
+	for _, tc := range cases {
+		os.Setenv("ADMISSION_CHAIR_BUY", tc.env)
+		l := newAdmission("chair_buy")
+		if tc.concurrency == 0 {
+			if l != nil {
+				t.Errorf("%v: limited to %v, want no limit", tc.name, cap(l.slots))
+			}
+			continue
+		}
+		if l == nil || cap(l.slots) != tc.concurrency || cap(l.queue) != tc.queue {
+			t.Errorf("%v: limiter %+v, want concurrency %v and queue %v", tc.name, l, tc.concurrency, tc.queue)
+		}
+	}
+}
+
+func TestAdmissionRejectsWithRetryAfter(t *testing.T) {
+	cases := []struct {
+		name       string
+		cfg        admissionConfig
+		retryAfter string
+	}{
+		{"queue full", admissionConfig{Concurrency: 1, MaxWait: 1500 * time.Millisecond}, "2"},
+		{"waited too long", admissionConfig{Concurrency: 1, Queue: 1, MaxWait: 10 * time.Millisecond}, "1"},
+	}
+	for _, tc := range cases {
+		l := newAdmissionLimiter("test", tc.cfg)
+		e := newEcho(nil)
+		e.GET("/test/admission", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, l.middleware)
+
+		// 唯一の枠を使っておく
+		if !l.acquire(context.Background()) {
+			t.Fatalf("%v: the first request was rejected", tc.name)
+		}
+		rec := serveRequest(e, http.MethodGet, "/test/admission", "", nil)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%v: status %v, want 503", tc.name, rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tc.retryAfter {
+			t.Errorf("%v: Retry-After %q, want %q", tc.name, got, tc.retryAfter)
+		}
+		var res APIError
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Code != errCodeOverloaded {
+			t.Errorf("%v: body %s, want code %v", tc.name, rec.Body.String(), errCodeOverloaded)
+		}
+
+		l.release()
+		if rec := serveRequest(e, http.MethodGet, "/test/admission", "", nil); rec.Code != http.StatusOK {
+			t.Errorf("%v: status %v after the slot was released, want 200", tc.name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/patrickmn/go-cache"
+)
+
+// CacheStore キャッシュの保存先。go-cache以外の実装に差し替えられるようにする
+type CacheStore interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+	Flush()
+	Len() int
+	// OnEvicted 期限切れや削除でキーが消えた時に呼ぶ関数を登録する
+	OnEvicted(f func(key string))
+}
+
+type goCacheStore struct {
+	c *cache.Cache
+}
+
+func newGoCacheStore() CacheStore {
+	return &goCacheStore{c: cache.New(5*time.Minute, 10*time.Minute)}
+}
+
+func (s *goCacheStore) Get(key string) (interface{}, bool) {
+	return s.c.Get(key)
+}
+
+func (s *goCacheStore) Set(key string, value interface{}, ttl time.Duration) {
+	s.c.Set(key, value, ttl)
+}
+
+func (s *goCacheStore) Delete(key string) {
+	s.c.Delete(key)
+}
+
+func (s *goCacheStore) Flush() {
+	s.c.Flush()
+}
+
+func (s *goCacheStore) Len() int {
+	return s.c.ItemCount()
+}
+
+func (s *goCacheStore) OnEvicted(f func(key string)) {
+	s.c.OnEvicted(func(key string, _ interface{}) { f(key) })
+}
+
+//CacheStats 名前空間ごとのキャッシュの統計
+type CacheStats struct {
+	Namespace string `json:"namespace"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Evictions int64  `json:"evictions"`
+	Stale     int64  `json:"stale"`
+	Coalesced int64  `json:"coalesced"`
+	Items     int    `json:"items"`
+	Tags      int    `json:"tags"`
+}
+
+// cacheStaleWindow 期限切れからこの時間までは古い値を返しつつ裏で読み直す
+var cacheStaleWindow = 10 * time.Second
+
+// cacheLoadTimeout まとめた読み込みはリクエストから切り離すので、代わりにこの時間で打ち切る
+var cacheLoadTimeout = 10 * time.Second
+
+// cacheEntry storeにはstaleの期間も含めた期限で保存し、freshUntilを過ぎたら読み直す
+type cacheEntry struct {
+	value      interface{}
+	freshUntil time.Time
+}
+
+// cacheLoader キャッシュに無い値を読み、値と付けるタグを返す
+type cacheLoader func(ctx context.Context) (interface{}, []string, error)
+
+// cacheNamespace 1種類の値だけを入れるキャッシュ。タグを付けて保存し、タグ単位で消せる
+type cacheNamespace struct {
+	hits      int64
+	misses    int64
+	evictions int64
+	stale     int64
+	coalesced int64
+	// gen 無効化のたびに増やす。読み込み中に無効化された値は保存しない
+	gen int64
+
+	name        string
+	store       CacheStore
+	staleWindow time.Duration
+	flight      flightGroup
+
+	mu      sync.Mutex
+	tagKeys map[string]map[string]struct{}
+	keyTags map[string][]string
+}
+
+// newCacheNamespace staleWindowが0の名前空間は期限切れの値を返さない
+func newCacheNamespace(name string, store CacheStore, staleWindow time.Duration) *cacheNamespace {
+	n := &cacheNamespace{
+		name:        name,
+		store:       store,
+		staleWindow: staleWindow,
+		tagKeys:     map[string]map[string]struct{}{},
+		keyTags:     map[string][]string{},
+	}
+	store.OnEvicted(n.evicted)
+	return n
+}
+
+func (n *cacheNamespace) entry(key string) (cacheEntry, bool) {
+	v, ok := n.store.Get(key)
+	if !ok {
+		return cacheEntry{}, false
+	}
+	e, ok := v.(cacheEntry)
+	return e, ok
+}
+
+// get 期限内の値だけを返す
+func (n *cacheNamespace) get(key string) (interface{}, bool) {
+	e, ok := n.entry(key)
+	if ok && time.Now().Before(e.freshUntil) {
+		atomic.AddInt64(&n.hits, 1)
+		return e.value, true
+	}
+	atomic.AddInt64(&n.misses, 1)
+	return nil, false
+}
+
+// fetch キャッシュの値を返す。無ければ同じキーの同時の呼び出しをまとめてloadを1回だけ呼ぶ。
+// 期限切れ直後の値はそのまま返し、裏で1回だけ読み直す。hitはキャッシュの値を返したかどうか
+func (n *cacheNamespace) fetch(ctx context.Context, key string, ttl time.Duration, load cacheLoader) (v interface{}, hit bool, err error) {
+	if e, ok := n.entry(key); ok {
+		if time.Now().Before(e.freshUntil) {
+			atomic.AddInt64(&n.hits, 1)
+			return e.value, true, nil
+		}
+		atomic.AddInt64(&n.stale, 1)
+		n.flight.DoAsync(key, func() (interface{}, error) {
+			ctx, cancel := detachedLoadContext(ctx)
+			defer cancel()
+			return n.load(ctx, key, ttl, load)
+		})
+		return e.value, true, nil
+	}
+
+	atomic.AddInt64(&n.misses, 1)
+	// 最初に呼んだリクエストが切断されても、待っている他のリクエストには結果を返す
+	v, err, shared := n.flight.Do(key, func() (interface{}, error) {
+		ctx, cancel := detachedLoadContext(ctx)
+		defer cancel()
+		return n.load(ctx, key, ttl, load)
+	})
+	if shared {
+		atomic.AddInt64(&n.coalesced, 1)
+	}
+	return v, false, err
+}
+
+// detachedLoadContext リクエストのキャンセルを引き継がないcontext。New Relicのトランザクションだけを引き継ぐ
+func detachedLoadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(newrelic.NewContext(context.Background(), newrelic.FromContext(ctx)), cacheLoadTimeout)
+}
+
+func (n *cacheNamespace) load(ctx context.Context, key string, ttl time.Duration, load cacheLoader) (interface{}, error) {
+	gen := atomic.LoadInt64(&n.gen)
+	v, tags, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	if atomic.LoadInt64(&n.gen) == gen {
+		n.setLocked(key, v, ttl, tags)
+	}
+	n.mu.Unlock()
+	return v, nil
+}
+
+// cacheItem loadAllで保存する値と付けるタグ
+type cacheItem struct {
+	value interface{}
+	tags  []string
+}
+
+// loadAll loadで読んだキーと値をまとめて保存し、保存した数を返す。読み込み中に無効化された場合は保存しない
+func (n *cacheNamespace) loadAll(ctx context.Context, ttl time.Duration, load func(ctx context.Context) (map[string]cacheItem, error)) (int, error) {
+	gen := atomic.LoadInt64(&n.gen)
+	items, err := load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if atomic.LoadInt64(&n.gen) != gen {
+		return 0, nil
+	}
+	for key, item := range items {
+		n.setLocked(key, item.value, ttl, item.tags)
+	}
+	return len(items), nil
+}
+
+func (n *cacheNamespace) set(key string, value interface{}, ttl time.Duration, tags ...string) {
+	n.mu.Lock()
+	n.setLocked(key, value, ttl, tags)
+	n.mu.Unlock()
+}
+
+func (n *cacheNamespace) setLocked(key string, value interface{}, ttl time.Duration, tags []string) {
+	n.untag(key)
+	for _, tag := range tags {
+		keys, ok := n.tagKeys[tag]
+		if !ok {
+			keys = map[string]struct{}{}
+			n.tagKeys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	if len(tags) > 0 {
+		n.keyTags[key] = tags
+	}
+	n.store.Set(key, cacheEntry{value: value, freshUntil: time.Now().Add(ttl)}, ttl+n.staleWindow)
+}
+
+// delete キーを消す。invalidateと同じく世代を進め、消す前に始まった読み込みの値は保存させない
+func (n *cacheNamespace) delete(key string) {
+	n.mu.Lock()
+	atomic.AddInt64(&n.gen, 1)
+	n.untag(key)
+	n.mu.Unlock()
+
+	// storeのOnEvictedがmuを取るので、ロックを外してから消す
+	n.store.Delete(key)
+}
+
+// invalidate いずれかのタグが付いたキーを消し、消した数を返す
+func (n *cacheNamespace) invalidate(tags ...string) int {
+	n.mu.Lock()
+	atomic.AddInt64(&n.gen, 1)
+	keys := make([]string, 0)
+	for _, tag := range tags {
+		for key := range n.tagKeys[tag] {
+			keys = append(keys, key)
+			n.untag(key)
+		}
+	}
+	n.mu.Unlock()
+
+	// storeのOnEvictedがmuを取るので、ロックを外してから消す
+	for _, key := range keys {
+		n.store.Delete(key)
+	}
+	return len(keys)
+}
+
+func (n *cacheNamespace) flush() {
+	n.mu.Lock()
+	atomic.AddInt64(&n.gen, 1)
+	atomic.AddInt64(&n.evictions, int64(n.store.Len()))
+	n.store.Flush()
+	n.tagKeys = map[string]map[string]struct{}{}
+	n.keyTags = map[string][]string{}
+	n.mu.Unlock()
+}
+
+func (n *cacheNamespace) stats() CacheStats {
+	n.mu.Lock()
+	tags := len(n.tagKeys)
+	n.mu.Unlock()
+	return CacheStats{
+		Namespace: n.name,
+		Hits:      atomic.LoadInt64(&n.hits),
+		Misses:    atomic.LoadInt64(&n.misses),
+		Evictions: atomic.LoadInt64(&n.evictions),
+		Stale:     atomic.LoadInt64(&n.stale),
+		Coalesced: atomic.LoadInt64(&n.coalesced),
+		Items:     n.store.Len(),
+		Tags:      tags,
+	}
+}
+
+// evicted 期限切れや削除で消えたキーをタグの索引からも外す
+func (n *cacheNamespace) evicted(key string) {
+	atomic.AddInt64(&n.evictions, 1)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// 消えた直後に同じキーで保存し直された場合は新しい方のタグを残す
+	if _, ok := n.store.Get(key); !ok {
+		n.untag(key)
+	}
+}
+
+func (n *cacheNamespace) untag(key string) {
+	for _, tag := range n.keyTags[key] {
+		if keys, ok := n.tagKeys[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(n.tagKeys, tag)
+			}
+		}
+	}
+	delete(n.keyTags, key)
+}
+
+// cacheSet 同じ対象のキャッシュの名前空間をまとめて扱う
+type cacheSet []*cacheNamespace
+
+func (s cacheSet) invalidate(tags ...string) {
+	for _, n := range s {
+		n.invalidate(tags...)
+	}
+}
+
+func (s cacheSet) flush() {
+	for _, n := range s {
+		n.flush()
+	}
+}
+
+func (s cacheSet) stats() []CacheStats {
+	res := make([]CacheStats, 0, len(s))
+	for _, n := range s {
+		res = append(res, n.stats())
+	}
+	return res
+}
+
+func uniqueTags(tags []string) []string {
+	sort.Strings(tags)
+	res := tags[:0]
+	for i, tag := range tags {
+		if i == 0 || tag != tags[i-1] {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheNamespaceInvalidateByTag(t *testing.T) {
+	n := newCacheNamespace("test", newGoCacheStore(), 0)
+	n.set("a", 1, time.Minute, "chair:1", "kind:座椅子")
+	n.set("b", 2, time.Minute, "chair:2", "kind:座椅子")
+	n.set("c", 3, time.Minute, "chair:3")
+
+	if removed := n.invalidate("kind:座椅子", "chair:9"); removed != 2 {
+		t.Errorf("removed %v keys, want 2", removed)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := n.get(key); ok {
+			t.Errorf("%v was not invalidated", key)
+		}
+	}
+	if v, ok := n.get("c"); !ok || v != 3 {
+		t.Errorf("c = %v, %v, want the untagged value kept", v, ok)
+	}
+	// 消したキーのタグは索引からも外れる
+	if s := n.stats(); s.Tags != 1 || s.Items != 1 {
+		t.Errorf("stats %+v, want 1 tag and 1 item left", s)
+	}
+}
+
+func TestCacheNamespaceDiscardsLoadInvalidatedMidway(t *testing.T) {
+	n := newCacheNamespace("test", newGoCacheStore(), 0)
+	_, _, err := n.fetch(context.Background(), "a", time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		// 読んでいる間に更新された
+		n.invalidate("chair:1")
+		return 1, []string{"chair:1"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.get("a"); ok {
+		t.Error("a value loaded before the invalidation was cached")
+	}
+}
+
+func TestChairCacheInvalidatesAffectedSearches(t *testing.T) {
+	saved := chairCache
+	chairCache = newChairCache(newGoCacheStore, nil)
+	defer func() { chairCache = saved }()
+
+	before := Chair{ID: 1, Price: 5000, Height: 100, Width: 60, Depth: 60, Color: "黒", Kind: "ゲーミングチェア", Stock: 3}
+	after := before
+	after.Price = 20000
+	priceBefore := rangeTag("price", chairSearchCondition.Price, before.Price)
+	priceAfter := rangeTag("price", chairSearchCondition.Price, after.Price)
+	if priceBefore == priceAfter {
+		t.Fatalf("prices %v and %v fall in the same range", before.Price, after.Price)
+	}
+
+	ctx := context.Background()
+	pages := map[string][]string{
+		"before":    {priceBefore},
+		"after":     {priceAfter},
+		"unrelated": {"kind:座椅子"},
+	}
+	for key, tags := range pages {
+		if _, _, err := chairCache.FetchCount(ctx, key, tags, func(ctx context.Context) (int64, error) { return 1, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := chairCache.FetchDetail(ctx, 1, func(ctx context.Context) (Chair, error) { return before, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidateChairCache(ctx, before, after)
+
+	want := map[string]bool{"before": false, "after": false, "unrelated": true}
+	for key, kept := range want {
+		if _, ok := chairCache.count.get(key); ok != kept {
+			t.Errorf("count %v cached = %v, want %v", key, ok, kept)
+		}
+	}
+	if _, ok := chairCache.detail.get("1"); ok {
+		t.Error("the detail of the updated chair was kept")
+	}
+}
+
+func TestCacheNamespaceFetchLoadsOnce(t *testing.T) {
+	n := newCacheNamespace("test", newGoCacheStore(), 0)
+	var loads int64
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, []string, error) {
+		atomic.AddInt64(&loads, 1)
+		<-release
+		return 1, nil, nil
+	}
+
+	const callers = 8
+	var wg sync.WaitGroup
+	results := make([]interface{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, _, err := n.fetch(context.Background(), "a", time.Minute, load)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}(i)
+	}
+	waitFor(t, "the first load", func() bool { return atomic.LoadInt64(&loads) == 1 })
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("loaded %v times, want 1", loads)
+	}
+	for i, v := range results {
+		if v != 1 {
+			t.Errorf("caller %v got %v", i, v)
+		}
+	}
+	// 読み込みを待った呼び出しと、読み込みの後にキャッシュから返した呼び出しで残りを全て数える
+	if s := n.stats(); s.Coalesced+s.Hits != callers-1 {
+		t.Errorf("stats %+v, want %v coalesced or hits", s, callers-1)
+	}
+}
+
+func TestCacheNamespaceRefreshesStaleOnce(t *testing.T) {
+	n := newCacheNamespace("test", newGoCacheStore(), time.Minute)
+	// 期限切れでもstaleの期間内の値
+	n.set("a", 1, 0)
+
+	var loads int64
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, []string, error) {
+		atomic.AddInt64(&loads, 1)
+		<-release
+		return 2, nil, nil
+	}
+	for i := 0; i < 4; i++ {
+		v, hit, err := n.fetch(context.Background(), "a", time.Minute, load)
+		if err != nil || !hit || v != 1 {
+			t.Fatalf("fetch = %v, %v, %v, want the stale value", v, hit, err)
+		}
+	}
+	close(release)
+	waitFor(t, "the refreshed value", func() bool {
+		v, ok := n.get("a")
+		return ok && v == 2
+	})
+	if loads != 1 {
+		t.Errorf("refreshed %v times, want 1", loads)
+	}
+	if s := n.stats(); s.Stale != 4 {
+		t.Errorf("stale %v, want 4", s.Stale)
+	}
+}
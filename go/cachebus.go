@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	cacheOpInvalidate   = "invalidate"
+	cacheOpFlush        = "flush"
+	cacheOpSoldOut      = "soldOut"
+	cacheOpClearSoldOut = "clearSoldOut"
+)
+
+// cacheBusRetention この時間より古い無効化のログは消す
+const cacheBusRetention = 10 * time.Minute
+
+// cacheBusQueueSize 書き込みを待つ無効化の数。溢れたらリクエストの中で書く
+const cacheBusQueueSize = 1024
+
+// cacheBusTimeLayout created_atはノードで決めて書き、読んだ時と同じ文字列になるようにする
+const cacheBusTimeLayout = "2006-01-02 15:04:05.000000"
+
+// cacheBusTarget 他のノードから届いた無効化を自分のキャッシュだけに適用する
+type cacheBusTarget interface {
+	receive(op string, keys []string, version cacheVersion)
+	// published 自分の無効化を書けた行の版を渡す
+	published(version cacheVersion)
+	// resume 起動時に最後の無効化の版を渡す
+	resume(version cacheVersion)
+}
+
+//CacheInvalidation ノード間で共有する無効化のログ
+type CacheInvalidation struct {
+	ID        int64  `db:"id"`
+	Target    string `db:"target"`
+	Op        string `db:"op"`
+	Keys      string `db:"cache_keys"`
+	CreatedAt string `db:"created_at"`
+}
+
+// cacheVersion 無効化の行の位置。全てのノードで同じになるのでETagの版に使う。
+// idは/initializeでテーブルを作り直すと振り直されるので、created_atと組にする
+type cacheVersion struct {
+	id        int64
+	createdAt string
+}
+
+// cacheBus キャッシュの無効化を全てのアプリケーションサーバーに伝える。
+// 無効化はwithStateのcache_invalidationに書き、各ノードがポーリングして自分以外が書いたものを適用する。
+// 書き込みはリクエストを待たせないようにキューに入れ、1つのgoroutineが順に書く
+type cacheBus struct {
+	node    string
+	targets map[string]cacheBusTarget
+	lastID  int64
+	queue   chan CacheInvalidation
+}
+
+func newCacheBus() *cacheBus {
+	host, _ := os.Hostname()
+	b := &cacheBus{
+		node:    fmt.Sprintf("%s-%d", host, os.Getpid()),
+		targets: map[string]cacheBusTarget{},
+		queue:   make(chan CacheInvalidation, cacheBusQueueSize),
+	}
+	go b.writeQueued()
+	return b
+}
+
+func (b *cacheBus) register(target string, t cacheBusTarget) {
+	if b != nil {
+		b.targets[target] = t
+	}
+}
+
+// publish 他のノードに無効化を伝える。自分のキャッシュには呼び出し側で適用する。
+// キューに入れて戻り、キューが溢れている時だけctxでそのまま書く
+func (b *cacheBus) publish(ctx context.Context, target, op string, keys []string) {
+	if b == nil {
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		log.Errorf("failed to encode cache invalidation : %v", err)
+		return
+	}
+	ev := CacheInvalidation{Target: target, Op: op, Keys: string(keysJSON)}
+	select {
+	case b.queue <- ev:
+	default:
+		b.write(ctx, ev)
+	}
+}
+
+func (b *cacheBus) writeQueued() {
+	for ev := range b.queue {
+		b.write(context.Background(), ev)
+	}
+}
+
+// write 無効化を1行書き、書いた行の版を無効化したキャッシュに渡す
+func (b *cacheBus) write(ctx context.Context, ev CacheInvalidation) {
+	createdAt := time.Now().UTC().Format(cacheBusTimeLayout)
+	res, err := db.withState.ExecContext(ctx, "INSERT INTO cache_invalidation(node, target, op, cache_keys, created_at) VALUES(?,?,?,?,?)", b.node, ev.Target, ev.Op, ev.Keys, createdAt)
+	if err != nil {
+		log.Errorf("failed to publish cache invalidation : %v", err)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Errorf("failed to publish cache invalidation : %v", err)
+		return
+	}
+	if target, ok := b.targets[ev.Target]; ok {
+		target.published(cacheVersion{id: id, createdAt: createdAt})
+	}
+}
+
+// start 起動時点までのログは読み飛ばし、intervalごとに新しい無効化を適用する。
+// 各キャッシュの版は最後の無効化の行から始め、既に動いているノードと揃える
+func (b *cacheBus) start(interval time.Duration) {
+	if err := db.withState.Get(&b.lastID, "SELECT COALESCE(MAX(id), 0) FROM cache_invalidation"); err != nil {
+		log.Errorf("failed to load cache invalidation position : %v", err)
+	}
+	for name, target := range b.targets {
+		var ev CacheInvalidation
+		err := db.withState.Get(&ev, "SELECT id, created_at FROM cache_invalidation WHERE target = ? AND id <= ? ORDER BY id DESC LIMIT 1", name, b.lastID)
+		if err == nil {
+			target.resume(cacheVersion{id: ev.ID, createdAt: ev.CreatedAt})
+		} else if err != sql.ErrNoRows {
+			log.Errorf("failed to load cache version of %v : %v", name, err)
+		}
+	}
+	go func() {
+		purged := time.Now()
+		for range time.Tick(interval) {
+			b.poll()
+			if time.Since(purged) > time.Minute {
+				b.purge()
+				purged = time.Now()
+			}
+		}
+	}()
+}
+
+func (b *cacheBus) poll() {
+	var events []CacheInvalidation
+	err := db.withState.Select(&events, "SELECT id, target, op, cache_keys, created_at FROM cache_invalidation WHERE id > ? AND node <> ? ORDER BY id LIMIT 1000", b.lastID, b.node)
+	if err != nil {
+		log.Errorf("failed to poll cache invalidation : %v", err)
+		return
+	}
+	if len(events) == 0 {
+		b.detectReset()
+		return
+	}
+	for _, ev := range events {
+		b.lastID = ev.ID
+		target, ok := b.targets[ev.Target]
+		if !ok {
+			continue
+		}
+		var keys []string
+		if err := json.Unmarshal([]byte(ev.Keys), &keys); err != nil {
+			log.Errorf("invalid cache invalidation %v : %v", ev.ID, err)
+			continue
+		}
+		target.receive(ev.Op, keys, cacheVersion{id: ev.ID, createdAt: ev.CreatedAt})
+	}
+}
+
+// detectReset initializeでテーブルが作り直されるとidが振り直されるので、読んだ位置と版を戻して全て消す
+func (b *cacheBus) detectReset() {
+	var maxID int64
+	if err := db.withState.Get(&maxID, "SELECT COALESCE(MAX(id), 0) FROM cache_invalidation"); err != nil {
+		log.Errorf("failed to poll cache invalidation : %v", err)
+		return
+	}
+	if maxID >= b.lastID {
+		return
+	}
+	b.lastID = 0
+	for _, target := range b.targets {
+		target.receive(cacheOpFlush, nil, cacheVersion{})
+	}
+}
+
+func (b *cacheBus) purge() {
+	_, err := db.withState.Exec("DELETE FROM cache_invalidation WHERE created_at < ?", time.Now().Add(-cacheBusRetention).UTC().Format(cacheBusTimeLayout))
+	if err != nil {
+		log.Errorf("failed to purge cache invalidation : %v", err)
+	}
+}
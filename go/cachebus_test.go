@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestCacheBusAppliesInvalidationFromAnotherNode(t *testing.T) {
+	withTables(func(tables map[string]*fakeTable) {}, func(fake *fakeDB) {
+		busA, busB := newCacheBus(), newCacheBus()
+		busA.node, busB.node = "a", "b"
+		a := newEstateCache(newGoCacheStore, busA)
+		b := newEstateCache(newGoCacheStore, busB)
+
+		fetch := func() bool {
+			_, hit, err := b.FetchCount(context.Background(), "rent:0", []string{"rent:0"}, func(ctx context.Context) (int64, error) {
+				return 1, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return hit
+		}
+		fetch()
+		if !fetch() {
+			t.Fatal("b did not cache the count")
+		}
+
+		a.Invalidate(context.Background(), "rent:0")
+		waitFor(t, "a to publish the invalidation", func() bool {
+			return len(fake.executed("INSERT INTO cache_invalidation")) == 1
+		})
+		published := fake.executed("INSERT INTO cache_invalidation")[0]
+		if published[0] != "a" || published[1] != "estate" || published[2] != cacheOpInvalidate || published[3] != `["rent:0"]` {
+			t.Fatalf("published %v", published)
+		}
+
+		// bのポーリングで読まれるまではbのキャッシュは残る
+		if !fetch() {
+			t.Fatal("b dropped the count before polling")
+		}
+		fake.mu.Lock()
+		fake.tables["cache_invalidation"].rows = [][]driver.Value{
+			{int64(1), published[1], published[2], published[3], published[4]},
+		}
+		fake.mu.Unlock()
+		busB.poll()
+		if fetch() {
+			t.Error("b still serves the count invalidated on a")
+		}
+	})
+}
@@ -3,13 +3,14 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/csv"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"net/url"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
 	"github.com/newrelic/go-agent/v3/newrelic"
@@ -31,6 +32,20 @@ type Chair struct {
 	Stock       int64  `db:"stock" json:"-"`
 }
 
+// errChairSoldOut 詳細を読んだ椅子が売り切れていた
+var errChairSoldOut = errors.New("chair is sold out")
+
+// chairSoldOut 売り切れの椅子は存在しない椅子と同じ404で返し、codeで区別できるようにする
+func chairSoldOut(id int64) *APIError {
+	return newAPIError(http.StatusNotFound, errCodeSoldOut, fmt.Sprintf("chair %v is sold out", id), nil)
+}
+
+//BuyChairRequest 椅子の購入
+type BuyChairRequest struct {
+	ID    int64  `json:"-" param:"id" validate:"required,min=1"`
+	Email string `json:"email" validate:"required,email"`
+}
+
 type ChairSearchResponse struct {
 	Count  int64   `json:"count"`
 	Chairs []Chair `json:"chairs"`
@@ -53,175 +68,228 @@ type ChairSearchCondition struct {
 func getChairDetail(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.Echo().Logger.Errorf("Request parameter \"id\" parse error : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+	id := c.Get(requestKey).(*IDRequest).ID
+	if chairCache.SoldOut(id) {
+		return chairSoldOut(id)
 	}
-	if _, ok := stockCache.Get(strconv.Itoa(id)); ok {
-		time.Sleep(time.Millisecond * cacheSleep)
-		return c.NoContent(http.StatusNotFound)
+
+	etag, modified := chairCache.Version()
+	if notModified(c, etag, modified, chairCacheControl) {
+		return c.NoContent(http.StatusNotModified)
 	}
 
-	chair := Chair{}
-	cc, ok := chairCache.Get(strconv.Itoa(id))
-	if ok {
-		time.Sleep(time.Millisecond * cacheSleep)
-		chair, _ = cc.(Chair)
-	} else {
-		query := `SELECT * FROM chair WHERE id = ?`
-		err = db.withState.GetContext(ctx, &chair, query, id)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.Echo().Logger.Infof("requested id's chair not found : %v", id)
-				return c.NoContent(http.StatusNotFound)
-			}
-			c.Echo().Logger.Errorf("Failed to get the chair from id : %v", err)
-			return c.NoContent(http.StatusInternalServerError)
-		} else if chair.Stock <= 0 {
-			stockCache.Add(strconv.Itoa(id), true, 5*time.Minute)
-			c.Echo().Logger.Infof("requested id's chair is sold out : %v", id)
-			return c.NoContent(http.StatusNotFound)
+	chair, hit, err := chairCache.FetchDetail(ctx, id, func(ctx context.Context) (Chair, error) {
+		return loadChairDetail(ctx, id)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("chair %v not found", id)
+		}
+		if err == errChairSoldOut {
+			return chairSoldOut(id)
 		}
-		chairCache.Add(strconv.Itoa(id), chair, time.Minute*1)
+		return internalError("Failed to get the chair from id", err)
 	}
+	setCacheStatus(c, hit)
 
 	return c.JSON(http.StatusOK, chair)
 }
 
-func postChair(c echo.Context) error {
+//ChairDetailsResponse まとめて読んだ椅子。見つからないidと売り切れのidは分けて返す
+type ChairDetailsResponse struct {
+	Chairs   []Chair `json:"chairs"`
+	NotFound []int64 `json:"notFound"`
+	SoldOut  []int64 `json:"soldOut"`
+}
+
+// getChairDetails ids=の椅子をまとめて返す。キャッシュに無い椅子は1回のクエリで読み、idsの順に並べる
+func getChairDetails(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	header, err := c.FormFile("chairs")
-	if err != nil {
-		c.Logger().Errorf("failed to get form file: %v", err)
-		return c.NoContent(http.StatusBadRequest)
+	ids := c.Get(requestKey).(*IDsRequest).unique()
+
+	etag, modified := chairCache.Version()
+	if notModified(c, etag, modified, chairCacheControl) {
+		return c.NoContent(http.StatusNotModified)
 	}
-	f, err := header.Open()
-	if err != nil {
-		c.Logger().Errorf("failed to open form file: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+
+	soldOut := map[int64]bool{}
+	candidates := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if chairCache.SoldOut(id) {
+			soldOut[id] = true
+			continue
+		}
+		candidates = append(candidates, id)
 	}
-	defer f.Close()
-	records, err := csv.NewReader(f).ReadAll()
+
+	chairs, hit, err := chairCache.FetchDetails(ctx, candidates, func(ctx context.Context, ids []int64) ([]Chair, error) {
+		inStock, soldOutIDs, err := loadChairDetails(ctx, ids)
+		for _, id := range soldOutIDs {
+			soldOut[id] = true
+		}
+		return inStock, err
+	})
 	if err != nil {
-		c.Logger().Errorf("failed to read csv: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return internalError("Failed to get the chairs from ids", err)
+	}
+	setCacheStatus(c, hit)
+
+	res := ChairDetailsResponse{Chairs: []Chair{}, NotFound: []int64{}, SoldOut: []int64{}}
+	for _, id := range ids {
+		if chair, ok := chairs[id]; ok {
+			res.Chairs = append(res.Chairs, chair)
+		} else if soldOut[id] {
+			res.SoldOut = append(res.SoldOut, id)
+		} else {
+			res.NotFound = append(res.NotFound, id)
+		}
 	}
+	return c.JSON(http.StatusOK, res)
+}
 
-	tx1, err := db.withState.Begin()
+// loadChairDetails idsの椅子を1回のクエリで読み、在庫のある椅子と売り切れのidに分ける。売り切れはloadChairDetailと同じく覚えておく
+func loadChairDetails(ctx context.Context, ids []int64) ([]Chair, []int64, error) {
+	query, params, err := sqlx.In(`SELECT * FROM chair WHERE id IN (?)`, ids)
 	if err != nil {
-		c.Logger().Errorf("failed to begin tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, nil, err
 	}
-	defer tx1.Rollback()
-	tx2, err := db.noState.Begin()
-	if err != nil {
-		c.Logger().Errorf("failed to begin tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	defer tx2.Rollback()
-
-	var wg sync.WaitGroup
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	limit := make(chan struct{}, 2)
-	for _, row := range records {
-		wg.Add(1)
-		limit <- struct{}{}
-		go func(row []string) {
-			defer func() {
-				wg.Done()
-				<-limit
-			}()
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			rm := RecordMapper{Record: row}
-			id := rm.NextInt()
-			name := rm.NextString()
-			description := rm.NextString()
-			thumbnail := rm.NextString()
-			price := rm.NextInt()
-			height := rm.NextInt()
-			width := rm.NextInt()
-			depth := rm.NextInt()
-			color := rm.NextString()
-			features := rm.NextString()
-			kind := rm.NextString()
-			popularity := rm.NextInt()
-			stock := rm.NextInt()
-			if err := rm.Err(); err != nil {
-				c.Logger().Errorf("failed to read record: %v", err)
-				cancel()
-			}
-			_, err := tx1.ExecContext(ctx, "INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)", id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock)
-			if err != nil {
-				c.Logger().Errorf("failed to insert chair: %v", err)
-				panic(err)
-			}
-			_, err = tx2.ExecContext(ctx, "INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)", id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock)
-			if err != nil {
-				c.Logger().Errorf("failed to insert chair: %v", err)
-				panic(err)
-			}
-			stockCache.Delete(strconv.Itoa(id))
-		}(row)
-	}
-	wg.Wait()
-	if ctx.Err() != nil {
-		return c.NoContent(http.StatusBadRequest)
-	}
-	if err := tx1.Commit(); err != nil {
-		c.Logger().Errorf("failed to commit tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	if err := tx2.Commit(); err != nil {
-		c.Logger().Errorf("failed to commit tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	chairCache.Flush()
-	return c.NoContent(http.StatusCreated)
+	var chairs []Chair
+	if err := db.withState.SelectContext(ctx, &chairs, query, params...); err != nil {
+		return nil, nil, err
+	}
+	inStock := make([]Chair, 0, len(chairs))
+	var soldOut []int64
+	for _, chair := range chairs {
+		if chair.Stock <= 0 {
+			chairCache.RememberSoldOut(chair.ID)
+			soldOut = append(soldOut, chair.ID)
+			continue
+		}
+		inStock = append(inStock, chair)
+	}
+	return inStock, soldOut, nil
+}
+
+// loadChairDetail 在庫のある椅子を読む。売り切れならそれを覚えてerrChairSoldOutを返す
+func loadChairDetail(ctx context.Context, id int64) (Chair, error) {
+	chair := Chair{}
+	query := `SELECT * FROM chair WHERE id = ?`
+	if err := db.withState.GetContext(ctx, &chair, query, id); err != nil {
+		return chair, err
+	}
+	if chair.Stock <= 0 {
+		chairCache.RememberSoldOut(chair.ID)
+		return chair, errChairSoldOut
+	}
+	return chair, nil
+}
+
+var chairImportTable = importTable{
+	name:    "chair",
+	columns: []string{"id", "name", "description", "thumbnail", "price", "height", "width", "depth", "color", "features", "kind", "popularity", "stock"},
+	fields:  []string{"id", "name", "description", "thumbnail", "price", "height", "width", "depth", "color", "features", "kind", "popularity", "stock"},
+	parse: func(r *recordReader) importRow {
+		id := r.Int("id", 0)
+		name := r.String("name", 64)
+		description := r.String("description", 4096)
+		thumbnail := r.String("thumbnail", 128)
+		price := r.Int("price", 0)
+		height := r.Int("height", 0)
+		width := r.Int("width", 0)
+		depth := r.Int("depth", 0)
+		color := r.OneOf("color", chairSearchCondition.Color.List)
+		features := r.Features("features", 64, chairSearchCondition.Feature.List)
+		kind := r.OneOf("kind", chairSearchCondition.Kind.List)
+		popularity := r.Int("popularity", 0)
+		stock := r.Int("stock", 0)
+		return importRow{
+			ID:     id,
+			Values: []interface{}{id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock},
+		}
+	},
+	afterCommit: afterChairImport,
+}
+
+func postChair(c echo.Context) error {
+	return handleImport(c, chairImportTable, "chairs")
+}
+
+func afterChairImport(ctx context.Context, mode string, result importResult) {
+	// 一括の取り込みは影響する範囲が広いので全て消す
+	chairCache.Flush(ctx)
+	estateCache.Invalidate(ctx, recommendTag)
+	go notifyRestock(context.Background(), nil)
+	// 置き換えや削除で消えた椅子もあるので、購読されている全ての椅子を読み直す
+	go stockStream.refresh(context.Background(), nil)
 }
 
 func searchChairs(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	conditions := make([]string, 0)
-	params := make([]interface{}, 0)
-	ck := ""
-
-	page, err := strconv.Atoi(c.QueryParam("page"))
+	s, err := newChairSearch(c.Get(requestKey).(*ChairSearchRequest))
 	if err != nil {
-		c.Logger().Infof("Invalid format page parameter : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+		return err
 	}
 
-	perPage, err := strconv.Atoi(c.QueryParam("perPage"))
+	res, hit, err := s.fetch(ctx)
 	if err != nil {
-		c.Logger().Infof("Invalid format perPage parameter : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+		return internalError("searchChairs DB execution error", err)
 	}
+	if s.page == 0 {
+		searchTraffic.record("chair", s.query.pageKey(s.page, s.perPage), c.QueryParams())
+	}
+	setCacheStatus(c, hit)
 
-	if page == 0 {
-		r, ok := chairCache.Get(c.Request().URL.RawQuery)
-		if ok {
-			time.Sleep(time.Millisecond * cacheSleep)
-			return c.JSON(http.StatusOK, r)
-		}
+	return c.JSON(http.StatusOK, res)
+}
+
+//ChairSearchRequest 椅子の検索のクエリパラメータ
+type ChairSearchRequest struct {
+	Page          int      `query:"page" validate:"required,min=0"`
+	PerPage       int      `query:"perPage" validate:"required,min=1,max=100"`
+	PriceRangeID  string   `query:"priceRangeId" validate:"range=chair.price"`
+	HeightRangeID string   `query:"heightRangeId" validate:"range=chair.height"`
+	WidthRangeID  string   `query:"widthRangeId" validate:"range=chair.width"`
+	DepthRangeID  string   `query:"depthRangeId" validate:"range=chair.depth"`
+	Kind          string   `query:"kind" validate:"enum=chair.kind"`
+	Color         string   `query:"color" validate:"enum=chair.color"`
+	Features      []string `query:"features" validate:"enum=chair.feature"`
+}
+
+// chairSearch 検索のクエリパラメータを解釈したもの。ウォームアップでも同じ検索を組み立てる
+type chairSearch struct {
+	query     searchQuery
+	condition string
+	params    []interface{}
+	page      int
+	perPage   int
+}
+
+// parseChairSearch ウォームアップで覚えていたクエリから検索を組み立て直す
+func parseChairSearch(v url.Values) (*chairSearch, error) {
+	req := &ChairSearchRequest{}
+	if err := decodeValues(v, "query", req); err != nil {
+		return nil, err
+	}
+	if err := validateRequest(req); err != nil {
+		return nil, err
 	}
+	return newChairSearch(req)
+}
+
+func newChairSearch(req *ChairSearchRequest) (*chairSearch, error) {
+	conditions := make([]string, 0)
+	params := make([]interface{}, 0)
+	q := searchQuery{}
 
-	if c.QueryParam("priceRangeId") != "" {
-		ck += c.QueryParam("priceRangeId")
-		chairPrice, err := getRange(chairSearchCondition.Price, c.QueryParam("priceRangeId"))
+	if req.PriceRangeID != "" {
+		chairPrice, err := getRange(chairSearchCondition.Price, req.PriceRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("priceRangeID invalid, %v : %v", c.QueryParam("priceRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("priceRangeId", err)
 		}
 
+		q.addRange("price", chairPrice)
 		if chairPrice.Min != -1 {
 			conditions = append(conditions, "price >= ?")
 			params = append(params, chairPrice.Min)
@@ -232,14 +300,13 @@ func searchChairs(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("heightRangeId") != "" {
-		ck += c.QueryParam("heightRangeId")
-		chairHeight, err := getRange(chairSearchCondition.Height, c.QueryParam("heightRangeId"))
+	if req.HeightRangeID != "" {
+		chairHeight, err := getRange(chairSearchCondition.Height, req.HeightRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("heightRangeIf invalid, %v : %v", c.QueryParam("heightRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("heightRangeId", err)
 		}
 
+		q.addRange("height", chairHeight)
 		if chairHeight.Min != -1 {
 			conditions = append(conditions, "height >= ?")
 			params = append(params, chairHeight.Min)
@@ -250,14 +317,13 @@ func searchChairs(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("widthRangeId") != "" {
-		ck += c.QueryParam("widthRangeId")
-		chairWidth, err := getRange(chairSearchCondition.Width, c.QueryParam("widthRangeId"))
+	if req.WidthRangeID != "" {
+		chairWidth, err := getRange(chairSearchCondition.Width, req.WidthRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("widthRangeID invalid, %v : %v", c.QueryParam("widthRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("widthRangeId", err)
 		}
 
+		q.addRange("width", chairWidth)
 		if chairWidth.Min != -1 {
 			conditions = append(conditions, "width >= ?")
 			params = append(params, chairWidth.Min)
@@ -268,14 +334,13 @@ func searchChairs(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("depthRangeId") != "" {
-		ck += c.QueryParam("depthRangeId")
-		chairDepth, err := getRange(chairSearchCondition.Depth, c.QueryParam("depthRangeId"))
+	if req.DepthRangeID != "" {
+		chairDepth, err := getRange(chairSearchCondition.Depth, req.DepthRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("depthRangeId invalid, %v : %v", c.QueryParam("depthRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("depthRangeId", err)
 		}
 
+		q.addRange("depth", chairDepth)
 		if chairDepth.Min != -1 {
 			conditions = append(conditions, "depth >= ?")
 			params = append(params, chairDepth.Min)
@@ -286,103 +351,102 @@ func searchChairs(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("kind") != "" {
-		ck += c.QueryParam("kind")
+	if req.Kind != "" {
+		q.add("kind", req.Kind)
 		conditions = append(conditions, "kind = ?")
-		params = append(params, c.QueryParam("kind"))
+		params = append(params, req.Kind)
 	}
 
-	if c.QueryParam("color") != "" {
-		ck += c.QueryParam("color")
+	if req.Color != "" {
+		q.add("color", req.Color)
 		conditions = append(conditions, "color = ?")
-		params = append(params, c.QueryParam("color"))
+		params = append(params, req.Color)
 	}
 
-	if c.QueryParam("features") != "" {
-		ck += c.QueryParam("features")
-		for _, f := range strings.Split(c.QueryParam("features"), ",") {
+	if len(req.Features) > 0 {
+		features := req.Features
+		q.addFeatures(features)
+		for _, f := range features {
 			conditions = append(conditions, "features LIKE CONCAT('%', ?, '%')")
 			params = append(params, f)
 		}
 	}
 
 	if len(conditions) == 0 {
-		c.Echo().Logger.Infof("Search condition not found")
-		return c.NoContent(http.StatusBadRequest)
+		return nil, errSearchConditionNotFound
 	}
 
 	conditions = append(conditions, "stock > 0")
 
+	return &chairSearch{
+		query:     q,
+		condition: strings.Join(conditions, " AND "),
+		params:    params,
+		page:      req.Page,
+		perPage:   req.PerPage,
+	}, nil
+}
+
+// fetch 1ページ目だけをキャッシュし、同じ検索の同時のキャッシュミスは1回の読み込みにまとめる
+func (s *chairSearch) fetch(ctx context.Context) (ChairSearchResponse, bool, error) {
+	if s.page == 0 {
+		return chairCache.FetchPage(ctx, s.query.pageKey(s.page, s.perPage), s.query.tags(chairSearchCondition.Feature.List), s.load)
+	}
+	res, err := s.load(ctx)
+	return res, false, err
+}
+
+func (s *chairSearch) load(ctx context.Context) (ChairSearchResponse, error) {
 	searchQuery := "SELECT * FROM chair WHERE "
 	countQuery := "SELECT COUNT(*) FROM chair WHERE "
-	searchCondition := strings.Join(conditions, " AND ")
 	limitOffset := " ORDER BY popularity DESC, id ASC LIMIT ? OFFSET ?"
 
 	var res ChairSearchResponse
-	cc, ok := chairCache.Get(ck)
-	if ok {
-		time.Sleep(time.Millisecond * cacheSleep)
-		s, _ := cc.(string)
-		res.Count, _ = strconv.ParseInt(s, 10, 64)
-	} else {
-		err = db.withState.GetContext(ctx, &res.Count, countQuery+searchCondition, params...)
-		if err != nil {
-			c.Logger().Errorf("searchChairs DB execution error : %v", err)
-			return c.NoContent(http.StatusInternalServerError)
-		}
-		_ = chairCache.Add(ck, strconv.FormatInt(res.Count, 10), time.Minute*1)
+	count, _, err := chairCache.FetchCount(ctx, s.query.countKey(), s.query.tags(chairSearchCondition.Feature.List), func(ctx context.Context) (int64, error) {
+		var count int64
+		err := db.withState.GetContext(ctx, &count, countQuery+s.condition, s.params...)
+		return count, err
+	})
+	if err != nil {
+		return res, err
 	}
+	res.Count = count
 
 	chairs := []Chair{}
-	params = append(params, perPage, page*perPage)
-	err = db.withState.SelectContext(ctx, &chairs, searchQuery+searchCondition+limitOffset, params...)
+	params := append(append([]interface{}{}, s.params...), s.perPage, s.page*s.perPage)
+	err = db.withState.SelectContext(ctx, &chairs, searchQuery+s.condition+limitOffset, params...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return c.JSON(http.StatusOK, ChairSearchResponse{Count: 0, Chairs: []Chair{}})
+			return ChairSearchResponse{Count: 0, Chairs: []Chair{}}, nil
 		}
-		c.Logger().Errorf("searchChairs DB execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return res, err
 	}
-
 	res.Chairs = chairs
-
-	if page == 0 {
-		_ = chairCache.Add(c.Request().URL.RawQuery, res, time.Minute*3)
-	}
-
-	return c.JSON(http.StatusOK, res)
+	return res, nil
 }
 
+// buyChair v1は本文を返さない。注文のidはbuyChairV2で返す
 func buyChair(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	m := echo.Map{}
-	if err := c.Bind(&m); err != nil {
-		c.Echo().Logger.Infof("post buy chair failed : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+	if _, err := purchaseChair(ctx, c.Get(requestKey).(*BuyChairRequest)); err != nil {
+		return err
 	}
 
-	_, ok := m["email"].(string)
-	if !ok {
-		c.Echo().Logger.Info("post buy chair failed : email not found in request body")
-		return c.NoContent(http.StatusBadRequest)
-	}
+	return c.NoContent(http.StatusOK)
+}
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.Echo().Logger.Infof("post buy chair failed : %v", err)
-		return c.NoContent(http.StatusBadRequest)
-	}
+// purchaseChair 在庫を1つ減らして注文を作り、注文のidを返す。HTTPとgRPCの両方から使う
+func purchaseChair(ctx context.Context, req *BuyChairRequest) (int64, error) {
+	id := req.ID
 
-	if _, ok := stockCache.Get(strconv.Itoa(id)); ok {
-		time.Sleep(time.Millisecond * cacheSleep)
-		return c.NoContent(http.StatusNotFound)
+	if chairCache.SoldOut(id) {
+		return 0, chairSoldOut(id)
 	}
 
 	tx, err := db.withState.Beginx()
 	if err != nil {
-		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return 0, internalError("failed to create transaction", err)
 	}
 	defer tx.Rollback()
 
@@ -390,57 +454,72 @@ func buyChair(c echo.Context) error {
 	err = tx.QueryRowxContext(ctx, "SELECT * FROM chair WHERE id = ? AND stock > 0 FOR UPDATE", id).StructScan(&chair)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.Echo().Logger.Infof("buyChair chair id \"%v\" not found", id)
-			return c.NoContent(http.StatusNotFound)
+			return 0, notFound("chair %v not found", id)
 		}
-		c.Echo().Logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return 0, internalError("DB Execution Error: on getting a chair by id", err)
 	}
 
 	_, err = tx.ExecContext(ctx, "UPDATE chair SET stock = stock - 1 WHERE id = ?", id)
 	if err != nil {
-		c.Echo().Logger.Errorf("chair stock update failed : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return 0, internalError("chair stock update failed", err)
 	}
-	if chair.Stock == 1 {
-		stockCache.Add(strconv.Itoa(id), true, 5*time.Minute)
+	res, err := tx.ExecContext(ctx, "INSERT INTO chair_order(chair_id, email, created_at) VALUES(?,?,?)", id, req.Email, time.Now())
+	if err != nil {
+		return 0, internalError("chair order insert failed", err)
+	}
+	orderID, err := res.LastInsertId()
+	if err != nil {
+		return 0, internalError("chair order insert failed", err)
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		c.Echo().Logger.Errorf("transaction commit error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return 0, internalError("transaction commit error", err)
+	}
+	if chair.Stock == 1 {
+		// 売り切れた椅子を含みうる件数・検索結果・一覧だけを消す
+		chairCache.SetSoldOut(ctx, chair.ID)
+		chairCache.Invalidate(ctx, chairTags(chair)...)
+	} else {
+		// v2は在庫数も返すので、詳細だけでなくこの椅子を含む検索結果と一覧も消す
+		chairCache.Invalidate(ctx, chairIDTag(chair.ID))
 	}
-	chairCache.Flush()
+	stockStream.publish(newStockEvent(chair.ID, chair.Stock-1))
 
-	return c.NoContent(http.StatusOK)
+	return orderID, nil
 }
 
 func getChairSearchCondition(c echo.Context) error {
+	if notModified(c, chairSearchConditionETag, startedAt, conditionCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
 	return c.JSON(http.StatusOK, chairSearchCondition)
 }
 
 func getLowPricedChair(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	var chairs []Chair
-	l, ok := chairCache.Get("LowPrice")
-	if ok {
-		time.Sleep(time.Millisecond * cacheSleep)
-		chairs = l.([]Chair)
-	} else {
-		query := `SELECT * FROM chair WHERE stock > 0 ORDER BY price ASC, id ASC LIMIT ?`
-		err := db.withState.SelectContext(ctx, &chairs, query, Limit)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.Logger().Error("getLowPricedChair not found")
-				return c.JSON(http.StatusOK, ChairListResponse{[]Chair{}})
-			}
-			c.Logger().Errorf("getLowPricedChair DB execution error : %v", err)
-			return c.NoContent(http.StatusInternalServerError)
+	etag, modified := chairCache.Version()
+	if notModified(c, etag, modified, chairCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	chairs, hit, err := chairCache.FetchLowPriced(ctx, loadLowPricedChairs)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Logger().Error("getLowPricedChair not found")
+			return c.JSON(http.StatusOK, ChairListResponse{[]Chair{}})
 		}
-		chairCache.Add("LowPrice", chairs, time.Minute)
+		return internalError("getLowPricedChair DB execution error", err)
 	}
+	setCacheStatus(c, hit)
 
 	return c.JSON(http.StatusOK, ChairListResponse{Chairs: chairs})
 }
+
+func loadLowPricedChairs(ctx context.Context) ([]Chair, error) {
+	var chairs []Chair
+	query := `SELECT * FROM chair WHERE stock > 0 ORDER BY price ASC, id ASC LIMIT ?`
+	err := db.withState.SelectContext(ctx, &chairs, query, Limit)
+	return chairs, err
+}
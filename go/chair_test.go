@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGetChairDetailsMixedIDs(t *testing.T) {
+	saved := chairCache
+	chairCache = newChairCache(newGoCacheStore, nil)
+	defer func() { chairCache = saved }()
+
+	withTables(func(tables map[string]*fakeTable) {
+		chairs := tables["chair"]
+		chairs.rows = append(chairs.rows,
+			[]driver.Value{int64(2), "座椅子", "売り切れ", "/images/chair/2.png", int64(3000), int64(50), int64(50), int64(50), "白", "", "座椅子", int64(10), int64(0)},
+			[]driver.Value{int64(3), "オフィスチェア", "疲れない", "/images/chair/3.png", int64(8000), int64(110), int64(60), int64(60), "黒", "", "エルゴノミクス", int64(20), int64(1)},
+		)
+	}, func(fake *fakeDB) {
+		e := newEcho(nil)
+		// 2回目は売り切れと在庫のある椅子をキャッシュから返す
+		for _, pass := range []string{"first", "cached"} {
+			rec := serveRequest(e, http.MethodGet, "/api/chair?ids=3,9,2,1,3", "", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%v: status %v: %s", pass, rec.Code, rec.Body.String())
+			}
+			var res ChairDetailsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Chairs) != 2 || res.Chairs[0].ID != 3 || res.Chairs[1].ID != 1 {
+				t.Errorf("%v: chairs %+v, want 3 and 1 in the requested order", pass, res.Chairs)
+			}
+			if len(res.NotFound) != 1 || res.NotFound[0] != 9 {
+				t.Errorf("%v: notFound %v, want [9]", pass, res.NotFound)
+			}
+			if len(res.SoldOut) != 1 || res.SoldOut[0] != 2 {
+				t.Errorf("%v: soldOut %v, want [2]", pass, res.SoldOut)
+			}
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lowPricedTag 安い順の一覧に新しく入りうる変更で消すタグ
+const lowPricedTag = "lowPriced"
+
+// soldOutTTL 売り切れを覚えておく時間
+const soldOutTTL = 5 * time.Minute
+
+// ChairCache 椅子のキャッシュ。詳細・件数・検索結果の1ページ目・安い順の一覧・売り切れの名前空間を持つ
+type ChairCache struct {
+	detail    *cacheNamespace
+	count     *cacheNamespace
+	page      *cacheNamespace
+	lowPriced *cacheNamespace
+	soldOut   *cacheNamespace
+	all       cacheSet
+	bus       *cacheBus
+	version   *resourceVersion
+}
+
+func newChairCache(newStore func() CacheStore, bus *cacheBus) *ChairCache {
+	c := &ChairCache{
+		detail:    newCacheNamespace("chair.detail", newStore(), cacheStaleWindow),
+		count:     newCacheNamespace("chair.count", newStore(), cacheStaleWindow),
+		page:      newCacheNamespace("chair.page", newStore(), cacheStaleWindow),
+		lowPriced: newCacheNamespace("chair.lowPriced", newStore(), cacheStaleWindow),
+		soldOut:   newCacheNamespace("chair.soldOut", newStore(), 0),
+	}
+	c.all = cacheSet{c.detail, c.count, c.page, c.lowPriced, c.soldOut}
+	c.bus = bus
+	c.version = newResourceVersion("chair")
+	bus.register("chair", c)
+	return c
+}
+
+// FetchDetail キャッシュに無ければloadで読む。同じ椅子の同時の読み込みは1回にまとめる
+func (c *ChairCache) FetchDetail(ctx context.Context, id int64, load func(ctx context.Context) (Chair, error)) (Chair, bool, error) {
+	v, hit, err := c.detail.fetch(ctx, strconv.FormatInt(id, 10), time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		chair, err := load(ctx)
+		return chair, []string{chairIDTag(id)}, err
+	})
+	if err != nil {
+		return Chair{}, hit, err
+	}
+	return v.(Chair), hit, nil
+}
+
+// FetchDetails キャッシュに無い椅子だけをloadでまとめて読む。loadが返さなかったidは結果に含めない。
+// hitは全ての椅子をキャッシュから返したかどうか
+func (c *ChairCache) FetchDetails(ctx context.Context, ids []int64, load func(ctx context.Context, ids []int64) ([]Chair, error)) (map[int64]Chair, bool, error) {
+	res := make(map[int64]Chair, len(ids))
+	missing := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if v, ok := c.detail.get(strconv.FormatInt(id, 10)); ok {
+			res[id] = v.(Chair)
+			continue
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return res, true, nil
+	}
+
+	_, err := c.detail.loadAll(ctx, time.Minute, func(ctx context.Context) (map[string]cacheItem, error) {
+		chairs, err := load(ctx, missing)
+		items := make(map[string]cacheItem, len(chairs))
+		for _, chair := range chairs {
+			res[chair.ID] = chair
+			items[strconv.FormatInt(chair.ID, 10)] = cacheItem{value: chair, tags: []string{chairIDTag(chair.ID)}}
+		}
+		return items, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return res, false, nil
+}
+
+// FetchCount tagsには検索条件のタグを渡す
+func (c *ChairCache) FetchCount(ctx context.Context, key string, tags []string, load func(ctx context.Context) (int64, error)) (int64, bool, error) {
+	v, hit, err := c.count.fetch(ctx, key, time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		n, err := load(ctx)
+		return n, tags, err
+	})
+	if err != nil {
+		return 0, hit, err
+	}
+	return v.(int64), hit, nil
+}
+
+// FetchPage tagsには検索条件のタグを渡す。含まれる椅子のタグはここで足す
+func (c *ChairCache) FetchPage(ctx context.Context, key string, tags []string, load func(ctx context.Context) (ChairSearchResponse, error)) (ChairSearchResponse, bool, error) {
+	v, hit, err := c.page.fetch(ctx, key, 3*time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		res, err := load(ctx)
+		all := append([]string{}, tags...)
+		for _, chair := range res.Chairs {
+			all = append(all, chairIDTag(chair.ID))
+		}
+		return res, all, err
+	})
+	if err != nil {
+		return ChairSearchResponse{}, hit, err
+	}
+	return v.(ChairSearchResponse), hit, nil
+}
+
+func (c *ChairCache) FetchLowPriced(ctx context.Context, load func(ctx context.Context) ([]Chair, error)) ([]Chair, bool, error) {
+	v, hit, err := c.lowPriced.fetch(ctx, "", time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		chairs, err := load(ctx)
+		tags := []string{lowPricedTag}
+		for _, chair := range chairs {
+			tags = append(tags, chairIDTag(chair.ID))
+		}
+		return chairs, tags, err
+	})
+	if err != nil {
+		return nil, hit, err
+	}
+	return v.([]Chair), hit, nil
+}
+
+func (c *ChairCache) SoldOut(id int64) bool {
+	_, ok := c.soldOut.get(strconv.FormatInt(id, 10))
+	return ok
+}
+
+func (c *ChairCache) SetSoldOut(ctx context.Context, id int64) {
+	c.update(ctx, cacheOpSoldOut, strconv.FormatInt(id, 10))
+}
+
+// RememberSoldOut DBから読んで分かった売り切れを覚える。他のノードも同じDBを読むので伝えない
+func (c *ChairCache) RememberSoldOut(id int64) {
+	c.apply(cacheOpSoldOut, []string{strconv.FormatInt(id, 10)})
+}
+
+// LoadSoldOut loadで読んだ売り切れのidをまとめて覚え、覚えた数を返す。ウォームアップで使う
+func (c *ChairCache) LoadSoldOut(ctx context.Context, load func(ctx context.Context) ([]int64, error)) (int, error) {
+	return c.soldOut.loadAll(ctx, soldOutTTL, func(ctx context.Context) (map[string]cacheItem, error) {
+		ids, err := load(ctx)
+		items := make(map[string]cacheItem, len(ids))
+		for _, id := range ids {
+			items[strconv.FormatInt(id, 10)] = cacheItem{value: true}
+		}
+		return items, err
+	})
+}
+
+func (c *ChairCache) ClearSoldOut(ctx context.Context, id int64) {
+	c.update(ctx, cacheOpClearSoldOut, strconv.FormatInt(id, 10))
+}
+
+// Invalidate いずれかのタグが付いた全ての名前空間のキーを消す
+func (c *ChairCache) Invalidate(ctx context.Context, tags ...string) {
+	c.update(ctx, cacheOpInvalidate, tags...)
+}
+
+func (c *ChairCache) Flush(ctx context.Context) {
+	c.update(ctx, cacheOpFlush)
+}
+
+// update 自分のキャッシュに適用し、他のノードにも伝える。
+// 伝えるログは後から書くので、書けるまではこのノードだけの版にし、書けたら行の版にする
+func (c *ChairCache) update(ctx context.Context, op string, keys ...string) {
+	c.apply(op, keys)
+	c.version.bump()
+	c.bus.publish(ctx, "chair", op, keys)
+}
+
+func (c *ChairCache) published(version cacheVersion) {
+	c.version.set(version)
+}
+
+func (c *ChairCache) receive(op string, keys []string, version cacheVersion) {
+	c.apply(op, keys)
+	c.version.set(version)
+	relayStockChange(op, keys)
+}
+
+func (c *ChairCache) resume(version cacheVersion) {
+	c.version.set(version)
+}
+
+func (c *ChairCache) apply(op string, keys []string) {
+	switch op {
+	case cacheOpInvalidate:
+		c.all.invalidate(keys...)
+	case cacheOpFlush:
+		c.all.flush()
+	case cacheOpSoldOut:
+		for _, id := range keys {
+			c.soldOut.set(id, true, soldOutTTL)
+		}
+	case cacheOpClearSoldOut:
+		for _, id := range keys {
+			c.soldOut.delete(id)
+		}
+	}
+}
+
+// Version ETagに使う版。キャッシュを無効化する更新のたびに変わる
+func (c *ChairCache) Version() (string, time.Time) {
+	return c.version.current()
+}
+
+func (c *ChairCache) Stats() []CacheStats {
+	return c.all.stats()
+}
+
+func chairIDTag(id int64) string {
+	return fmt.Sprintf("chair:%d", id)
+}
+
+// chairTags 椅子のidと、椅子が当てはまる検索条件のタグ
+func chairTags(chair Chair) []string {
+	tags := []string{
+		chairIDTag(chair.ID),
+		rangeTag("price", chairSearchCondition.Price, chair.Price),
+		rangeTag("height", chairSearchCondition.Height, chair.Height),
+		rangeTag("width", chairSearchCondition.Width, chair.Width),
+		rangeTag("depth", chairSearchCondition.Depth, chair.Depth),
+		"kind:" + chair.Kind,
+		"color:" + chair.Color,
+	}
+	return append(tags, featureTags(chairSearchCondition.Feature.List, chair.Features)...)
+}
+
+// rangeTag 値が入る範囲のタグ。検索のrangeIdと同じ番号を使う
+func rangeTag(name string, cond RangeCondition, v int64) string {
+	for _, r := range cond.Ranges {
+		if (r.Min == -1 || r.Min <= v) && (r.Max == -1 || v < r.Max) {
+			return fmt.Sprintf("%s:%d", name, r.ID)
+		}
+	}
+	return name + ":none"
+}
+
+// featureTags 検索はLIKEの部分一致なので、リストの各値が含まれているかで決める。
+// リストに無い値での検索はfeature:*で表すので、どの椅子や物件にも付けておく
+func featureTags(list []string, features string) []string {
+	tags := []string{"feature:*"}
+	for _, f := range list {
+		if strings.Contains(features, f) {
+			tags = append(tags, "feature:"+f)
+		}
+	}
+	return tags
+}
+
+// featureSearchTag 検索に使われた特徴のタグ
+func featureSearchTag(list []string, f string) string {
+	if containsString(list, f) {
+		return "feature:" + f
+	}
+	return "feature:*"
+}
+
+// invalidateChairCache 更新された椅子の影響を受けるキャッシュだけを消す
+func invalidateChairCache(ctx context.Context, before, after Chair) {
+	if after.Stock <= 0 {
+		chairCache.SetSoldOut(ctx, after.ID)
+	} else {
+		chairCache.ClearSoldOut(ctx, after.ID)
+	}
+
+	listedChanged := (before.Stock > 0) != (after.Stock > 0)
+	// 検索条件に使われる項目や並び順が変わると件数もページの中身も変わりうる
+	searchChanged := listedChanged ||
+		before.Price != after.Price ||
+		before.Height != after.Height ||
+		before.Width != after.Width ||
+		before.Depth != after.Depth ||
+		before.Color != after.Color ||
+		before.Features != after.Features ||
+		before.Kind != after.Kind ||
+		before.Popularity != after.Popularity
+
+	tags := []string{chairIDTag(after.ID)}
+	if searchChanged {
+		tags = append(tags, chairTags(before)...)
+		tags = append(tags, chairTags(after)...)
+	}
+	if listedChanged || before.Price != after.Price {
+		tags = append(tags, lowPricedTag)
+	}
+	chairCache.Invalidate(ctx, uniqueTags(tags)...)
+	if before.Stock != after.Stock {
+		stockStream.publish(newStockEvent(after.ID, after.Stock))
+	}
+}
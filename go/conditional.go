@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// エンドポイントごとのCache-Control
+const (
+	// 在庫で内容が変わるので毎回ETagで確かめさせる
+	chairCacheControl = "public, no-cache"
+	// 物件も管理APIで変えたらすぐに見せるので、毎回ETagで確かめさせる
+	estateCacheControl = "public, no-cache"
+	// 検索条件は起動中に変わらない
+	conditionCacheControl = "public, max-age=86400"
+)
+
+// resourceVersion 更新のたびに変わる版。ETagとLast-Modifiedに使う。
+// 版はcache_invalidationの行から作るので、同じ無効化まで適用したノードは同じETagを返す。
+// 行が無い時はこのノードだけの版にし、他のノードと違っても誤って304を返すことはない
+type resourceVersion struct {
+	name     string
+	mu       sync.RWMutex
+	shared   int64
+	local    int64
+	etag     string
+	modified time.Time
+}
+
+func newResourceVersion(name string) *resourceVersion {
+	v := &resourceVersion{name: name}
+	v.bump()
+	return v
+}
+
+// bump 共有の版が無い更新でこのノードだけの版にする。
+// 時刻はロックの中で取り、読んだ版より後の更新の時刻が読んだ時刻より前にならないようにする
+func (v *resourceVersion) bump() {
+	v.mu.Lock()
+	now := time.Now()
+	v.local++
+	v.etag = fmt.Sprintf("\"%s-%x-%d\"", v.name, startedAt.UnixNano(), v.local)
+	v.modified = now
+	v.mu.Unlock()
+}
+
+// set 無効化の行の版にする。既に適用したより前の行なら、同じ版で内容が変わらないようにこのノードだけの版にする。
+// idが0ならテーブルが作り直されたので、次の行から版を揃え直す
+func (v *resourceVersion) set(cv cacheVersion) {
+	v.mu.Lock()
+	if cv.id == 0 {
+		v.shared = 0
+	}
+	if cv.id <= v.shared {
+		v.mu.Unlock()
+		v.bump()
+		return
+	}
+	v.shared = cv.id
+	v.etag = fmt.Sprintf("\"%s-%d-%s\"", v.name, cv.id, strings.Map(func(r rune) rune {
+		if '0' <= r && r <= '9' {
+			return r
+		}
+		return -1
+	}, cv.createdAt))
+	v.modified = time.Now()
+	v.mu.Unlock()
+}
+
+// current データを読む前に呼ぶ。読んでいる間に更新されても古い版のETagを返すだけで済む
+func (v *resourceVersion) current() (string, time.Time) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.etag, v.modified
+}
+
+// startedAt 起動中に変わらないレスポンスのLast-Modified
+var startedAt = time.Now()
+
+// staticETag 起動中に変わらないレスポンスのETag。内容のハッシュなのでノード間で同じになる
+func staticETag(v interface{}) string {
+	b, _ := json.Marshal(v)
+	sum := sha1.Sum(b)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+// notModified リクエストの条件に合えばtrueを返す。ETag、Last-Modified、Cache-Controlは200か304のレスポンスにだけ付ける。
+// If-None-MatchがあればIf-Modified-Sinceは見ない。Last-Modifiedは秒までしか表せないので、
+// 版が今の秒に変わったばかりならLast-Modifiedを付けず、If-Modified-Sinceでも304を返さない
+func notModified(c echo.Context, etag string, modified time.Time, cacheControl string) bool {
+	settled := modified.Truncate(time.Second).Before(time.Now().Truncate(time.Second))
+
+	res := c.Response()
+	w := &validatorWriter{ResponseWriter: res.Writer, etag: etag, cacheControl: cacheControl}
+	if settled {
+		w.lastModified = modified.UTC().Format(http.TimeFormat)
+	}
+	res.Writer = w
+
+	req := c.Request()
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" && settled {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// validatorWriter ステータスコードを書く時に、200か304ならETagなどを付ける。エラーのレスポンスはキャッシュさせない
+type validatorWriter struct {
+	http.ResponseWriter
+	etag         string
+	lastModified string
+	cacheControl string
+}
+
+func (w *validatorWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusNotModified {
+		h := w.Header()
+		h.Set("ETag", w.etag)
+		if w.lastModified != "" {
+			h.Set("Last-Modified", w.lastModified)
+		}
+		h.Set("Cache-Control", w.cacheControl)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// etagMatch If-None-Matchは弱い比較で判定する
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheStatus アプリケーションのキャッシュから返したかをnginxのログに残す
+func setCacheStatus(c echo.Context, hit bool) {
+	if hit {
+		c.Response().Header().Set("X-Cache", "HIT")
+	} else {
+		c.Response().Header().Set("X-Cache", "MISS")
+	}
+}
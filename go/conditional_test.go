@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serveConditional notModifiedを使うハンドラーを、handleの結果で呼ぶ
+func serveConditional(t *testing.T, header http.Header, etag string, modified time.Time, handle func(c echo.Context) error) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	err := func() error {
+		if notModified(c, etag, modified, chairCacheControl) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return handle(c)
+	}()
+	if err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func respondOK(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{})
+}
+
+func TestNotModifiedValidatorsOnlyOnSuccess(t *testing.T) {
+	modified := time.Now().Add(-time.Hour)
+	cases := []struct {
+		name   string
+		header http.Header
+		handle func(c echo.Context) error
+		status int
+		set    bool
+	}{
+		{"ok", nil, respondOK, http.StatusOK, true},
+		{"not modified", http.Header{"If-None-Match": {`"v1"`}}, respondOK, http.StatusNotModified, true},
+		{"not found", nil, func(c echo.Context) error { return notFound("chair 1 not found") }, http.StatusNotFound, false},
+		{"internal error", nil, func(c echo.Context) error { return internalError("failed", nil) }, http.StatusInternalServerError, false},
+	}
+	for _, tc := range cases {
+		rec := serveConditional(t, tc.header, `"v1"`, modified, tc.handle)
+		if rec.Code != tc.status {
+			t.Fatalf("%v: status %v, want %v", tc.name, rec.Code, tc.status)
+		}
+		for _, name := range []string{"ETag", "Last-Modified", "Cache-Control"} {
+			if got := rec.Header().Get(name) != ""; got != tc.set {
+				t.Errorf("%v: %v set = %v, want %v", tc.name, name, got, tc.set)
+			}
+		}
+	}
+}
+
+func TestNotModifiedETagTakesPrecedence(t *testing.T) {
+	modified := time.Now().Add(-time.Hour)
+	ims := time.Now().UTC().Format(http.TimeFormat)
+
+	// If-Modified-Sinceだけなら304になる日時でも、ETagが違えば200
+	rec := serveConditional(t, http.Header{"If-None-Match": {`"old"`}, "If-Modified-Since": {ims}}, `"v1"`, modified, respondOK)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %v with a stale If-None-Match, want 200", rec.Code)
+	}
+	rec = serveConditional(t, http.Header{"If-Modified-Since": {ims}}, `"v1"`, modified, respondOK)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("status %v with only If-Modified-Since, want 304", rec.Code)
+	}
+}
+
+func TestNotModifiedIgnoresSinceWithinTheSameSecond(t *testing.T) {
+	// 同じ秒のうちに変わった版は、秒までのIf-Modified-Sinceでは前の版と区別できない
+	modified := time.Now()
+	ims := modified.Truncate(time.Second).UTC().Format(http.TimeFormat)
+	rec := serveConditional(t, http.Header{"If-Modified-Since": {ims}}, `"v2"`, modified, respondOK)
+	if modified.Truncate(time.Second).Before(time.Now().Truncate(time.Second)) {
+		t.Skip("the second passed while serving")
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %v for a version changed within the If-Modified-Since second, want 200", rec.Code)
+	}
+	if rec.Header().Get("Last-Modified") != "" {
+		t.Errorf("Last-Modified %q for a version changed in the current second", rec.Header().Get("Last-Modified"))
+	}
+}
+
+func TestResourceVersionSharedAcrossNodes(t *testing.T) {
+	withTables(func(tables map[string]*fakeTable) {}, func(fake *fakeDB) {
+		busA, busB := newCacheBus(), newCacheBus()
+		busA.node, busB.node = "a", "b"
+		a := newEstateCache(newGoCacheStore, busA)
+		b := newEstateCache(newGoCacheStore, busB)
+
+		a.Invalidate(context.Background(), "estate:1")
+		// 行を書けたらaは行の版にする
+		waitFor(t, "a to publish the invalidation", func() bool {
+			etag, _ := a.Version()
+			return strings.HasPrefix(etag, `"estate-1-`)
+		})
+		published := fake.executed("INSERT INTO cache_invalidation")
+		if len(published) != 1 {
+			t.Fatalf("published %v, want once", published)
+		}
+		// bが読むcache_invalidationにaの書いた行を置く
+		fake.mu.Lock()
+		fake.tables["cache_invalidation"].rows = [][]driver.Value{
+			{int64(1), "estate", cacheOpInvalidate, published[0][3], published[0][4]},
+		}
+		fake.mu.Unlock()
+		etagA, _ := a.Version()
+		etagB, _ := b.Version()
+		if etagA == etagB {
+			t.Fatalf("versions %v before b applied the invalidation, want them to differ", etagA)
+		}
+		busB.poll()
+		if etagB, _ = b.Version(); etagA != etagB {
+			t.Errorf("version %v on b, want %v as on a", etagB, etagA)
+		}
+
+		// 適用済みの行をもう一度受け取っても同じ版にはしない
+		b.receive(cacheOpInvalidate, []string{"estate:1"}, cacheVersion{id: 1, createdAt: published[0][4].(string)})
+		if again, _ := b.Version(); again == etagA {
+			t.Errorf("version %v kept after an out-of-order invalidation", again)
+		}
+
+		// 後から起動したノードは最後の行の版から始める
+		busC := newCacheBus()
+		c := newEstateCache(newGoCacheStore, busC)
+		busC.start(time.Hour)
+		if etagC, _ := c.Version(); etagC != etagA {
+			t.Errorf("version %v on a new node, want %v", etagC, etagA)
+		}
+	})
+}
+
+func TestEstateCacheControl(t *testing.T) {
+	e := newEcho(nil)
+	rec := serveRequest(e, http.MethodGet, "/api/estate/1", "", nil)
+	if got := rec.Header().Get("Cache-Control"); got != "public, no-cache" {
+		t.Errorf("Cache-Control %q, want public, no-cache", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// エラーレスポンスのcode。クライアントはステータスコードではなくこれで原因を見分ける
+const (
+	errCodeInvalidParameter = "invalid_parameter"
+	errCodeMissingParameter = "missing_parameter"
+	errCodeInvalidBody      = "invalid_body"
+	errCodeNotFound         = "not_found"
+	errCodeSoldOut          = "sold_out"
+	errCodeConflict         = "conflict"
+	// Idempotency-Keyが別のリクエストに使われたか、同じキーのリクエストが実行中
+	errCodeIdempotencyKeyReused     = "idempotency_key_reused"
+	errCodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+	errCodeUnauthorized             = "unauthorized"
+	errCodeUnsupportedMedia         = "unsupported_media_type"
+	errCodeOverloaded               = "overloaded"
+	errCodeTimeout                  = "timeout"
+	errCodeInternal                 = "internal_error"
+)
+
+// statusErrorCodes echo.HTTPErrorのステータスコードから決めるcode
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          errCodeUnauthorized,
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              errCodeNotFound,
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              errCodeConflict,
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  errCodeUnsupportedMedia,
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusServiceUnavailable:    errCodeOverloaded,
+}
+
+//APIError エラーレスポンスの本文。causeはログにだけ出し、クライアントには返さない。
+// detailsはインポートの行ごとのエラーのように、codeだけでは足りない時の詳細
+type APIError struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Field     string      `json:"field,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId"`
+	cause     error
+}
+
+func newAPIError(status int, code, message string, cause error) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, cause: cause}
+}
+
+func (e *APIError) Error() string {
+	if e.cause != nil {
+		return e.Message + " : " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+// invalidParam パラメータの値が読めないか、範囲に無い
+func invalidParam(field string, err error) *APIError {
+	e := newAPIError(http.StatusBadRequest, errCodeInvalidParameter, field+" is invalid", err)
+	e.Field = field
+	return e
+}
+
+// invalidParamf 違反した理由をmessageにしてクライアントに返す
+func invalidParamf(field, format string, args ...interface{}) *APIError {
+	e := newAPIError(http.StatusBadRequest, errCodeInvalidParameter, field+" "+fmt.Sprintf(format, args...), nil)
+	e.Field = field
+	return e
+}
+
+// missingParam 必須のパラメータが無い
+func missingParam(field string) *APIError {
+	e := newAPIError(http.StatusBadRequest, errCodeMissingParameter, field+" is required", nil)
+	e.Field = field
+	return e
+}
+
+// invalidBody リクエストの本文が読めない
+func invalidBody(err error) *APIError {
+	return newAPIError(http.StatusBadRequest, errCodeInvalidBody, "request body is invalid", err)
+}
+
+func notFound(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusNotFound, errCodeNotFound, fmt.Sprintf(format, args...), nil)
+}
+
+func conflict(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusConflict, errCodeConflict, fmt.Sprintf(format, args...), nil)
+}
+
+// internalError msgはログにだけ出す。クライアントには原因を返さない
+func internalError(msg string, err error) *APIError {
+	return newAPIError(http.StatusInternalServerError, errCodeInternal, "internal server error", fmt.Errorf("%s : %w", msg, err))
+}
+
+// toAPIError ハンドラーやミドルウェアが返したエラーをレスポンスにする
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		res := *apiErr
+		return &res
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code, ok := statusErrorCodes[he.Code]
+		if !ok {
+			code = errCodeInternal
+		}
+		return newAPIError(he.Code, code, fmt.Sprint(he.Message), he.Internal)
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return newAPIError(http.StatusNotFound, errCodeNotFound, "not found", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return newAPIError(http.StatusServiceUnavailable, errCodeTimeout, "request timed out", err)
+	}
+	return newAPIError(http.StatusInternalServerError, errCodeInternal, "internal server error", err)
+}
+
+// httpErrorHandler 全てのエラーをAPIErrorのJSONで返す。X-Request-IDを本文にも入れてログと突き合わせられるようにする
+func httpErrorHandler(err error, c echo.Context) {
+	e := toAPIError(err)
+	e.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+	req := c.Request()
+	// 過負荷での503はクライアントが再送すればよいので、500だけをエラーとして残す
+	if e.Code == errCodeInternal {
+		c.Echo().Logger.Errorf("%v %v %v : %v", req.Method, req.URL.Path, e.RequestID, e)
+	} else {
+		c.Echo().Logger.Infof("%v %v %v : %v", req.Method, req.URL.Path, e.RequestID, e)
+	}
+
+	if c.Response().Committed {
+		return
+	}
+	if req.Method == http.MethodHead {
+		err = c.NoContent(e.Status)
+	} else {
+		err = c.JSON(e.Status, e)
+	}
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to send error response : %v", err)
+	}
+}
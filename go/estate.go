@@ -3,14 +3,13 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/csv"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
 	"github.com/newrelic/go-agent/v3/newrelic"
@@ -55,7 +54,13 @@ type Coordinate struct {
 }
 
 type Coordinates struct {
-	Coordinates []Coordinate `json:"coordinates"`
+	Coordinates []Coordinate `json:"coordinates" validate:"required,minitems=3"`
+}
+
+//RequestDocumentRequest 物件の資料請求
+type RequestDocumentRequest struct {
+	ID    int64  `json:"-" param:"id" validate:"required,min=1"`
+	Email string `json:"email" validate:"required,email"`
 }
 
 type Range struct {
@@ -84,26 +89,66 @@ type BoundingBox struct {
 func getEstateDetail(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.Echo().Logger.Infof("Request parameter \"id\" parse error : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+	id := c.Get(requestKey).(*IDRequest).ID
+
+	etag, modified := estateCache.Version()
+	if notModified(c, etag, modified, estateCacheControl) {
+		return c.NoContent(http.StatusNotModified)
 	}
 
 	var estate Estate
-	err = db.noState.GetContext(ctx, &estate, "SELECT * FROM estate WHERE id = ?", id)
+	err := db.noState.GetContext(ctx, &estate, "SELECT * FROM estate WHERE id = ?", id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.Echo().Logger.Infof("getEstateDetail estate id %v not found", id)
-			return c.NoContent(http.StatusNotFound)
+			return notFound("estate %v not found", id)
 		}
-		c.Echo().Logger.Errorf("Database Execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return internalError("Database Execution error", err)
 	}
 
 	return c.JSON(http.StatusOK, estate)
 }
 
+//EstateDetailsResponse まとめて読んだ物件。見つからないidは分けて返す
+type EstateDetailsResponse struct {
+	Estates  []Estate `json:"estates"`
+	NotFound []int64  `json:"notFound"`
+}
+
+// getEstateDetails ids=の物件を1回のクエリで読み、idsの順に並べて返す
+func getEstateDetails(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	ids := c.Get(requestKey).(*IDsRequest).unique()
+
+	etag, modified := estateCache.Version()
+	if notModified(c, etag, modified, estateCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	query, params, err := sqlx.In(`SELECT * FROM estate WHERE id IN (?)`, ids)
+	if err != nil {
+		return internalError("getEstateDetails query build error", err)
+	}
+	var estates []Estate
+	if err := db.noState.SelectContext(ctx, &estates, query, params...); err != nil {
+		return internalError("getEstateDetails DB execution error", err)
+	}
+	byID := make(map[int64]Estate, len(estates))
+	for _, estate := range estates {
+		byID[estate.ID] = estate
+	}
+
+	res := EstateDetailsResponse{Estates: []Estate{}, NotFound: []int64{}}
+	for _, id := range ids {
+		if estate, ok := byID[id]; ok {
+			res.Estates = append(res.Estates, estate)
+		} else {
+			res.NotFound = append(res.NotFound, id)
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
 func getRange(cond RangeCondition, rangeID string) (*Range, error) {
 	RangeIndex, err := strconv.Atoi(rangeID)
 	if err != nil {
@@ -117,137 +162,103 @@ func getRange(cond RangeCondition, rangeID string) (*Range, error) {
 	return cond.Ranges[RangeIndex], nil
 }
 
+var estateImportTable = importTable{
+	name:    "estate",
+	columns: []string{"id", "name", "description", "thumbnail", "address", "latitude", "longitude", "rent", "door_height", "door_width", "features", "popularity"},
+	fields:  []string{"id", "name", "description", "thumbnail", "address", "latitude", "longitude", "rent", "doorHeight", "doorWidth", "features", "popularity"},
+	aliases: map[string]string{"lat": "latitude", "lng": "longitude", "lon": "longitude"},
+	parse: func(r *recordReader) importRow {
+		id := r.Int("id", 0)
+		name := r.String("name", 64)
+		description := r.String("description", 4096)
+		thumbnail := r.String("thumbnail", 128)
+		address := r.String("address", 128)
+		latitude := r.Float("latitude", -90, 90)
+		longitude := r.Float("longitude", -180, 180)
+		rent := r.Int("rent", 0)
+		doorHeight := r.Int("door_height", 0)
+		doorWidth := r.Int("door_width", 0)
+		features := r.Features("features", 64, estateSearchCondition.Feature.List)
+		popularity := r.Int("popularity", 0)
+		return importRow{
+			ID:     id,
+			Values: []interface{}{id, name, description, thumbnail, address, latitude, longitude, rent, doorHeight, doorWidth, features, popularity},
+		}
+	},
+	afterCommit: afterEstateImport,
+}
+
 func postEstate(c echo.Context) error {
+	return handleImport(c, estateImportTable, "estates")
+}
+
+func afterEstateImport(ctx context.Context, mode string, result importResult) {
+	estateCache.Flush(ctx)
+}
+
+func searchEstates(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	header, err := c.FormFile("estates")
+	s, err := newEstateSearch(c.Get(requestKey).(*EstateSearchRequest))
 	if err != nil {
-		c.Logger().Errorf("failed to get form file: %v", err)
-		return c.NoContent(http.StatusBadRequest)
-	}
-	f, err := header.Open()
-	if err != nil {
-		c.Logger().Errorf("failed to open form file: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	defer f.Close()
-	records, err := csv.NewReader(f).ReadAll()
-	if err != nil {
-		c.Logger().Errorf("failed to read csv: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return err
 	}
 
-	tx1, err := db.withState.Begin()
+	res, hit, err := s.fetch(ctx)
 	if err != nil {
-		c.Logger().Errorf("failed to begin tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return internalError("searchEstates DB execution error", err)
 	}
-	defer tx1.Rollback()
-	tx2, err := db.noState.Begin()
-	if err != nil {
-		c.Logger().Errorf("failed to begin tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	defer tx2.Rollback()
-	var wg sync.WaitGroup
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	limit := make(chan struct{}, 2)
-	for _, row := range records {
-		wg.Add(1)
-		limit <- struct{}{}
-		go func(row []string) {
-			defer func() {
-				wg.Done()
-				<-limit
-			}()
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			rm := RecordMapper{Record: row}
-			id := rm.NextInt()
-			name := rm.NextString()
-			description := rm.NextString()
-			thumbnail := rm.NextString()
-			address := rm.NextString()
-			latitude := rm.NextFloat()
-			longitude := rm.NextFloat()
-			rent := rm.NextInt()
-			doorHeight := rm.NextInt()
-			doorWidth := rm.NextInt()
-			features := rm.NextString()
-			popularity := rm.NextInt()
-			if err := rm.Err(); err != nil {
-				c.Logger().Errorf("failed to read record: %v", err)
-				cancel()
-			}
-			_, err := tx1.ExecContext(ctx, "INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)", id, name, description, thumbnail, address, latitude, longitude, rent, doorHeight, doorWidth, features, popularity)
-			if err != nil {
-				c.Logger().Errorf("failed to insert estate: %v", err)
-				panic(err)
-			}
-			_, err = tx2.ExecContext(ctx, "INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)", id, name, description, thumbnail, address, latitude, longitude, rent, doorHeight, doorWidth, features, popularity)
-			if err != nil {
-				c.Logger().Errorf("failed to insert estate: %v", err)
-				panic(err)
-			}
-		}(row)
-	}
-	wg.Wait()
-	if ctx.Err() != nil {
-		return c.NoContent(http.StatusBadRequest)
-	}
-	if err := tx1.Commit(); err != nil {
-		c.Logger().Errorf("failed to commit tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+	if s.page == 0 {
+		searchTraffic.record("estate", s.query.pageKey(s.page, s.perPage), c.QueryParams())
 	}
-	if err := tx2.Commit(); err != nil {
-		c.Logger().Errorf("failed to commit tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	estateCache.Flush()
+	setCacheStatus(c, hit)
 
-	return c.NoContent(http.StatusCreated)
+	return c.JSON(http.StatusOK, res)
 }
 
-func searchEstates(c echo.Context) error {
-	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+//EstateSearchRequest 物件の検索のクエリパラメータ
+type EstateSearchRequest struct {
+	Page              int      `query:"page" validate:"required,min=0"`
+	PerPage           int      `query:"perPage" validate:"required,min=1,max=100"`
+	DoorHeightRangeID string   `query:"doorHeightRangeId" validate:"range=estate.doorHeight"`
+	DoorWidthRangeID  string   `query:"doorWidthRangeId" validate:"range=estate.doorWidth"`
+	RentRangeID       string   `query:"rentRangeId" validate:"range=estate.rent"`
+	Features          []string `query:"features" validate:"enum=estate.feature"`
+}
 
-	conditions := make([]string, 0)
-	params := make([]interface{}, 0)
-	ck := ""
+// estateSearch 検索のクエリパラメータを解釈したもの。ウォームアップでも同じ検索を組み立てる
+type estateSearch struct {
+	query     searchQuery
+	condition string
+	params    []interface{}
+	page      int
+	perPage   int
+}
 
-	page, err := strconv.Atoi(c.QueryParam("page"))
-	if err != nil {
-		c.Logger().Infof("Invalid format page parameter : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+// parseEstateSearch ウォームアップで覚えていたクエリから検索を組み立て直す
+func parseEstateSearch(v url.Values) (*estateSearch, error) {
+	req := &EstateSearchRequest{}
+	if err := decodeValues(v, "query", req); err != nil {
+		return nil, err
 	}
-
-	perPage, err := strconv.Atoi(c.QueryParam("perPage"))
-	if err != nil {
-		c.Logger().Infof("Invalid format perPage parameter : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+	if err := validateRequest(req); err != nil {
+		return nil, err
 	}
+	return newEstateSearch(req)
+}
 
-	if page == 0 {
-		r, ok := estateCache.Get(c.Request().URL.RawQuery)
-		if ok {
-			time.Sleep(time.Millisecond * cacheSleep)
-			return c.JSON(http.StatusOK, r)
-		}
-	}
+func newEstateSearch(req *EstateSearchRequest) (*estateSearch, error) {
+	conditions := make([]string, 0)
+	params := make([]interface{}, 0)
+	q := searchQuery{}
 
-	if c.QueryParam("doorHeightRangeId") != "" {
-		doorHeight, err := getRange(estateSearchCondition.DoorHeight, c.QueryParam("doorHeightRangeId"))
-		ck += c.QueryParam("doorHeightRangeId")
+	if req.DoorHeightRangeID != "" {
+		doorHeight, err := getRange(estateSearchCondition.DoorHeight, req.DoorHeightRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("doorHeightRangeID invalid, %v : %v", c.QueryParam("doorHeightRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("doorHeightRangeId", err)
 		}
 
+		q.addRange("doorHeight", doorHeight)
 		if doorHeight.Min != -1 {
 			conditions = append(conditions, "door_height >= ?")
 			params = append(params, doorHeight.Min)
@@ -259,14 +270,13 @@ func searchEstates(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("doorWidthRangeId") != "" {
-		doorWidth, err := getRange(estateSearchCondition.DoorWidth, c.QueryParam("doorWidthRangeId"))
-		ck += c.QueryParam("doorWidthRangeId")
+	if req.DoorWidthRangeID != "" {
+		doorWidth, err := getRange(estateSearchCondition.DoorWidth, req.DoorWidthRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("doorWidthRangeID invalid, %v : %v", c.QueryParam("doorWidthRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("doorWidthRangeId", err)
 		}
 
+		q.addRange("doorWidth", doorWidth)
 		if doorWidth.Min != -1 {
 			conditions = append(conditions, "door_width >= ?")
 			params = append(params, doorWidth.Min)
@@ -277,14 +287,13 @@ func searchEstates(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("rentRangeId") != "" {
-		estateRent, err := getRange(estateSearchCondition.Rent, c.QueryParam("rentRangeId"))
-		ck += c.QueryParam("rentRangeId")
+	if req.RentRangeID != "" {
+		estateRent, err := getRange(estateSearchCondition.Rent, req.RentRangeID)
 		if err != nil {
-			c.Echo().Logger.Infof("rentRangeID invalid, %v : %v", c.QueryParam("rentRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, invalidParam("rentRangeId", err)
 		}
 
+		q.addRange("rent", estateRent)
 		if estateRent.Min != -1 {
 			conditions = append(conditions, "rent >= ?")
 			params = append(params, estateRent.Min)
@@ -295,99 +304,123 @@ func searchEstates(c echo.Context) error {
 		}
 	}
 
-	if c.QueryParam("features") != "" {
-		ck += c.QueryParam("features")
-		for _, f := range strings.Split(c.QueryParam("features"), ",") {
+	if len(req.Features) > 0 {
+		features := req.Features
+		q.addFeatures(features)
+		for _, f := range features {
 			conditions = append(conditions, "features like concat('%', ?, '%')")
 			params = append(params, f)
 		}
 	}
 
 	if len(conditions) == 0 {
-		c.Echo().Logger.Infof("searchEstates search condition not found")
-		return c.NoContent(http.StatusBadRequest)
+		return nil, errSearchConditionNotFound
 	}
 
+	return &estateSearch{
+		query:     q,
+		condition: strings.Join(conditions, " AND "),
+		params:    params,
+		page:      req.Page,
+		perPage:   req.PerPage,
+	}, nil
+}
+
+// fetch 1ページ目だけをキャッシュし、同じ検索の同時のキャッシュミスは1回の読み込みにまとめる
+func (s *estateSearch) fetch(ctx context.Context) (EstateSearchResponse, bool, error) {
+	if s.page == 0 {
+		return estateCache.FetchPage(ctx, s.query.pageKey(s.page, s.perPage), s.query.tags(estateSearchCondition.Feature.List), s.load)
+	}
+	res, err := s.load(ctx)
+	return res, false, err
+}
+
+func (s *estateSearch) load(ctx context.Context) (EstateSearchResponse, error) {
 	searchQuery := "SELECT * FROM estate WHERE "
 	countQuery := "SELECT COUNT(*) FROM estate WHERE "
-	searchCondition := strings.Join(conditions, " AND ")
 	limitOffset := " ORDER BY popularity DESC, id ASC LIMIT ? OFFSET ?"
 
 	var res EstateSearchResponse
-	cc, ok := estateCache.Get(ck)
-	if ok {
-		s, _ := cc.(string)
-		time.Sleep(time.Millisecond * cacheSleep)
-		res.Count, _ = strconv.ParseInt(s, 10, 64)
-	} else {
-		err = db.noState.GetContext(ctx, &res.Count, countQuery+searchCondition, params...)
-		if err != nil {
-			c.Logger().Errorf("searchEstates DB execution error : %v", err)
-			return c.NoContent(http.StatusInternalServerError)
-		}
-		_ = estateCache.Add(ck, strconv.FormatInt(res.Count, 10), time.Minute*1)
+	count, _, err := estateCache.FetchCount(ctx, s.query.countKey(), s.query.tags(estateSearchCondition.Feature.List), func(ctx context.Context) (int64, error) {
+		var count int64
+		err := db.noState.GetContext(ctx, &count, countQuery+s.condition, s.params...)
+		return count, err
+	})
+	if err != nil {
+		return res, err
 	}
-	if res.Count == 0 {
-		return c.JSON(http.StatusOK, EstateSearchResponse{Count: 0, Estates: []Estate{}})
+	if count == 0 {
+		return EstateSearchResponse{Count: 0, Estates: []Estate{}}, nil
 	}
+	res.Count = count
 
 	estates := []Estate{}
-	params = append(params, perPage, page*perPage)
-	err = db.noState.SelectContext(ctx, &estates, searchQuery+searchCondition+limitOffset, params...)
+	params := append(append([]interface{}{}, s.params...), s.perPage, s.page*s.perPage)
+	err = db.noState.SelectContext(ctx, &estates, searchQuery+s.condition+limitOffset, params...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return c.JSON(http.StatusOK, EstateSearchResponse{Count: 0, Estates: []Estate{}})
+			return EstateSearchResponse{Count: 0, Estates: []Estate{}}, nil
 		}
-		c.Logger().Errorf("searchEstates DB execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return res, err
 	}
-
 	res.Estates = estates
-
-	if page == 0 {
-		_ = estateCache.Add(c.Request().URL.RawQuery, res, time.Minute*3)
-	}
-
-	return c.JSON(http.StatusOK, res)
+	return res, nil
 }
 
 func getLowPricedEstate(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	estates := make([]Estate, 0, Limit)
-	query := `SELECT * FROM estate ORDER BY rent ASC, id ASC LIMIT ?`
-	err := db.noState.SelectContext(ctx, &estates, query, Limit)
+	etag, modified := estateCache.Version()
+	if notModified(c, etag, modified, estateCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	estates, hit, err := estateCache.FetchLowPriced(ctx, loadLowPricedEstates)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Logger().Error("getLowPricedEstate not found")
 			return c.JSON(http.StatusOK, EstateListResponse{[]Estate{}})
 		}
-		c.Logger().Errorf("getLowPricedEstate DB execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return internalError("getLowPricedEstate DB execution error", err)
 	}
+	setCacheStatus(c, hit)
 
 	return c.JSON(http.StatusOK, EstateListResponse{Estates: estates})
 }
 
+func loadLowPricedEstates(ctx context.Context) ([]Estate, error) {
+	estates := make([]Estate, 0, Limit)
+	query := `SELECT * FROM estate ORDER BY rent ASC, id ASC LIMIT ?`
+	err := db.noState.SelectContext(ctx, &estates, query, Limit)
+	return estates, err
+}
+
 func searchRecommendedEstateWithChair(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	id, err := strconv.Atoi(c.Param("id"))
+	id := c.Get(requestKey).(*IDRequest).ID
+
+	estates, hit, err := estateCache.FetchRecommended(ctx, id, func(ctx context.Context) ([]Estate, error) {
+		return loadRecommendedEstates(ctx, id)
+	})
 	if err != nil {
-		c.Logger().Infof("Invalid format searchRecommendedEstateWithChair id : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+		if err == sql.ErrNoRows {
+			return invalidParam("id", fmt.Errorf("chair %v not found", id))
+		}
+		return internalError("Database execution error", err)
 	}
 
+	setCacheStatus(c, hit)
+
+	return c.JSON(http.StatusOK, EstateListResponse{Estates: estates})
+}
+
+func loadRecommendedEstates(ctx context.Context, id int64) ([]Estate, error) {
 	chair := Chair{}
 	query := `SELECT * FROM chair WHERE id = ?`
-	err = db.noState.GetContext(ctx, &chair, query, id)
+	err := db.noState.GetContext(ctx, &chair, query, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.Logger().Infof("Requested chair id \"%v\" not found", id)
-			return c.NoContent(http.StatusBadRequest)
-		}
-		c.Logger().Errorf("Database execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, err
 	}
 
 	var estates []Estate
@@ -410,41 +443,39 @@ func searchRecommendedEstateWithChair(c echo.Context) error {
 	}
 	query = `SELECT * FROM estate WHERE (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) ORDER BY popularity DESC, id ASC LIMIT ?`
 	err = db.noState.SelectContext(ctx, &estates, query, q1, q2, q2, q1, Limit)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.JSON(http.StatusOK, EstateListResponse{[]Estate{}})
-		}
-		c.Logger().Errorf("Database execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
 	}
-
-	return c.JSON(http.StatusOK, EstateListResponse{Estates: estates})
+	return estates, nil
 }
 
 func searchEstateNazotte(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	coordinates := Coordinates{}
-	err := c.Bind(&coordinates)
+	coordinates := c.Get(requestKey).(*Coordinates)
+
+	estates, err := findEstatesInPolygon(ctx, coordinates)
 	if err != nil {
-		c.Echo().Logger.Infof("post search estate nazotte failed : %v", err)
-		return c.NoContent(http.StatusBadRequest)
+		return err
 	}
 
-	if len(coordinates.Coordinates) == 0 {
-		return c.NoContent(http.StatusBadRequest)
-	}
+	var re EstateSearchResponse
+	re.Estates = estates
+	re.Count = int64(len(re.Estates))
 
+	return c.JSON(http.StatusOK, re)
+}
+
+// findEstatesInPolygon 多角形の中にある物件を人気順にNazotteLimit件まで返す
+func findEstatesInPolygon(ctx context.Context, coordinates *Coordinates) ([]Estate, error) {
 	b := coordinates.getBoundingBox()
 	estatesInBoundingBox := []Estate{}
 	query := `SELECT * FROM estate WHERE latitude between ? AND ? AND longitude between ? AND ? ORDER BY popularity DESC, id ASC`
-	err = db.noState.SelectContext(ctx, &estatesInBoundingBox, query, b.TopLeftCorner.Latitude, b.BottomRightCorner.Latitude, b.TopLeftCorner.Longitude, b.BottomRightCorner.Longitude)
+	err := db.noState.SelectContext(ctx, &estatesInBoundingBox, query, b.TopLeftCorner.Latitude, b.BottomRightCorner.Latitude, b.TopLeftCorner.Longitude, b.BottomRightCorner.Longitude)
 	if err == sql.ErrNoRows {
-		c.Echo().Logger.Infof("select * from estate where latitude ...", err)
-		return c.JSON(http.StatusOK, EstateSearchResponse{Count: 0, Estates: []Estate{}})
+		return []Estate{}, nil
 	} else if err != nil {
-		c.Echo().Logger.Errorf("database execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, internalError("database execution error", err)
 	}
 
 	estatesInPolygon := []Estate{}
@@ -458,8 +489,7 @@ func searchEstateNazotte(c echo.Context) error {
 			if err == sql.ErrNoRows {
 				continue
 			} else {
-				c.Echo().Logger.Errorf("db access is failed on executing validate if estate is in polygon : %v", err)
-				return c.NoContent(http.StatusInternalServerError)
+				return nil, internalError("db access is failed on executing validate if estate is in polygon", err)
 			}
 		} else {
 			estatesInPolygon = append(estatesInPolygon, validatedEstate)
@@ -469,16 +499,10 @@ func searchEstateNazotte(c echo.Context) error {
 		}
 	}
 
-	var re EstateSearchResponse
-	re.Estates = []Estate{}
 	if len(estatesInPolygon) > NazotteLimit {
-		re.Estates = estatesInPolygon[:NazotteLimit]
-	} else {
-		re.Estates = estatesInPolygon
+		return estatesInPolygon[:NazotteLimit], nil
 	}
-	re.Count = int64(len(re.Estates))
-
-	return c.JSON(http.StatusOK, re)
+	return estatesInPolygon, nil
 }
 
 func (cs Coordinates) getBoundingBox() BoundingBox {
@@ -520,38 +544,24 @@ func (cs Coordinates) coordinatesToText() string {
 func postEstateRequestDocument(c echo.Context) error {
 	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
 
-	m := echo.Map{}
-	if err := c.Bind(&m); err != nil {
-		c.Echo().Logger.Infof("post request document failed : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-
-	_, ok := m["email"].(string)
-	if !ok {
-		c.Echo().Logger.Info("post request document failed : email not found in request body")
-		return c.NoContent(http.StatusBadRequest)
-	}
-
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.Echo().Logger.Infof("post request document failed : %v", err)
-		return c.NoContent(http.StatusBadRequest)
-	}
+	id := c.Get(requestKey).(*RequestDocumentRequest).ID
 
 	estate := Estate{}
 	query := `SELECT * FROM estate WHERE id = ?`
-	err = db.noState.GetContext(ctx, &estate, query, id)
+	err := db.noState.GetContext(ctx, &estate, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return c.NoContent(http.StatusNotFound)
+			return notFound("estate %v not found", id)
 		}
-		c.Logger().Errorf("postEstateRequestDocument DB execution error : %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return internalError("postEstateRequestDocument DB execution error", err)
 	}
 
 	return c.NoContent(http.StatusOK)
 }
 
 func getEstateSearchCondition(c echo.Context) error {
+	if notModified(c, estateSearchConditionETag, startedAt, conditionCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
 	return c.JSON(http.StatusOK, estateSearchCondition)
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGetEstateDetailsMixedIDs(t *testing.T) {
+	withTables(func(tables map[string]*fakeTable) {
+		estates := tables["estate"]
+		estates.rows = append(estates.rows,
+			[]driver.Value{int64(2), "/images/estate/2.png", "イスウーモ館", "広い", 35.7, 139.8, "東京都港区", int64(120000), int64(210), int64(120), "", int64(100)},
+		)
+	}, func(fake *fakeDB) {
+		e := newEcho(nil)
+		rec := serveRequest(e, http.MethodGet, "/api/estate?ids=2,8,1,2", "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %v: %s", rec.Code, rec.Body.String())
+		}
+		var res EstateDetailsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Estates) != 2 || res.Estates[0].ID != 2 || res.Estates[1].ID != 1 {
+			t.Errorf("estates %+v, want 2 and 1 in the requested order", res.Estates)
+		}
+		if len(res.NotFound) != 1 || res.NotFound[0] != 8 {
+			t.Errorf("notFound %v, want [8]", res.NotFound)
+		}
+	})
+}
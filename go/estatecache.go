@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// recommendTag 椅子ごとのおすすめの物件に新しく入りうる変更で消すタグ
+const recommendTag = "recommend"
+
+// EstateCache 物件のキャッシュ。件数・検索結果の1ページ目・安い順の一覧・椅子ごとのおすすめの名前空間を持つ
+type EstateCache struct {
+	count     *cacheNamespace
+	page      *cacheNamespace
+	lowPriced *cacheNamespace
+	recommend *cacheNamespace
+	all       cacheSet
+	bus       *cacheBus
+	version   *resourceVersion
+}
+
+func newEstateCache(newStore func() CacheStore, bus *cacheBus) *EstateCache {
+	c := &EstateCache{
+		count:     newCacheNamespace("estate.count", newStore(), cacheStaleWindow),
+		page:      newCacheNamespace("estate.page", newStore(), cacheStaleWindow),
+		lowPriced: newCacheNamespace("estate.lowPriced", newStore(), cacheStaleWindow),
+		recommend: newCacheNamespace("estate.recommend", newStore(), cacheStaleWindow),
+	}
+	c.all = cacheSet{c.count, c.page, c.lowPriced, c.recommend}
+	c.bus = bus
+	c.version = newResourceVersion("estate")
+	bus.register("estate", c)
+	return c
+}
+
+// FetchCount tagsには検索条件のタグを渡す
+func (c *EstateCache) FetchCount(ctx context.Context, key string, tags []string, load func(ctx context.Context) (int64, error)) (int64, bool, error) {
+	v, hit, err := c.count.fetch(ctx, key, time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		n, err := load(ctx)
+		return n, tags, err
+	})
+	if err != nil {
+		return 0, hit, err
+	}
+	return v.(int64), hit, nil
+}
+
+// FetchPage tagsには検索条件のタグを渡す。含まれる物件のタグはここで足す
+func (c *EstateCache) FetchPage(ctx context.Context, key string, tags []string, load func(ctx context.Context) (EstateSearchResponse, error)) (EstateSearchResponse, bool, error) {
+	v, hit, err := c.page.fetch(ctx, key, 3*time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		res, err := load(ctx)
+		all := append([]string{}, tags...)
+		for _, estate := range res.Estates {
+			all = append(all, estateIDTag(estate.ID))
+		}
+		return res, all, err
+	})
+	if err != nil {
+		return EstateSearchResponse{}, hit, err
+	}
+	return v.(EstateSearchResponse), hit, nil
+}
+
+func (c *EstateCache) FetchLowPriced(ctx context.Context, load func(ctx context.Context) ([]Estate, error)) ([]Estate, bool, error) {
+	v, hit, err := c.lowPriced.fetch(ctx, "", time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		estates, err := load(ctx)
+		tags := []string{lowPricedTag}
+		for _, estate := range estates {
+			tags = append(tags, estateIDTag(estate.ID))
+		}
+		return estates, tags, err
+	})
+	if err != nil {
+		return nil, hit, err
+	}
+	return v.([]Estate), hit, nil
+}
+
+// FetchRecommended 椅子のidごとにおすすめの物件を持つ。同じ椅子の同時の読み込みは1回にまとめる
+func (c *EstateCache) FetchRecommended(ctx context.Context, chairID int64, load func(ctx context.Context) ([]Estate, error)) ([]Estate, bool, error) {
+	v, hit, err := c.recommend.fetch(ctx, strconv.FormatInt(chairID, 10), 3*time.Minute, func(ctx context.Context) (interface{}, []string, error) {
+		estates, err := load(ctx)
+		tags := []string{recommendTag}
+		for _, estate := range estates {
+			tags = append(tags, estateIDTag(estate.ID))
+		}
+		return estates, tags, err
+	})
+	if err != nil {
+		return nil, hit, err
+	}
+	return v.([]Estate), hit, nil
+}
+
+func (c *EstateCache) Invalidate(ctx context.Context, tags ...string) {
+	c.update(ctx, cacheOpInvalidate, tags...)
+}
+
+func (c *EstateCache) Flush(ctx context.Context) {
+	c.update(ctx, cacheOpFlush)
+}
+
+// update 自分のキャッシュに適用し、他のノードにも伝える。
+// 伝えるログは後から書くので、書けるまではこのノードだけの版にし、書けたら行の版にする
+func (c *EstateCache) update(ctx context.Context, op string, keys ...string) {
+	c.apply(op, keys)
+	c.version.bump()
+	c.bus.publish(ctx, "estate", op, keys)
+}
+
+func (c *EstateCache) published(version cacheVersion) {
+	c.version.set(version)
+}
+
+func (c *EstateCache) receive(op string, keys []string, version cacheVersion) {
+	c.apply(op, keys)
+	c.version.set(version)
+}
+
+func (c *EstateCache) resume(version cacheVersion) {
+	c.version.set(version)
+}
+
+func (c *EstateCache) apply(op string, keys []string) {
+	switch op {
+	case cacheOpInvalidate:
+		c.all.invalidate(keys...)
+	case cacheOpFlush:
+		c.all.flush()
+	}
+}
+
+// Version ETagに使う版。キャッシュを無効化する更新のたびに変わる
+func (c *EstateCache) Version() (string, time.Time) {
+	return c.version.current()
+}
+
+func (c *EstateCache) Stats() []CacheStats {
+	return c.all.stats()
+}
+
+func estateIDTag(id int64) string {
+	return fmt.Sprintf("estate:%d", id)
+}
+
+// estateTags 物件のidと、物件が当てはまる検索条件のタグ
+func estateTags(estate Estate) []string {
+	tags := []string{
+		estateIDTag(estate.ID),
+		rangeTag("doorHeight", estateSearchCondition.DoorHeight, estate.DoorHeight),
+		rangeTag("doorWidth", estateSearchCondition.DoorWidth, estate.DoorWidth),
+		rangeTag("rent", estateSearchCondition.Rent, estate.Rent),
+	}
+	return append(tags, featureTags(estateSearchCondition.Feature.List, estate.Features)...)
+}
+
+// invalidateEstateCache 更新された物件の影響を受けるキャッシュだけを消す
+func invalidateEstateCache(ctx context.Context, before, after Estate) {
+	searchChanged := before.Rent != after.Rent ||
+		before.DoorHeight != after.DoorHeight ||
+		before.DoorWidth != after.DoorWidth ||
+		before.Features != after.Features ||
+		before.Popularity != after.Popularity
+
+	tags := []string{estateIDTag(after.ID)}
+	if searchChanged {
+		tags = append(tags, estateTags(before)...)
+		tags = append(tags, estateTags(after)...)
+	}
+	if before.Rent != after.Rent {
+		tags = append(tags, lowPricedTag)
+	}
+	// おすすめは扉の大きさで絞り人気順に並べるので、どの椅子のおすすめにも新しく入りうる
+	if before.DoorHeight != after.DoorHeight ||
+		before.DoorWidth != after.DoorWidth ||
+		before.Popularity != after.Popularity {
+		tags = append(tags, recommendTag)
+	}
+	estateCache.Invalidate(ctx, uniqueTags(tags)...)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// exportFlushInterval この行数ごとにレスポンスをフラッシュする
+const exportFlushInterval = 1000
+
+// exportWriter 形式ごとに1行ずつレスポンスへ書き出す
+type exportWriter struct {
+	format string
+	res    *echo.Response
+	csv    *csv.Writer
+	enc    *json.Encoder
+	n      int
+}
+
+// newExportWriter headerが渡された場合はCSVの1行目に列名を書く
+func newExportWriter(c echo.Context, format, name string, header []string) (*exportWriter, error) {
+	w := &exportWriter{format: format, res: c.Response()}
+	switch format {
+	case importFormatCSV:
+		w.res.Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+		w.csv = csv.NewWriter(w.res)
+	case importFormatJSON:
+		w.res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+		w.enc = json.NewEncoder(w.res)
+	case importFormatNDJSON:
+		w.res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		w.enc = json.NewEncoder(w.res)
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	w.res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	w.res.WriteHeader(http.StatusOK)
+	if format == importFormatCSV && header != nil {
+		if err := w.csv.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	if format == importFormatJSON {
+		if _, err := w.res.Write([]byte("[")); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *exportWriter) write(record []string, v interface{}) error {
+	var err error
+	switch w.format {
+	case importFormatCSV:
+		err = w.csv.Write(record)
+	case importFormatJSON:
+		if w.n > 0 {
+			if _, err := w.res.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		err = w.enc.Encode(v)
+	default:
+		err = w.enc.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+	w.n++
+	if w.n%exportFlushInterval == 0 {
+		w.flush()
+	}
+	return nil
+}
+
+func (w *exportWriter) flush() {
+	if w.csv != nil {
+		w.csv.Flush()
+	}
+	w.res.Flush()
+}
+
+func (w *exportWriter) close() error {
+	if w.format == importFormatJSON {
+		if _, err := w.res.Write([]byte("]\n")); err != nil {
+			return err
+		}
+	}
+	w.flush()
+	if w.csv != nil {
+		return w.csv.Error()
+	}
+	return nil
+}
+
+//ChairExportQuery 椅子の書き出しの絞り込み条件。数値はnilなら絞らない
+type ChairExportQuery struct {
+	Format  string `query:"format" validate:"enum=export.format"`
+	Header  bool   `query:"header"`
+	MinID   *int64 `query:"minId"`
+	MaxID   *int64 `query:"maxId"`
+	Kind    string `query:"kind"`
+	Color   string `query:"color"`
+	InStock bool   `query:"inStock"`
+}
+
+//EstateExportQuery 物件の書き出しの絞り込み条件。数値はnilなら絞らず、min/maxはidも賃料も両端を含む
+type EstateExportQuery struct {
+	Format  string `query:"format" validate:"enum=export.format"`
+	Header  bool   `query:"header"`
+	MinID   *int64 `query:"minId"`
+	MaxID   *int64 `query:"maxId"`
+	MinRent *int64 `query:"minRent"`
+	MaxRent *int64 `query:"maxRent"`
+}
+
+// exportFilter 共通の絞り込み条件を組み立てる
+type exportFilter struct {
+	conditions []string
+	params     []interface{}
+}
+
+// intParam vがnilなら絞らない
+func (f *exportFilter) intParam(v *int64, condition string) {
+	if v != nil {
+		f.conditions = append(f.conditions, condition)
+		f.params = append(f.params, *v)
+	}
+}
+
+// stringParam vが空なら絞らない
+func (f *exportFilter) stringParam(v, condition string) {
+	if v != "" {
+		f.conditions = append(f.conditions, condition)
+		f.params = append(f.params, v)
+	}
+}
+
+func (f *exportFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// exportFormat 指定がなければCSV
+func exportFormat(format string) string {
+	if format != "" {
+		return format
+	}
+	return importFormatCSV
+}
+
+func exportHeader(header bool, table importTable) []string {
+	if !header {
+		return nil
+	}
+	return table.columns
+}
+
+// beginSnapshot 全ての行を同じ時点のスナップショットから読むためのトランザクション
+func beginSnapshot(ctx context.Context, db *sqlx.DB) (*sqlx.Tx, error) {
+	return db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+func chairRecord(chair Chair) []string {
+	return []string{
+		strconv.FormatInt(chair.ID, 10),
+		chair.Name,
+		chair.Description,
+		chair.Thumbnail,
+		strconv.FormatInt(chair.Price, 10),
+		strconv.FormatInt(chair.Height, 10),
+		strconv.FormatInt(chair.Width, 10),
+		strconv.FormatInt(chair.Depth, 10),
+		chair.Color,
+		chair.Features,
+		chair.Kind,
+		strconv.FormatInt(chair.Popularity, 10),
+		strconv.FormatInt(chair.Stock, 10),
+	}
+}
+
+func estateRecord(estate Estate) []string {
+	return []string{
+		strconv.FormatInt(estate.ID, 10),
+		estate.Name,
+		estate.Description,
+		estate.Thumbnail,
+		estate.Address,
+		strconv.FormatFloat(estate.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(estate.Longitude, 'f', -1, 64),
+		strconv.FormatInt(estate.Rent, 10),
+		strconv.FormatInt(estate.DoorHeight, 10),
+		strconv.FormatInt(estate.DoorWidth, 10),
+		estate.Features,
+		strconv.FormatInt(estate.Popularity, 10),
+	}
+}
+
+// exportChairs postChairでそのまま取り込める形式で全ての椅子を書き出す
+func exportChairs(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	q := c.Get(requestKey).(*ChairExportQuery)
+	format := exportFormat(q.Format)
+	f := exportFilter{}
+	f.intParam(q.MinID, "id >= ?")
+	f.intParam(q.MaxID, "id <= ?")
+	f.stringParam(q.Kind, "kind = ?")
+	f.stringParam(q.Color, "color = ?")
+	if q.InStock {
+		f.conditions = append(f.conditions, "stock > 0")
+	}
+
+	tx, err := beginSnapshot(ctx, db.withState)
+	if err != nil {
+		return internalError("failed to begin tx", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryxContext(ctx, "SELECT * FROM chair"+f.where()+" ORDER BY id", f.params...)
+	if err != nil {
+		return internalError("exportChairs DB execution error", err)
+	}
+	defer rows.Close()
+
+	w, err := newExportWriter(c, format, "chairs", exportHeader(q.Header, chairImportTable))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var chair Chair
+		if err := rows.StructScan(&chair); err != nil {
+			c.Echo().Logger.Errorf("exportChairs scan error : %v", err)
+			return err
+		}
+		if err := w.write(chairRecord(chair), newAdminChair(chair)); err != nil {
+			c.Echo().Logger.Errorf("exportChairs write error : %v", err)
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.Echo().Logger.Errorf("exportChairs DB execution error : %v", err)
+		return err
+	}
+	return w.close()
+}
+
+// exportEstates postEstateでそのまま取り込める形式で全ての物件を書き出す
+func exportEstates(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	q := c.Get(requestKey).(*EstateExportQuery)
+	format := exportFormat(q.Format)
+	f := exportFilter{}
+	f.intParam(q.MinID, "id >= ?")
+	f.intParam(q.MaxID, "id <= ?")
+	f.intParam(q.MinRent, "rent >= ?")
+	f.intParam(q.MaxRent, "rent <= ?")
+
+	tx, err := beginSnapshot(ctx, db.noState)
+	if err != nil {
+		return internalError("failed to begin tx", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryxContext(ctx, "SELECT * FROM estate"+f.where()+" ORDER BY id", f.params...)
+	if err != nil {
+		return internalError("exportEstates DB execution error", err)
+	}
+	defer rows.Close()
+
+	w, err := newExportWriter(c, format, "estates", exportHeader(q.Header, estateImportTable))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var estate Estate
+		if err := rows.StructScan(&estate); err != nil {
+			c.Echo().Logger.Errorf("exportEstates scan error : %v", err)
+			return err
+		}
+		if err := w.write(estateRecord(estate), newAdminEstate(estate)); err != nil {
+			c.Echo().Logger.Errorf("exportEstates write error : %v", err)
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.Echo().Logger.Errorf("exportEstates DB execution error : %v", err)
+		return err
+	}
+	return w.close()
+}
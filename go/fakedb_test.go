@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeDB テストでMySQLの代わりに使う。ハンドラーが投げるクエリの形だけを見て、テーブルの行をidで絞って返す。
+// 書き込みは記録するだけで、行は変えない。INSERT IGNOREは行のあるテーブルには重複として何も挿入しない。
+// 複数行のINSERTは、テーブルか同じ文の前の行と同じidをMySQLと同じく重複として扱う
+type fakeDB struct {
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+	execs  []fakeExec
+}
+
+// fakeExec 受け付けた書き込みの文と引数
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+type fakeTable struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var (
+	fakeCountQuery  = regexp.MustCompile(`^SELECT COUNT\(\*\) FROM`)
+	fakeExistsQuery = regexp.MustCompile(`^SELECT 1 FROM`)
+	fakeMaxIDQuery  = regexp.MustCompile(`^SELECT COALESCE\(MAX\(id\), 0\) FROM`)
+	fakeSelectQuery = regexp.MustCompile(`(?s)^SELECT\s+(.+?)\s+FROM\s+(\w+)`)
+	fakeColumnList  = regexp.MustCompile(`^\w+(?:,\s*\w+)*$`)
+	fakeIDFilter    = regexp.MustCompile(`WHERE (?:\w+\.)?id = \?`)
+	fakeIDsFilter   = regexp.MustCompile(`WHERE (?:\w+\.)?id IN \(`)
+	fakeWriteQuery  = regexp.MustCompile(`^(INSERT|UPDATE|DELETE|CREATE|DROP)\b`)
+	fakeIgnoreQuery = regexp.MustCompile(`^INSERT IGNORE INTO (\w+)`)
+	fakeInsertQuery = regexp.MustCompile(`^INSERT INTO (\w+)\(([\w, ]+)\) VALUES`)
+)
+
+func newFakeDB(tables map[string]*fakeTable) *fakeDB {
+	return &fakeDB{tables: tables}
+}
+
+// open withStateとnoStateのどちらにも使える接続
+func (db *fakeDB) open() *sqlx.DB {
+	return sqlx.NewDb(sql.OpenDB(fakeConnector{db}), "mysql")
+}
+
+func (db *fakeDB) query(query string, args []driver.Value) (driver.Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if m := fakeMaxIDQuery.FindStringSubmatch(query); m != nil {
+		return &fakeRows{columns: []string{"id"}, rows: [][]driver.Value{{int64(0)}}}, nil
+	}
+	m := fakeSelectQuery.FindStringSubmatch(query)
+	if m == nil {
+		return nil, fmt.Errorf("fakedb: unexpected query %q", query)
+	}
+	t, ok := db.tables[m[2]]
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unknown table %q", m[2])
+	}
+	rows := t.filter(query, args)
+
+	switch {
+	case fakeCountQuery.MatchString(query):
+		return &fakeRows{columns: []string{"COUNT(*)"}, rows: [][]driver.Value{{int64(len(rows))}}}, nil
+	case fakeExistsQuery.MatchString(query):
+		res := &fakeRows{columns: []string{"1"}}
+		for range rows {
+			res.rows = append(res.rows, []driver.Value{int64(1)})
+		}
+		return res, nil
+	}
+	if fakeColumnList.MatchString(m[1]) {
+		return t.project(strings.Split(m[1], ","), rows)
+	}
+	return &fakeRows{columns: t.columns, rows: rows}, nil
+}
+
+// project 列の名前だけを並べたSELECTなら、その列だけを返す。式を含むものは全ての列を返す
+func (t *fakeTable) project(columns []string, rows [][]driver.Value) (driver.Rows, error) {
+	indexes := make([]int, 0, len(columns))
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		column = strings.TrimSpace(column)
+		i := 0
+		for i < len(t.columns) && t.columns[i] != column {
+			i++
+		}
+		if i == len(t.columns) {
+			return nil, fmt.Errorf("fakedb: unknown column %q", column)
+		}
+		indexes = append(indexes, i)
+		names = append(names, column)
+	}
+	res := &fakeRows{columns: names}
+	for _, row := range rows {
+		projected := make([]driver.Value, 0, len(indexes))
+		for _, i := range indexes {
+			projected = append(projected, row[i])
+		}
+		res.rows = append(res.rows, projected)
+	}
+	return res, nil
+}
+
+// filter idで絞るクエリなら、その行だけにする。それ以外の条件は見ない
+func (t *fakeTable) filter(query string, args []driver.Value) [][]driver.Value {
+	var ids []driver.Value
+	switch {
+	case fakeIDFilter.MatchString(query):
+		ids = args[:1]
+	case fakeIDsFilter.MatchString(query):
+		ids = args
+	default:
+		return t.rows
+	}
+	res := make([][]driver.Value, 0)
+	for _, row := range t.rows {
+		for _, id := range ids {
+			if row[0] == id {
+				res = append(res, row)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func (db *fakeDB) exec(query string, args []driver.Value) (driver.Result, error) {
+	if !fakeWriteQuery.MatchString(query) {
+		return nil, fmt.Errorf("fakedb: unexpected statement %q", query)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.execs = append(db.execs, fakeExec{query: query, args: args})
+	if m := fakeIgnoreQuery.FindStringSubmatch(query); m != nil {
+		if t, ok := db.tables[m[1]]; ok && len(t.rows) > 0 {
+			return fakeResult{affected: 0}, nil
+		}
+	}
+	if m := fakeInsertQuery.FindStringSubmatch(query); m != nil {
+		if t, ok := db.tables[m[1]]; ok {
+			return t.insert(strings.Split(m[2], ", "), args, strings.Contains(query, "ON DUPLICATE KEY UPDATE"))
+		}
+	}
+	return fakeResult{affected: 1}, nil
+}
+
+// insert 行を追加したとして影響行数を数える。ON DUPLICATE KEY UPDATEなら追加で1、変更で2、変更なしで0
+func (t *fakeTable) insert(columns []string, args []driver.Value, upsert bool) (driver.Result, error) {
+	rows := map[driver.Value]map[string]driver.Value{}
+	for _, row := range t.rows {
+		values := map[string]driver.Value{}
+		for i, column := range t.columns {
+			values[column] = row[i]
+		}
+		rows[row[0]] = values
+	}
+	var affected int64
+	for i := 0; i+len(columns) <= len(args); i += len(columns) {
+		values := map[string]driver.Value{}
+		for j, column := range columns {
+			values[column] = args[i+j]
+		}
+		id := values["id"]
+		existing, ok := rows[id]
+		if !ok {
+			rows[id] = values
+			affected++
+			continue
+		}
+		if !upsert {
+			return nil, &mysql.MySQLError{Number: 1062, Message: fmt.Sprintf("Duplicate entry '%v' for key 'PRIMARY'", id)}
+		}
+		for column, v := range values {
+			if existing[column] != v {
+				rows[id] = values
+				affected += 2
+				break
+			}
+		}
+	}
+	return fakeResult{affected: affected}, nil
+}
+
+// executed prefixで始まる書き込みの引数を、受け付けた順に返す
+func (db *fakeDB) executed(prefix string) [][]driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var res [][]driver.Value
+	for _, e := range db.execs {
+		if strings.HasPrefix(e.query, prefix) {
+			res = append(res, e.args)
+		}
+	}
+	return res
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("fakedb: open with fakeConnector")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// BeginTx 分離レベルと読み取り専用の指定は受け付けるだけ
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+// NumInput 引数の数は確かめない
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.db.exec(s.query, args)
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.db.query(s.query, args)
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+// fakeResult 挿入した行のidはいつも1
+type fakeResult struct {
+	affected int64
+}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/labstack/gommon/log"
+)
+
+// errFlightAborted 先に呼ばれた処理がpanicで終わった場合に待っていた呼び出し元へ返す
+var errFlightAborted = errors.New("flight aborted")
+
+// flightGroup 同じキーで同時に呼ばれた処理を1回にまとめ、結果を全ての呼び出し元で共有する
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Do 同じキーの処理が実行中ならその結果を待つ。sharedは他の呼び出しの結果を受け取ったかどうか
+func (g *flightGroup) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	g.run(key, call, fn)
+	return call.val, call.err, false
+}
+
+// DoAsync 同じキーの処理が実行中でなければ裏で実行する。結果は待たない
+func (g *flightGroup) DoAsync(key string, fn func() (interface{}, error)) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if _, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		return
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	go func() {
+		// 裏で動く処理はリクエストのRecoverミドルウェアの外なので、ここでpanicを止める
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("flight %v panicked : %v", key, r)
+			}
+		}()
+		g.run(key, call, fn)
+	}()
+}
+
+func (g *flightGroup) run(key string, call *flightCall, fn func() (interface{}, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.err = errFlightAborted
+	call.val, call.err = fn()
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/isucon/isucon10-qualify/isuumo/isuumopb"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// grpcIdempotencyKey HTTPのIdempotency-Keyヘッダと同じ値を受け取るメタデータ
+const grpcIdempotencyKey = "idempotency-key"
+
+// grpcIdempotencyReplayed 保存したレスポンスを返したことを示すヘッダのメタデータ
+const grpcIdempotencyReplayed = "idempotent-replayed"
+
+// grpcProtobufContentType idempotency_keyにprotoのレスポンスを保存する時のcontent_type
+const grpcProtobufContentType = "application/x-protobuf"
+
+// grpcAdmissions メソッドごとのadmissionのルート名。HTTPの同じ処理と同時実行数の制限を共有する
+var grpcAdmissions = map[string]string{
+	"/isuumo.Isuumo/GetChair":               "chair_detail",
+	"/isuumo.Isuumo/SearchChairs":           "chair_search",
+	"/isuumo.Isuumo/ListLowPricedChairs":    "chair_low_priced",
+	"/isuumo.Isuumo/BuyChair":               "chair_buy",
+	"/isuumo.Isuumo/GetEstate":              "estate_detail",
+	"/isuumo.Isuumo/SearchEstates":          "estate_search",
+	"/isuumo.Isuumo/ListLowPricedEstates":   "estate_low_priced",
+	"/isuumo.Isuumo/SearchEstatesNazotte":   "estate_nazotte",
+	"/isuumo.Isuumo/ListRecommendedEstates": "recommended_estate",
+}
+
+// grpcCodes APIErrorのステータスコードに対応するgRPCのコード。無いものはINTERNALにする
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.Aborted,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// startGRPCServer HTTPとは別のポートでgRPCを受ける。listenできなければエラーを返し、その後のエラーはログに出す
+func startGRPCServer(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := newGRPCServer()
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Errorf("gRPC server stopped : %v", err)
+		}
+	}()
+	return nil
+}
+
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpcInterceptor()))
+	isuumopb.RegisterIsuumoServer(s, isuumoService{})
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	reflection.Register(s)
+	return s
+}
+
+// grpcInterceptor admissionで同時実行数を制限し、返ったエラーとpanicをgRPCのステータスにする
+func grpcInterceptor() grpc.UnaryServerInterceptor {
+	recovery := grpcRecovery()
+	limiters := make(map[string]*admissionLimiter, len(grpcAdmissions))
+	for method, name := range grpcAdmissions {
+		limiters[method] = admissionFor(name)
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if l := limiters[info.FullMethod]; l != nil {
+			if !l.acquire(ctx) {
+				return nil, grpcError(ctx, info.FullMethod, l.rejected())
+			}
+			defer l.release()
+		}
+		res, err := recovery(ctx, req, info, handler)
+		if err != nil {
+			return nil, grpcError(ctx, info.FullMethod, err)
+		}
+		return res, nil
+	}
+}
+
+// grpcRecovery HTTPのRecoverミドルウェアと同じく、ハンドラーのpanicでプロセスを落とさずINTERNALにする。
+// このgrpcのバージョンにはインターセプターを繋ぐオプションが無いので、grpcInterceptorがハンドラーを包んで呼ぶ
+func grpcRecovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("gRPC %v panicked : %v\n%s", info.FullMethod, r, debug.Stack())
+				res, err = nil, internalError("gRPC handler panicked", fmt.Errorf("%v", r))
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// grpcError httpErrorHandlerと同じくAPIErrorにしてログに出す。codeとfieldはトレーラーで返す
+func grpcError(ctx context.Context, method string, err error) error {
+	e := toAPIError(err)
+	if e.Code == errCodeInternal {
+		log.Errorf("gRPC %v : %v", method, e)
+	} else {
+		log.Infof("gRPC %v : %v", method, e)
+	}
+
+	md := metadata.Pairs("error-code", e.Code)
+	if e.Field != "" {
+		md.Append("error-field", e.Field)
+	}
+	if err := grpc.SetTrailer(ctx, md); err != nil {
+		log.Errorf("failed to set gRPC trailer : %v", err)
+	}
+
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	if e.Code == errCodeTimeout {
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, e.Message)
+}
+
+// isuumoService HTTPのハンドラーと同じ検証・キャッシュ・DBの読み書きを使う
+type isuumoService struct{}
+
+func (isuumoService) GetChair(ctx context.Context, in *isuumopb.GetChairRequest) (*isuumopb.Chair, error) {
+	req := &IDRequest{ID: in.Id}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	if chairCache.SoldOut(req.ID) {
+		return nil, chairSoldOut(req.ID)
+	}
+
+	chair, _, err := chairCache.FetchDetail(ctx, req.ID, func(ctx context.Context) (Chair, error) {
+		return loadChairDetail(ctx, req.ID)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound("chair %v not found", req.ID)
+		}
+		if err == errChairSoldOut {
+			return nil, chairSoldOut(req.ID)
+		}
+		return nil, internalError("Failed to get the chair from id", err)
+	}
+	return chairPB(chair), nil
+}
+
+func (isuumoService) SearchChairs(ctx context.Context, in *isuumopb.SearchChairsRequest) (*isuumopb.SearchChairsResponse, error) {
+	req := &ChairSearchRequest{
+		Page:          int(in.Page),
+		PerPage:       int(in.PerPage),
+		PriceRangeID:  in.PriceRangeId,
+		HeightRangeID: in.HeightRangeId,
+		WidthRangeID:  in.WidthRangeId,
+		DepthRangeID:  in.DepthRangeId,
+		Kind:          in.Kind,
+		Color:         in.Color,
+		Features:      in.Features,
+	}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	s, err := newChairSearch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, _, err := s.fetch(ctx)
+	if err != nil {
+		return nil, internalError("SearchChairs DB execution error", err)
+	}
+	return &isuumopb.SearchChairsResponse{Count: res.Count, Chairs: chairsPB(res.Chairs)}, nil
+}
+
+func (isuumoService) ListLowPricedChairs(ctx context.Context, in *isuumopb.ListLowPricedChairsRequest) (*isuumopb.ChairList, error) {
+	chairs, _, err := chairCache.FetchLowPriced(ctx, loadLowPricedChairs)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, internalError("ListLowPricedChairs DB execution error", err)
+	}
+	return &isuumopb.ChairList{Chairs: chairsPB(chairs)}, nil
+}
+
+// BuyChair メタデータのidempotency-keyがあれば、HTTPのIdempotency-Keyと同じく一度だけ注文し、再送には保存した結果を返す
+func (isuumoService) BuyChair(ctx context.Context, in *isuumopb.BuyChairRequest) (*isuumopb.BuyChairResponse, error) {
+	req := &BuyChairRequest{ID: in.Id, Email: in.Email}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+
+	key := incomingMetadata(ctx, grpcIdempotencyKey)
+	if key == "" {
+		return buyChairPB(ctx, req)
+	}
+	if err := checkIdempotencyKey(grpcIdempotencyKey, key); err != nil {
+		return nil, err
+	}
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return nil, internalError("failed to marshal BuyChairRequest", err)
+	}
+	fingerprint := requestFingerprint("gRPC", "/isuumo.Isuumo/BuyChair", body)
+
+	client := grpcClient(ctx)
+	record, reserved, err := reserveIdempotencyKey(ctx, client, key, fingerprint)
+	if err != nil {
+		return nil, internalError("failed to reserve idempotency key", err)
+	}
+	if !reserved {
+		if err := checkIdempotentReplay(record, fingerprint); err != nil {
+			return nil, err
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(grpcIdempotencyReplayed, "true")); err != nil {
+			log.Errorf("failed to set gRPC header : %v", err)
+		}
+		return replayBuyChair(record)
+	}
+
+	saved := false
+	defer func() {
+		// パニックやキャンセルで保存できなかった予約は、再送でやり直せるように取り消す
+		if !saved {
+			releaseIdempotencyKey(client, key)
+		}
+	}()
+
+	res, err := buyChairPB(ctx, req)
+	if err != nil {
+		// サーバ側の失敗は再送でやり直せるように保存しない
+		if e := toAPIError(err); e.Status < http.StatusInternalServerError {
+			if b, err := json.Marshal(e); err == nil {
+				saved = saveIdempotentResponse(client, key, e.Status, echo.MIMEApplicationJSON, b)
+			}
+		}
+		return nil, err
+	}
+	if b, err := proto.Marshal(res); err == nil {
+		saved = saveIdempotentResponse(client, key, http.StatusOK, grpcProtobufContentType, b)
+	}
+	return res, nil
+}
+
+func buyChairPB(ctx context.Context, req *BuyChairRequest) (*isuumopb.BuyChairResponse, error) {
+	orderID, err := purchaseChair(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &isuumopb.BuyChairResponse{OrderId: orderID}, nil
+}
+
+// replayBuyChair 保存した注文の結果かエラーを返す。HTTPで同じキーを使った結果はfingerprintが違うのでここには来ない
+func replayBuyChair(record IdempotencyRecord) (*isuumopb.BuyChairResponse, error) {
+	if record.StatusCode != http.StatusOK {
+		e := &APIError{}
+		if err := json.Unmarshal(record.ResponseBody, e); err != nil {
+			return nil, internalError("failed to unmarshal the saved error", err)
+		}
+		e.Status = record.StatusCode
+		return nil, e
+	}
+	res := &isuumopb.BuyChairResponse{}
+	if err := proto.Unmarshal(record.ResponseBody, res); err != nil {
+		return nil, internalError("failed to unmarshal the saved BuyChairResponse", err)
+	}
+	return res, nil
+}
+
+// incomingMetadata クライアントが送ったメタデータの最初の値
+func incomingMetadata(ctx context.Context, name string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcClient idempotency_keyのclient。HTTPのclientIPと同じくポートを除いたアドレスにする
+func grpcClient(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func (isuumoService) GetEstate(ctx context.Context, in *isuumopb.GetEstateRequest) (*isuumopb.Estate, error) {
+	req := &IDRequest{ID: in.Id}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+
+	var estate Estate
+	err := db.noState.GetContext(ctx, &estate, "SELECT * FROM estate WHERE id = ?", req.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound("estate %v not found", req.ID)
+		}
+		return nil, internalError("Database Execution error", err)
+	}
+	return estatePB(estate), nil
+}
+
+func (isuumoService) SearchEstates(ctx context.Context, in *isuumopb.SearchEstatesRequest) (*isuumopb.SearchEstatesResponse, error) {
+	req := &EstateSearchRequest{
+		Page:              int(in.Page),
+		PerPage:           int(in.PerPage),
+		DoorHeightRangeID: in.DoorHeightRangeId,
+		DoorWidthRangeID:  in.DoorWidthRangeId,
+		RentRangeID:       in.RentRangeId,
+		Features:          in.Features,
+	}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	s, err := newEstateSearch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, _, err := s.fetch(ctx)
+	if err != nil {
+		return nil, internalError("SearchEstates DB execution error", err)
+	}
+	return &isuumopb.SearchEstatesResponse{Count: res.Count, Estates: estatesPB(res.Estates)}, nil
+}
+
+func (isuumoService) ListLowPricedEstates(ctx context.Context, in *isuumopb.ListLowPricedEstatesRequest) (*isuumopb.EstateList, error) {
+	estates, _, err := estateCache.FetchLowPriced(ctx, loadLowPricedEstates)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, internalError("ListLowPricedEstates DB execution error", err)
+	}
+	return &isuumopb.EstateList{Estates: estatesPB(estates)}, nil
+}
+
+func (isuumoService) SearchEstatesNazotte(ctx context.Context, in *isuumopb.SearchEstatesNazotteRequest) (*isuumopb.SearchEstatesResponse, error) {
+	req := &Coordinates{Coordinates: make([]Coordinate, 0, len(in.Coordinates))}
+	for _, c := range in.Coordinates {
+		req.Coordinates = append(req.Coordinates, Coordinate{Latitude: c.Latitude, Longitude: c.Longitude})
+	}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+
+	estates, err := findEstatesInPolygon(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &isuumopb.SearchEstatesResponse{Count: int64(len(estates)), Estates: estatesPB(estates)}, nil
+}
+
+func (isuumoService) ListRecommendedEstates(ctx context.Context, in *isuumopb.ListRecommendedEstatesRequest) (*isuumopb.EstateList, error) {
+	req := &IDRequest{ID: in.ChairId}
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+
+	estates, _, err := estateCache.FetchRecommended(ctx, req.ID, func(ctx context.Context) ([]Estate, error) {
+		return loadRecommendedEstates(ctx, req.ID)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound("chair %v not found", req.ID)
+		}
+		return nil, internalError("Database execution error", err)
+	}
+	return &isuumopb.EstateList{Estates: estatesPB(estates)}, nil
+}
+
+func chairPB(chair Chair) *isuumopb.Chair {
+	return &isuumopb.Chair{
+		Id:          chair.ID,
+		Name:        chair.Name,
+		Description: chair.Description,
+		Thumbnail:   chair.Thumbnail,
+		Price:       chair.Price,
+		Height:      chair.Height,
+		Width:       chair.Width,
+		Depth:       chair.Depth,
+		Color:       chair.Color,
+		Features:    chair.Features,
+		Kind:        chair.Kind,
+	}
+}
+
+func chairsPB(chairs []Chair) []*isuumopb.Chair {
+	res := make([]*isuumopb.Chair, 0, len(chairs))
+	for _, chair := range chairs {
+		res = append(res, chairPB(chair))
+	}
+	return res
+}
+
+func estatePB(estate Estate) *isuumopb.Estate {
+	return &isuumopb.Estate{
+		Id:          estate.ID,
+		Thumbnail:   estate.Thumbnail,
+		Name:        estate.Name,
+		Description: estate.Description,
+		Latitude:    estate.Latitude,
+		Longitude:   estate.Longitude,
+		Address:     estate.Address,
+		Rent:        estate.Rent,
+		DoorHeight:  estate.DoorHeight,
+		DoorWidth:   estate.DoorWidth,
+		Features:    estate.Features,
+	}
+}
+
+func estatesPB(estates []Estate) []*isuumopb.Estate {
+	res := make([]*isuumopb.Estate, 0, len(estates))
+	for _, estate := range estates {
+		res = append(res, estatePB(estate))
+	}
+	return res
+}
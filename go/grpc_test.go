@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/isucon/isucon10-qualify/isuumo/isuumopb"
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCInterceptorRecoversPanic(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/isuumo.Isuumo/GetChair"}
+	panicking := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	}
+	intercept := grpcInterceptor()
+	for i := 0; i < 2; i++ {
+		// 2回目も通れば、panicしたリクエストのadmissionの枠は返っている
+		res, err := intercept(context.Background(), nil, info, panicking)
+		if res != nil {
+			t.Errorf("response %v from a panicking handler", res)
+		}
+		if code := status.Code(err); code != codes.Internal {
+			t.Fatalf("code %v for a panicking handler, want %v", code, codes.Internal)
+		}
+	}
+}
+
+func TestBuyChairIdempotencyKey(t *testing.T) {
+	in := &isuumopb.BuyChairRequest{Id: 1, Email: "buyer@example.com"}
+	body, err := proto.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := requestFingerprint("gRPC", "/isuumo.Isuumo/BuyChair", body)
+	withKey := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcIdempotencyKey, "k1"))
+
+	// 初めてのキーは注文する
+	res, err := isuumoService{}.BuyChair(withKey, in)
+	if err != nil || res.OrderId != 1 {
+		t.Fatalf("BuyChair = %v, %v, want order 1", res, err)
+	}
+
+	saved, err := proto.Marshal(&isuumopb.BuyChairResponse{OrderId: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		row  []driver.Value
+		code codes.Code
+	}{
+		{"replayed", []driver.Value{"", "k1", fingerprint, int64(200), grpcProtobufContentType, saved}, codes.OK},
+		{"replayed error", []driver.Value{"", "k1", fingerprint, int64(409), echo.MIMEApplicationJSON, []byte(`{"code":"sold_out","message":"sold out"}`)}, codes.Aborted},
+		{"in progress", []driver.Value{"", "k1", fingerprint, int64(0), "", []byte{}}, codes.Aborted},
+		{"other request", []driver.Value{"", "k1", "other", int64(200), grpcProtobufContentType, saved}, codes.FailedPrecondition},
+	}
+	for _, tc := range cases {
+		withTables(func(tables map[string]*fakeTable) { tables["idempotency_key"].rows = [][]driver.Value{tc.row} }, func(*fakeDB) {
+			res, err := grpcInterceptor()(withKey, in, &grpc.UnaryServerInfo{FullMethod: "/isuumo.Isuumo/BuyChair"},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return isuumoService{}.BuyChair(ctx, req.(*isuumopb.BuyChairRequest))
+				})
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("%v: code %v, want %v", tc.name, code, tc.code)
+			}
+			if tc.code == codes.OK && res.(*isuumopb.BuyChairResponse).OrderId != 42 {
+				t.Errorf("%v: order %v, want the saved order 42", tc.name, res)
+			}
+		})
+	}
+}
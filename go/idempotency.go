@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+const idempotencyReplayedHeader = "Idempotent-Replayed"
+const idempotencyKeyMaxLength = 255
+
+// idempotencyRetention 保存したレスポンスを再送に返す期間
+var idempotencyRetention = 24 * time.Hour
+
+// idempotencyLease 実行中の予約を守る期間。プロセスが落ちて残った予約は、これを過ぎたら再送で取り直せる
+var idempotencyLease = 30 * time.Second
+
+// idempotencyReserveAttempts 予約と取り消しが競った時に予約を試みる回数。取れなければ実行中として409を返す
+const idempotencyReserveAttempts = 3
+
+// trustedProxies X-Real-IPでクライアントのアドレスを渡してくるプロキシ。TRUSTED_PROXIESにCIDRをカンマ区切りで指定する
+var trustedProxies = mustParseCIDRs("127.0.0.0/8,::1/128")
+
+//IdempotencyRecord Idempotency-Keyごとに保存したリクエストとレスポンス
+type IdempotencyRecord struct {
+	Client       string `db:"client"`
+	Key          string `db:"idempotency_key"`
+	Fingerprint  string `db:"fingerprint"`
+	StatusCode   int    `db:"status_code"`
+	ContentType  string `db:"content_type"`
+	ResponseBody []byte `db:"response_body"`
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotency Idempotency-Keyヘッダ付きのリクエストを一度だけ実行し、再送には保存したレスポンスを返す。
+// キーはクライアントのアドレスごとに別のものとして扱う。bindRequestの後に置き、検査で弾いたリクエストは予約しない
+func idempotency(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			return next(c)
+		}
+		if err := checkIdempotencyKey(idempotencyKeyHeader, key); err != nil {
+			return err
+		}
+		ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+		// 本文はbindRequestが読んだので、読んだ値で同じリクエストか判定する
+		body, err := json.Marshal(c.Get(requestKey))
+		if err != nil {
+			return internalError("failed to encode request", err)
+		}
+		fingerprint := requestFingerprint(c.Request().Method, c.Request().URL.Path, body)
+
+		client := clientIP(c)
+		record, reserved, err := reserveIdempotencyKey(ctx, client, key, fingerprint)
+		if err != nil {
+			if apiErr, ok := err.(*APIError); ok {
+				return apiErr
+			}
+			return internalError("failed to reserve idempotency key", err)
+		}
+		if !reserved {
+			if err := checkIdempotentReplay(record, fingerprint); err != nil {
+				return err
+			}
+			c.Response().Header().Set(idempotencyReplayedHeader, "true")
+			if len(record.ResponseBody) == 0 {
+				return c.NoContent(record.StatusCode)
+			}
+			return c.Blob(record.StatusCode, record.ContentType, record.ResponseBody)
+		}
+
+		saved := false
+		defer func() {
+			// パニックやキャンセルで保存できなかった予約は、再送でやり直せるように取り消す
+			if !saved {
+				releaseIdempotencyKey(client, key)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rec
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		status := c.Response().Status
+		if status >= http.StatusInternalServerError {
+			// サーバ側の失敗は再送でやり直せるように予約を取り消す
+			return nil
+		}
+		saved = saveIdempotentResponse(client, key, status, c.Response().Header().Get(echo.HeaderContentType), rec.body.Bytes())
+		return nil
+	}
+}
+
+func checkIdempotencyKey(field, key string) error {
+	if len(key) > idempotencyKeyMaxLength {
+		return invalidParam(field, fmt.Errorf("too long : %v", len(key)))
+	}
+	return nil
+}
+
+// checkIdempotentReplay 予約できなかったキーのレコードを再送に返せるか確かめる
+func checkIdempotentReplay(record IdempotencyRecord, fingerprint string) error {
+	if record.Fingerprint != fingerprint {
+		return newAPIError(http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused, "Idempotency-Key was used for a different request", nil)
+	}
+	if record.StatusCode == 0 {
+		return idempotencyKeyInProgress()
+	}
+	return nil
+}
+
+func idempotencyKeyInProgress() *APIError {
+	return newAPIError(http.StatusConflict, errCodeIdempotencyKeyInProgress, "a request with the same Idempotency-Key is still in progress", nil)
+}
+
+// clientIP idempotency_keyのclient。信用するプロキシからのリクエストだけX-Real-IPを使い、それ以外は接続元のアドレスにする
+func clientIP(c echo.Context) string {
+	req := c.Request()
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && containsIP(trustedProxies, ip) {
+		if real := req.Header.Get(echo.HeaderXRealIP); real != "" {
+			return real
+		}
+	}
+	return host
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(s string) []*net.IPNet {
+	nets, err := parseCIDRs(s)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// requestFingerprint 同じキーで別のリクエストが送られていないか判定するためのハッシュ
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reserveIdempotencyKey キーを予約する。既に使われていた場合は保存済みのレコードを返す。
+// 保存期間を過ぎたレコードと、リースを過ぎても実行中のままの予約は取り直す
+func reserveIdempotencyKey(ctx context.Context, client, key, fingerprint string) (IdempotencyRecord, bool, error) {
+	var record IdempotencyRecord
+	for i := 0; i < idempotencyReserveAttempts; i++ {
+		now := time.Now()
+		_, err := db.withState.ExecContext(ctx, "DELETE FROM idempotency_key WHERE client = ? AND idempotency_key = ? AND (created_at < ? OR (status_code = 0 AND created_at < ?))",
+			client, key, now.Add(-idempotencyRetention), now.Add(-idempotencyLease))
+		if err != nil {
+			return record, false, err
+		}
+		res, err := db.withState.ExecContext(ctx, "INSERT IGNORE INTO idempotency_key(client, idempotency_key, fingerprint, response_body, created_at) VALUES(?,?,?,?,?)", client, key, fingerprint, []byte{}, now)
+		if err != nil {
+			return record, false, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return record, false, err
+		} else if n == 1 {
+			return record, true, nil
+		}
+
+		err = db.withState.GetContext(ctx, &record, "SELECT client, idempotency_key, fingerprint, status_code, content_type, response_body FROM idempotency_key WHERE client = ? AND idempotency_key = ?", client, key)
+		if err != sql.ErrNoRows {
+			return record, false, err
+		}
+		// 予約が取り消された直後なのでもう一度予約を試みる
+	}
+	return record, false, idempotencyKeyInProgress()
+}
+
+// saveIdempotentResponse 予約したキーにレスポンスを保存する。リクエストがキャンセルされても保存できるようにリクエストのcontextは使わない。
+// 保存できなければfalseを返すので、呼び出し側で予約を取り消す
+func saveIdempotentResponse(client, key string, status int, contentType string, body []byte) bool {
+	_, err := db.withState.Exec("UPDATE idempotency_key SET status_code = ?, content_type = ?, response_body = ? WHERE client = ? AND idempotency_key = ?", status, contentType, body, client, key)
+	if err != nil {
+		log.Errorf("failed to save idempotent response : %v", err)
+		return false
+	}
+	return true
+}
+
+// releaseIdempotencyKey 予約を取り消す。消せなかった予約もidempotencyLeaseを過ぎれば取り直せる
+func releaseIdempotencyKey(client, key string) {
+	if _, err := db.withState.Exec("DELETE FROM idempotency_key WHERE client = ? AND idempotency_key = ? AND status_code = 0", client, key); err != nil {
+		log.Errorf("failed to release idempotency key : %v", err)
+	}
+}
+
+// purgeIdempotencyKeys 保存期間を過ぎたキーを定期的に削除する
+func purgeIdempotencyKeys(interval time.Duration) {
+	for range time.Tick(interval) {
+		_, err := db.withState.Exec("DELETE FROM idempotency_key WHERE created_at < ?", time.Now().Add(-idempotencyRetention))
+		if err != nil {
+			log.Errorf("failed to purge idempotency keys : %v", err)
+		}
+	}
+}
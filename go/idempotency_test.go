@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const buyBody = `{"email":"buyer@example.com"}`
+
+// buyFingerprint /api/chair/buy/1にbuyBodyを送った時のfingerprint
+func buyFingerprint(t *testing.T) string {
+	t.Helper()
+	body, err := json.Marshal(&BuyChairRequest{ID: 1, Email: "buyer@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requestFingerprint(http.MethodPost, "/api/chair/buy/1", body)
+}
+
+var idempotentHeader = http.Header{idempotencyKeyHeader: {"k1"}}
+
+func TestIdempotencyReplaysSavedResponse(t *testing.T) {
+	e := newEcho(nil)
+	withTables(func(tables map[string]*fakeTable) {
+		tables["idempotency_key"].rows = [][]driver.Value{
+			{"192.0.2.1", "k1", buyFingerprint(t), int64(http.StatusOK), "application/json", []byte(`{"saved":true}`)},
+		}
+	}, func(fake *fakeDB) {
+		rec := serveRequest(e, http.MethodPost, "/api/chair/buy/1", buyBody, idempotentHeader)
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"saved":true}` {
+			t.Fatalf("status %v, body %s, want the saved response", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get(idempotencyReplayedHeader) != "true" {
+			t.Errorf("%v header missing from a replay", idempotencyReplayedHeader)
+		}
+		if bought := fake.executed("UPDATE chair SET stock = stock - 1"); len(bought) > 0 {
+			t.Errorf("replay bought the chair again: %v", bought)
+		}
+	})
+}
+
+func TestIdempotencyRejectsReusedKey(t *testing.T) {
+	cases := []struct {
+		name        string
+		fingerprint func(t *testing.T) string
+		status      int
+		code        int
+	}{
+		{"different request", func(*testing.T) string { return "other" }, http.StatusOK, http.StatusUnprocessableEntity},
+		// 保存前の予約はリースの間は実行中として扱う
+		{"in progress", buyFingerprint, 0, http.StatusConflict},
+	}
+	e := newEcho(nil)
+	for _, tc := range cases {
+		withTables(func(tables map[string]*fakeTable) {
+			tables["idempotency_key"].rows = [][]driver.Value{
+				{"192.0.2.1", "k1", tc.fingerprint(t), int64(tc.status), "", []byte{}},
+			}
+		}, func(fake *fakeDB) {
+			rec := serveRequest(e, http.MethodPost, "/api/chair/buy/1", buyBody, idempotentHeader)
+			if rec.Code != tc.code {
+				t.Errorf("%v: status %v, want %v: %s", tc.name, rec.Code, tc.code, rec.Body.String())
+			}
+			if bought := fake.executed("UPDATE chair SET stock = stock - 1"); len(bought) > 0 {
+				t.Errorf("%v: bought the chair: %v", tc.name, bought)
+			}
+		})
+	}
+}
+
+func TestIdempotencySavesResponse(t *testing.T) {
+	e := newEcho(nil)
+	withTables(func(tables map[string]*fakeTable) {}, func(fake *fakeDB) {
+		rec := serveRequest(e, http.MethodPost, "/api/chair/buy/1", buyBody, idempotentHeader)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %v, want 200: %s", rec.Code, rec.Body.String())
+		}
+		reserved := fake.executed("INSERT IGNORE INTO idempotency_key")
+		if len(reserved) != 1 || reserved[0][0] != "192.0.2.1" || reserved[0][2] != buyFingerprint(t) {
+			t.Errorf("reserved %v, want k1 for 192.0.2.1 with the request fingerprint", reserved)
+		}
+		if saved := fake.executed("UPDATE idempotency_key SET status_code"); len(saved) != 1 || saved[0][0] != int64(http.StatusOK) {
+			t.Errorf("saved %v, want the 200 response", saved)
+		}
+	})
+}
+
+func TestIdempotencySkipsInvalidRequests(t *testing.T) {
+	e := newEcho(nil)
+	withTables(func(tables map[string]*fakeTable) {}, func(fake *fakeDB) {
+		rec := serveRequest(e, http.MethodPost, "/api/chair/buy/1", `{"email":"not an address"}`, idempotentHeader)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status %v, want 400: %s", rec.Code, rec.Body.String())
+		}
+		// 検査で弾いたリクエストは保存しないので、直して同じキーで送り直せる
+		if reserved := fake.executed("INSERT IGNORE INTO idempotency_key"); len(reserved) > 0 {
+			t.Errorf("reserved the key for an invalid request: %v", reserved)
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name   string
+		remote string
+		realIP string
+		want   string
+	}{
+		{"direct", "192.0.2.1:1234", "", "192.0.2.1"},
+		{"trusted proxy", "127.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"untrusted proxy", "192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+	}
+	e := newEcho(nil)
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		// X-Forwarded-Forは信用しない
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		if got := clientIP(e.NewContext(req, httptest.NewRecorder())); got != tc.want {
+			t.Errorf("%v: client %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const (
+	importModeInsert  = "insert"
+	importModeUpsert  = "upsert"
+	importModeReplace = "replace"
+	importModeDelete  = "delete"
+)
+
+// mysqlMaxPlaceholders 1つのプリペアドステートメントで使えるプレースホルダの上限
+const mysqlMaxPlaceholders = 65535
+
+var errInvalidImportMode = errors.New("invalid import mode")
+
+// importBatchSize 1つのINSERT文にまとめる行数
+var importBatchSize = 500
+
+// importMaxFieldSize multipartでファイル以外のフィールドの最大サイズ
+const importMaxFieldSize = 64 * 1024
+
+// importMaxErrors 報告に入れるエラーの上限。import_job.errorsに入れるので大きなファイルでも溢れないようにする
+var importMaxErrors = 100
+
+//ImportReport インポート前のバリデーション結果
+type ImportReport struct {
+	Rows  int `json:"rows"`
+	Valid int `json:"valid"`
+	// ErrorCount 見つかったエラーの数。Errorsには先頭のimportMaxErrors件だけを入れ、溢れたらTruncatedにする
+	ErrorCount int              `json:"errorCount"`
+	Truncated  bool             `json:"truncated"`
+	Errors     []ImportRowError `json:"errors"`
+}
+
+func (r *ImportReport) addErrors(errs ...ImportRowError) {
+	r.ErrorCount += len(errs)
+	if room := importMaxErrors - len(r.Errors); len(errs) > room {
+		errs = errs[:room]
+		r.Truncated = true
+	}
+	r.Errors = append(r.Errors, errs...)
+}
+
+//ImportSummary CSVインポートの結果
+type ImportSummary struct {
+	Mode      string `json:"mode"`
+	Inserted  int64  `json:"inserted"`
+	Updated   int64  `json:"updated"`
+	Unchanged int64  `json:"unchanged"`
+	Deleted   int64  `json:"deleted"`
+
+	Metrics ImportMetrics `json:"metrics"`
+}
+
+//ImportMetrics インポートのスループット
+type ImportMetrics struct {
+	Rows       int64   `json:"rows"`
+	Batches    int64   `json:"batches"`
+	ElapsedMs  int64   `json:"elapsedMs"`
+	RowsPerSec float64 `json:"rowsPerSec"`
+}
+
+//ImportQuery インポートのパラメータ。multipartならファイルより前のフィールドでも送れる
+type ImportQuery struct {
+	Mode      string `query:"mode" validate:"enum=import.mode"`
+	DryRun    bool   `query:"dryRun"`
+	Header    bool   `query:"header"`
+	ColumnMap string `query:"columnMap"`
+	Async     bool   `query:"async"`
+}
+
+// importRow CSVの1行をテーブルの列順に並べたもの
+type importRow struct {
+	ID     int64
+	Values []interface{}
+	// Line DBに書き込めなかった行を報告する時の行番号
+	Line int
+}
+
+// mysqlErrDuplicateEntry 主キーが重複した時のMySQLのエラー番号
+const mysqlErrDuplicateEntry = 1062
+
+// duplicateEntryPattern MySQLのエラーメッセージから重複したidを読む
+var duplicateEntryPattern = regexp.MustCompile(`Duplicate entry '(-?\d+)' for key '(?:\w+\.)?PRIMARY'`)
+
+// importRowConflict DBに書き込めなかった行。500にせず、検査で見つかった不正な行と同じく報告する
+type importRowConflict struct {
+	ImportRowError
+}
+
+func (e *importRowConflict) Error() string {
+	return e.Reason
+}
+
+// importTable インポート先のテーブル定義
+type importTable struct {
+	name    string
+	columns []string
+	// fields JSON/NDJSONでのフィールド名。Chair/EstateのJSONタグと同じ名前を列順に並べる
+	fields []string
+	// aliases ヘッダ付きCSVで受け付ける別名
+	aliases map[string]string
+	parse   func(r *recordReader) importRow
+	// afterCommit コミット後にキャッシュの更新や通知を行う
+	afterCommit func(ctx context.Context, mode string, result importResult)
+}
+
+// handleImport chairs/estatesのアップロードを受け付ける。
+// multipartのfieldのファイルか、JSON/NDJSONのリクエストボディを読む
+func handleImport(c echo.Context, table importTable, field string) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	q := c.Get(requestKey).(*ImportQuery)
+	var format string
+	var body io.ReadCloser
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+		part, err := importFormFile(c, q, field)
+		if err != nil {
+			return err
+		}
+		format = importFormatOf(part.Header.Get(echo.HeaderContentType), part.FileName())
+		body = part
+	} else {
+		format = importFormatOf(contentType, "")
+		if format == importFormatCSV && !strings.HasPrefix(contentType, "text/csv") {
+			return newAPIError(http.StatusUnsupportedMediaType, errCodeUnsupportedMedia, "unsupported content type "+contentType, nil)
+		}
+		body = c.Request().Body
+	}
+	defer body.Close()
+
+	mode, err := parseImportMode(q.Mode)
+	if err != nil {
+		return invalidParam("mode", err)
+	}
+	columnMap, err := parseColumnMap(table, q.ColumnMap)
+	if err != nil {
+		return invalidParam("columnMap", err)
+	}
+	opts := ImportSourceOptions{
+		Header:    q.Header,
+		ColumnMap: columnMap,
+	}
+
+	if q.Async && !q.DryRun {
+		return enqueueImportJob(c, table, mode, format, opts, body)
+	}
+
+	result, report, err := importRows(ctx, table, mode, q.DryRun, newImportSource(format, table, mode, body, opts), nil)
+	if err != nil {
+		return internalError("failed to import "+table.name, err)
+	}
+	if report.ErrorCount > 0 {
+		return invalidImportRows(table, report)
+	}
+	if q.DryRun {
+		return c.JSON(http.StatusOK, report)
+	}
+	metrics := result.Summary.Metrics
+	c.Logger().Infof("imported %v %v rows in %v batches, %vms (%.0f rows/s)", metrics.Rows, table.name, metrics.Batches, metrics.ElapsedMs, metrics.RowsPerSec)
+	table.afterCommit(ctx, mode, result)
+
+	if mode == importModeDelete {
+		return c.JSON(http.StatusOK, result.Summary)
+	}
+	return c.JSON(http.StatusCreated, result.Summary)
+}
+
+// importFormFile multipartを先頭から読み、fieldのファイルのpartを返す。ファイルをメモリや一時ファイルに溜めずに読めるよう、
+// ファイルより前のフィールドだけをクエリのパラメータと同じくqに入れて検査する。ファイルより後のフィールドは読まない
+func importFormFile(c echo.Context, q *ImportQuery, field string) (*multipart.Part, error) {
+	mr, err := c.Request().MultipartReader()
+	if err != nil {
+		return nil, invalidBody(err)
+	}
+	form := url.Values{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, missingParam(field)
+		}
+		if err != nil {
+			return nil, invalidBody(err)
+		}
+		if part.FormName() == field {
+			if err := decodeValues(form, "query", q); err != nil {
+				return nil, err
+			}
+			if err := c.Validate(q); err != nil {
+				return nil, err
+			}
+			return part, nil
+		}
+		value, err := ioutil.ReadAll(io.LimitReader(part, importMaxFieldSize+1))
+		part.Close()
+		if err != nil {
+			return nil, invalidBody(err)
+		}
+		if len(value) > importMaxFieldSize {
+			return nil, invalidParamf(part.FormName(), "must be at most %d bytes", importMaxFieldSize)
+		}
+		form.Add(part.FormName(), string(value))
+	}
+}
+
+// invalidImportRows 不正な行があれば何も取り込まず、行ごとのエラーをdetailsに入れて返す
+func invalidImportRows(table importTable, report ImportReport) *APIError {
+	e := newAPIError(http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("%v of %v %v rows are invalid", report.ErrorCount, report.Rows, table.name), nil)
+	e.Details = report
+	return e
+}
+
+var importTables = map[string]importTable{
+	"chair":  chairImportTable,
+	"estate": estateImportTable,
+}
+
+// importResult インポート後のキャッシュ更新や通知に使う情報。大きなファイルでもメモリを使わないようにidは持たない
+type importResult struct {
+	Summary ImportSummary
+}
+
+func parseImportMode(s string) (string, error) {
+	switch s {
+	case "":
+		return importModeInsert, nil
+	case importModeInsert, importModeUpsert, importModeReplace, importModeDelete:
+		return s, nil
+	}
+	return "", errInvalidImportMode
+}
+
+// insertQuery n行分のVALUESをまとめたINSERT文
+func (t importTable) insertQuery(mode string, n int) string {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(t.columns)), ",") + ")"
+	values := strings.TrimSuffix(strings.Repeat(placeholders+",", n), ",")
+	query := fmt.Sprintf("INSERT INTO %s(%s) VALUES %s", t.name, strings.Join(t.columns, ", "), values)
+	if mode == importModeInsert {
+		return query
+	}
+	updates := make([]string, 0, len(t.columns)-1)
+	for _, col := range t.columns[1:] {
+		updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", col, col))
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// parseImportRecord 1行を検査してテーブルの列順に並べる
+func parseImportRecord(table importTable, mode string, line int, record []string) (importRow, []ImportRowError) {
+	var row importRow
+	var r *recordReader
+	if mode == importModeDelete && len(record) == 1 {
+		// 削除はidだけの行も受け付ける
+		r = newRecordReader(line, record, []string{"id"})
+	} else {
+		r = newRecordReader(line, record, table.columns)
+	}
+	if mode == importModeDelete {
+		row.ID = r.Int("id", 0)
+		row.Values = []interface{}{row.ID}
+	} else {
+		row = table.parse(r)
+	}
+	row.Line = line
+	return row, r.Errors()
+}
+
+// importRows 1行ずつ読みながら検査し、batchSize行ごとにまとめて2つのDBへ書き込む。
+// 不正な行が見つかった後は書き込みをやめて検査だけを続け、最後にロールバックする
+// progressが渡された場合は書き込んだ行数をバッチごとに通知する
+func importRows(ctx context.Context, table importTable, mode string, dryRun bool, src importSource, progress func(rows int64)) (importResult, ImportReport, error) {
+	started := time.Now()
+	report := ImportReport{Errors: []ImportRowError{}}
+
+	var im *importer
+	if !dryRun {
+		var err error
+		im, err = beginImport(ctx, table, mode)
+		if err != nil {
+			return importResult{}, report, err
+		}
+		im.progress = progress
+		defer im.rollback()
+	}
+
+	for {
+		line, record, errs, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if se, ok := err.(*importSyntaxError); ok {
+				report.addErrors(se.ImportRowError)
+				break
+			}
+			return importResult{}, report, err
+		}
+		report.Rows++
+
+		var row importRow
+		if len(errs) == 0 {
+			row, errs = parseImportRecord(table, mode, line, record)
+		}
+		if len(errs) > 0 {
+			report.addErrors(errs...)
+			continue
+		}
+		report.Valid++
+		if im == nil || report.ErrorCount > 0 {
+			continue
+		}
+		if err := im.add(ctx, row); err != nil {
+			if ce, ok := err.(*importRowConflict); ok {
+				report.Valid--
+				report.addErrors(ce.ImportRowError)
+				continue
+			}
+			return importResult{}, report, err
+		}
+	}
+	if im == nil || report.ErrorCount > 0 {
+		return importResult{}, report, nil
+	}
+
+	if err := im.commit(ctx); err != nil {
+		if ce, ok := err.(*importRowConflict); ok {
+			report.Valid--
+			report.addErrors(ce.ImportRowError)
+			return importResult{}, report, nil
+		}
+		return importResult{}, report, err
+	}
+	metrics := &im.result.Summary.Metrics
+	metrics.ElapsedMs = time.Since(started).Milliseconds()
+	if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+		metrics.RowsPerSec = float64(metrics.Rows) / elapsed
+	}
+	return im.result, report, nil
+}
+
+// importer 2つのDBのトランザクションとまだ書き込んでいない行を持つ
+type importer struct {
+	table     importTable
+	mode      string
+	batchSize int
+	tx1       *sqlx.Tx
+	tx2       *sqlx.Tx
+	batch     []importRow
+	result    importResult
+	progress  func(rows int64)
+}
+
+func beginImport(ctx context.Context, table importTable, mode string) (*importer, error) {
+	batchSize := importBatchSize
+	if max := mysqlMaxPlaceholders / len(table.columns); batchSize > max {
+		batchSize = max
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	tx1, err := db.withState.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	tx2, err := db.noState.BeginTxx(ctx, nil)
+	if err != nil {
+		tx1.Rollback()
+		return nil, err
+	}
+	im := &importer{
+		table:     table,
+		mode:      mode,
+		batchSize: batchSize,
+		tx1:       tx1,
+		tx2:       tx2,
+		batch:     make([]importRow, 0, batchSize),
+		result: importResult{
+			Summary: ImportSummary{Mode: mode},
+		},
+	}
+	if mode == importModeReplace {
+		if err := im.createSeenTable(ctx); err != nil {
+			im.rollback()
+			return nil, err
+		}
+	}
+	return im, nil
+}
+
+func (im *importer) add(ctx context.Context, row importRow) error {
+	im.batch = append(im.batch, row)
+	if len(im.batch) >= im.batchSize {
+		return im.flush(ctx)
+	}
+	return nil
+}
+
+// flush 溜まった行を1つの文で書き込む
+func (im *importer) flush(ctx context.Context) error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	n := int64(len(im.batch))
+	// 同じidが2回あれば2回目は1回目の行を書き換えるので、重複を除いたidで数える
+	ids := make([]int64, 0, len(im.batch))
+	seen := make(map[int64]bool, len(im.batch))
+	for _, row := range im.batch {
+		if !seen[row.ID] {
+			seen[row.ID] = true
+			ids = append(ids, row.ID)
+		}
+	}
+
+	var existing int64
+	if im.mode == importModeUpsert || im.mode == importModeReplace {
+		// 更新と追加を数え分けるため、先に既存の行数を数えておく。書き込むまで他のトランザクションに変えられないようにロックする
+		query, params, err := sqlx.In(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id IN (?) FOR UPDATE", im.table.name), ids)
+		if err != nil {
+			return err
+		}
+		if err := im.tx1.GetContext(ctx, &existing, query, params...); err != nil {
+			return err
+		}
+	}
+
+	var query string
+	var params []interface{}
+	if im.mode == importModeDelete {
+		var err error
+		query, params, err = sqlx.In(fmt.Sprintf("DELETE FROM %s WHERE id IN (?)", im.table.name), ids)
+		if err != nil {
+			return err
+		}
+	} else {
+		query = im.table.insertQuery(im.mode, len(im.batch))
+		params = make([]interface{}, 0, len(im.batch)*len(im.table.columns))
+		for _, row := range im.batch {
+			params = append(params, row.Values...)
+		}
+	}
+	affected, err := im.execBoth(ctx, query, params)
+	if err != nil {
+		if ce, ok := im.duplicateRow(err); ok {
+			return ce
+		}
+		return err
+	}
+
+	summary := &im.result.Summary
+	switch im.mode {
+	case importModeDelete:
+		summary.Deleted += affected
+	case importModeInsert:
+		summary.Inserted += affected
+	default:
+		// ON DUPLICATE KEY UPDATEは追加で1、変更で2、変更なしで0行と数える。
+		// 追加は無かったidの数だけで、残りの行は既にある行か同じ文で追加した行の変更か変更なし
+		inserted := int64(len(ids)) - existing
+		updated := (affected - inserted) / 2
+		summary.Inserted += inserted
+		summary.Updated += updated
+		summary.Unchanged += n - inserted - updated
+	}
+	summary.Metrics.Rows += n
+	summary.Metrics.Batches++
+
+	if im.mode == importModeReplace {
+		if err := im.markSeen(ctx, ids); err != nil {
+			return err
+		}
+	}
+	im.batch = im.batch[:0]
+	if im.progress != nil {
+		im.progress(summary.Metrics.Rows)
+	}
+	return nil
+}
+
+// duplicateRow insertで主キーが重複した行を報告にする。MySQLは最初の重複しか返さないので、
+// バッチの中でそのidを持つ最後の行を重複とする。前の行は既にある行か同じバッチで先に追加した行になる
+func (im *importer) duplicateRow(err error) (*importRowConflict, bool) {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) || me.Number != mysqlErrDuplicateEntry {
+		return nil, false
+	}
+	m := duplicateEntryPattern.FindStringSubmatch(me.Message)
+	if m == nil {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	for i := len(im.batch) - 1; i >= 0; i-- {
+		if row := im.batch[i]; row.ID == id {
+			return &importRowConflict{ImportRowError{Line: row.Line, Column: "id", Reason: fmt.Sprintf("duplicate id %v", id)}}, true
+		}
+	}
+	return nil, false
+}
+
+// execBoth 2つのDBで同じ文を並行して実行し、withState側の影響行数を返す
+func (im *importer) execBoth(ctx context.Context, query string, params []interface{}) (int64, error) {
+	var wg sync.WaitGroup
+	var err2 error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err2 = im.tx2.ExecContext(ctx, query, params...)
+	}()
+	res, err1 := im.tx1.ExecContext(ctx, query, params...)
+	wg.Wait()
+	if err1 != nil {
+		return 0, err1
+	}
+	if err2 != nil {
+		return 0, err2
+	}
+	return res.RowsAffected()
+}
+
+// commit 残りの行を書き込み、replaceの場合はCSVに無い行を削除してからコミットする
+func (im *importer) commit(ctx context.Context) error {
+	if err := im.flush(ctx); err != nil {
+		return err
+	}
+	if im.mode == importModeReplace {
+		deleted, err := im.deleteMissingRows(ctx)
+		if err != nil {
+			return err
+		}
+		im.result.Summary.Deleted = deleted
+	}
+	if err := im.tx1.Commit(); err != nil {
+		return err
+	}
+	return im.tx2.Commit()
+}
+
+func (im *importer) rollback() {
+	im.tx1.Rollback()
+	im.tx2.Rollback()
+}
+
+// seenTable replaceで書き込んだidを覚える一時テーブル。一時テーブルは接続ごとなので、トランザクションの接続で作る
+func (im *importer) seenTable() string {
+	return "import_seen_" + im.table.name
+}
+
+// createSeenTable 前のインポートがロールバックして同じ接続に残っていれば作り直す。
+// 一時テーブルのCREATEとDROPは暗黙のコミットを起こさない
+func (im *importer) createSeenTable(ctx context.Context) error {
+	if _, err := im.execBoth(ctx, fmt.Sprintf("DROP TEMPORARY TABLE IF EXISTS %s", im.seenTable()), nil); err != nil {
+		return err
+	}
+	_, err := im.execBoth(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (id INTEGER NOT NULL PRIMARY KEY)", im.seenTable()), nil)
+	return err
+}
+
+// markSeen バッチのidを一時テーブルに書く。バッチの行数はプレースホルダの上限に収まっている
+func (im *importer) markSeen(ctx context.Context, ids []int64) error {
+	params := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		params = append(params, id)
+	}
+	query := fmt.Sprintf("INSERT IGNORE INTO %s(id) VALUES %s", im.seenTable(), strings.TrimSuffix(strings.Repeat("(?),", len(ids)), ","))
+	_, err := im.execBoth(ctx, query, params)
+	return err
+}
+
+// deleteMissingRows 一時テーブルに無い行を両方のDBから削除する
+func (im *importer) deleteMissingRows(ctx context.Context) (int64, error) {
+	query := fmt.Sprintf("DELETE t FROM %s t LEFT JOIN %s s ON s.id = t.id WHERE s.id IS NULL", im.table.name, im.seenTable())
+	deleted, err := im.execBoth(ctx, query, nil)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := im.execBoth(ctx, fmt.Sprintf("DROP TEMPORARY TABLE %s", im.seenTable()), nil); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testChairCSV testTablesの椅子1と同じ値の行
+const testChairCSV = `1,ゲーミングチェア,よく回る,/images/chair/1.png,5000,100,60,60,黒,"肘かけ,キャスター",ゲーミングチェア,1000,3` + "\n"
+
+const newChairCSV = "2,椅子,説明,/images/chair/2.png,5000,100,50,60,黒,肘かけ,座椅子,10,3\n"
+
+var csvHeader = http.Header{echo.HeaderContentType: {"text/csv"}}
+
+func TestImportInsertDuplicateID(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want ImportRowError
+	}{
+		{"existing", newChairCSV + testChairCSV, ImportRowError{Line: 2, Column: "id", Reason: "duplicate id 1"}},
+		{"repeated", newChairCSV + newChairCSV, ImportRowError{Line: 2, Column: "id", Reason: "duplicate id 2"}},
+	}
+	e := newEcho(nil)
+	for _, tc := range cases {
+		rec := serveRequest(e, http.MethodPost, "/api/chair", tc.body, csvHeader)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%v: status %v, want 400: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var res struct {
+			Details ImportReport `json:"details"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if r := res.Details; r.Rows != 2 || r.Valid != 1 || len(r.Errors) != 1 || r.Errors[0] != tc.want {
+			t.Errorf("%v: report %+v, want 1 valid row and %+v", tc.name, r, tc.want)
+		}
+	}
+}
+
+func TestImportUpsertCounts(t *testing.T) {
+	// 椅子1は変更なし、椅子2は追加した後に同じ文で値を変える
+	body := testChairCSV + newChairCSV + "2,椅子,説明,/images/chair/2.png,6000,100,50,60,黒,肘かけ,座椅子,10,3\n"
+	e := newEcho(nil)
+	rec := serveRequest(e, http.MethodPost, "/api/chair?mode=upsert", body, csvHeader)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %v, want 201: %s", rec.Code, rec.Body.String())
+	}
+	var summary ImportSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Inserted != 1 || summary.Updated != 1 || summary.Unchanged != 1 {
+		t.Errorf("inserted %v, updated %v, unchanged %v, want 1 of each", summary.Inserted, summary.Updated, summary.Unchanged)
+	}
+}
+
+func TestImportReportTruncatesErrors(t *testing.T) {
+	saved := importMaxErrors
+	importMaxErrors = 2
+	defer func() { importMaxErrors = saved }()
+
+	// 3行とも価格と在庫が数でない
+	row := "2,椅子,説明,/images/chair/2.png,x,100,50,60,黒,肘かけ,座椅子,10,y\n"
+	e := newEcho(nil)
+	rec := serveRequest(e, http.MethodPost, "/api/chair?dryRun=true", row+row+row, csvHeader)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %v, want 400: %s", rec.Code, rec.Body.String())
+	}
+	var res struct {
+		Details ImportReport `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Details; r.Rows != 3 || r.ErrorCount != 6 || !r.Truncated || len(r.Errors) != 2 {
+		t.Errorf("report %+v, want 6 errors with the first 2 listed", r)
+	}
+}
+
+func TestImportMultipartStreamsFile(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"fields before the file", chairsMultipart("dryRun", "true"), http.StatusOK},
+		{"invalid mode", chairsMultipart("mode", "merge"), http.StatusBadRequest},
+		// ファイルより後のフィールドは読まないので、dryRunにならずに取り込む
+		{"fields after the file", "--b\r\nContent-Disposition: form-data; name=\"chairs\"; filename=\"chairs.csv\"\r\nContent-Type: text/csv\r\n\r\n" + newChairCSV +
+			"\r\n--b\r\nContent-Disposition: form-data; name=\"dryRun\"\r\n\r\ntrue\r\n--b--\r\n", http.StatusCreated},
+		{"missing file", "--b\r\nContent-Disposition: form-data; name=\"dryRun\"\r\n\r\ntrue\r\n--b--\r\n", http.StatusBadRequest},
+	}
+	e := newEcho(nil)
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/chair", strings.NewReader(tc.body))
+		req.Header.Set(echo.HeaderContentType, "multipart/form-data; boundary=b")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%v: status %v, want %v: %s", tc.name, rec.Code, tc.status, rec.Body.String())
+		}
+		// MultipartReaderで読めばFormは作られず、MultipartFormも中身の無い印になる
+		if req.Form != nil || req.MultipartForm != nil && len(req.MultipartForm.File) > 0 {
+			t.Errorf("%v: the upload was parsed into a multipart form", tc.name)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const (
+	importJobQueued    = "queued"
+	importJobRunning   = "running"
+	importJobSucceeded = "succeeded"
+	importJobFailed    = "failed"
+)
+
+// importSpoolDir 非同期インポートのアップロードファイルを置くディレクトリ
+var importSpoolDir = filepath.Join(os.TempDir(), "isuumo-imports")
+
+// importNode アップロードファイルを持っているノード。起動時は前回の起動で終わらなかった自分のジョブを失敗にする
+var importNode, _ = os.Hostname()
+
+// importJobScanInterval キューに入れられなかったジョブを探し直す間隔
+var importJobScanInterval = 5 * time.Second
+
+// importJobQueue ワーカーに渡すジョブ。溢れたジョブはqueuedのまま残り、scanImportJobsが後で入れる
+var importJobQueue = make(chan queuedImportJob, 1024)
+
+// importJobGeneration /initializeでimport_jobを作り直すたびに増やす。前の世代のidは別のジョブを指しうるので、
+// ジョブの行に世代を書き、実行中の更新は同じ世代の行にだけ行う。プロセスごとに違う値から始め、他のプロセスのジョブとも区別する
+var importJobGeneration = time.Now().UnixNano()
+
+type queuedImportJob struct {
+	id         int64
+	generation int64
+}
+
+//ImportJob 非同期インポートの状態
+type ImportJob struct {
+	ID            int64  `db:"id"`
+	Generation    int64  `db:"generation"`
+	Target        string `db:"target"`
+	Mode          string `db:"mode"`
+	Format        string `db:"format"`
+	Options       string `db:"options"`
+	FilePath      string `db:"file_path"`
+	Status        string `db:"status"`
+	RowsProcessed int64  `db:"rows_processed"`
+	Summary       string `db:"summary"`
+	Errors        string `db:"errors"`
+	ErrorCount    int64  `db:"error_count"`
+	DurationMs    int64  `db:"duration_ms"`
+}
+
+type ImportJobResponse struct {
+	ID            int64           `json:"id"`
+	Target        string          `json:"target"`
+	Mode          string          `json:"mode"`
+	Format        string          `json:"format"`
+	Status        string          `json:"status"`
+	RowsProcessed int64           `json:"rowsProcessed"`
+	DurationMs    int64           `json:"durationMs"`
+	Summary       json.RawMessage `json:"summary,omitempty"`
+	// Errors 先頭のimportMaxErrors件のエラー。ErrorCountが全ての数
+	Errors     json.RawMessage `json:"errors"`
+	ErrorCount int64           `json:"errorCount"`
+}
+
+func (j ImportJob) response() ImportJobResponse {
+	res := ImportJobResponse{
+		ID:            j.ID,
+		Target:        j.Target,
+		Mode:          j.Mode,
+		Format:        j.Format,
+		Status:        j.Status,
+		RowsProcessed: j.RowsProcessed,
+		DurationMs:    j.DurationMs,
+		Errors:        json.RawMessage("[]"),
+		ErrorCount:    j.ErrorCount,
+	}
+	if j.Summary != "" {
+		res.Summary = json.RawMessage(j.Summary)
+	}
+	if j.Errors != "" {
+		res.Errors = json.RawMessage(j.Errors)
+	}
+	return res
+}
+
+// enqueueImportJob アップロードされたファイルを保存し、202とジョブのidを返す
+func enqueueImportJob(c echo.Context, table importTable, mode, format string, opts ImportSourceOptions, body io.Reader) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return internalError("failed to encode import options", err)
+	}
+
+	path, err := spoolImportFile(table.name, format, body)
+	if err != nil {
+		return internalError("failed to spool import file", err)
+	}
+
+	generation := atomic.LoadInt64(&importJobGeneration)
+	res, err := db.withState.ExecContext(ctx, "INSERT INTO import_job(node, generation, target, mode, format, options, file_path, status, summary, errors, created_at) VALUES(?,?,?,?,?,?,?,?,'','',NOW(6))", importNode, generation, table.name, mode, format, string(optsJSON), path, importJobQueued)
+	if err != nil {
+		os.Remove(path)
+		return internalError("failed to insert import job", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return internalError("failed to insert import job", err)
+	}
+	if !queueImportJob(queuedImportJob{id: id, generation: generation}) {
+		log.Infof("import job queue is full, job %v waits for the next scan", id)
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/api/imports/%d", id))
+	return c.JSON(http.StatusAccepted, ImportJobResponse{
+		ID:     id,
+		Target: table.name,
+		Mode:   mode,
+		Format: format,
+		Status: importJobQueued,
+		Errors: json.RawMessage("[]"),
+	})
+}
+
+func spoolImportFile(target, format string, src io.Reader) (string, error) {
+	if err := os.MkdirAll(importSpoolDir, 0755); err != nil {
+		return "", err
+	}
+	dst, err := ioutil.TempFile(importSpoolDir, target+"-*."+format)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+func getImportJob(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	id := c.Get(requestKey).(*IDRequest).ID
+
+	var job ImportJob
+	query := `SELECT id, target, mode, format, options, file_path, status, rows_processed, summary, errors, error_count,
+		CASE WHEN status = 'running' THEN TIMESTAMPDIFF(MICROSECOND, started_at, NOW(6)) DIV 1000 ELSE duration_ms END AS duration_ms
+		FROM import_job WHERE id = ?`
+	err := db.withState.GetContext(ctx, &job, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("import job %v not found", id)
+		}
+		return internalError("Database Execution error", err)
+	}
+
+	return c.JSON(http.StatusOK, job.response())
+}
+
+// queueImportJob 待たずにキューに入れる。溢れたらfalseを返す
+func queueImportJob(job queuedImportJob) bool {
+	select {
+	case importJobQueue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// resetImportJobs import_jobを作り直した後に呼び、キューに残っている前の世代のidを捨てさせる
+func resetImportJobs() {
+	atomic.AddInt64(&importJobGeneration, 1)
+}
+
+// startImportWorkers ワーカーを起動し、前回の起動で終わらなかったジョブを再開する
+func startImportWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range importJobQueue {
+				if job.generation != atomic.LoadInt64(&importJobGeneration) {
+					continue
+				}
+				runImportJob(job)
+			}
+		}()
+	}
+
+	failOrphanedImportJobs()
+	go scanImportJobs(importJobScanInterval)
+}
+
+// failOrphanedImportJobs 前回の起動で終わらなかったジョブを失敗にする。
+// 一時ファイルは再起動で消えていることがあり、残っていても途中まで書いたかどうかは分からないので再開しない
+func failOrphanedImportJobs() {
+	var paths []string
+	err := db.withState.Select(&paths, "SELECT file_path FROM import_job WHERE node = ? AND status IN (?, ?)", importNode, importJobQueued, importJobRunning)
+	if err != nil {
+		log.Errorf("failed to load orphaned import jobs : %v", err)
+		return
+	}
+	errsJSON, _ := json.Marshal([]ImportRowError{{Reason: "the server restarted before the import job finished"}})
+	_, err = db.withState.Exec("UPDATE import_job SET status = ?, errors = ?, error_count = 1, finished_at = NOW(6) WHERE node = ? AND status IN (?, ?)",
+		importJobFailed, string(errsJSON), importNode, importJobQueued, importJobRunning)
+	if err != nil {
+		log.Errorf("failed to fail orphaned import jobs : %v", err)
+		return
+	}
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// scanImportJobs このノードのqueuedのジョブを定期的にキューに入れる。
+// 既にキューにあるジョブを入れ直しても、runImportJobが1つのワーカーにしか実行させない
+func scanImportJobs(interval time.Duration) {
+	for {
+		var ids []int64
+		generation := atomic.LoadInt64(&importJobGeneration)
+		err := db.withState.Select(&ids, "SELECT id FROM import_job WHERE node = ? AND generation = ? AND status = ? ORDER BY id", importNode, generation, importJobQueued)
+		if err != nil {
+			log.Errorf("failed to load pending import jobs : %v", err)
+		}
+		for _, id := range ids {
+			if !queueImportJob(queuedImportJob{id: id, generation: generation}) {
+				break
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func runImportJob(queued queuedImportJob) {
+	ctx := context.Background()
+	id := queued.id
+
+	// queuedからrunningにできたワーカーだけが実行する
+	res, err := db.withState.Exec("UPDATE import_job SET status = ?, rows_processed = 0, started_at = NOW(6) WHERE id = ? AND generation = ? AND status = ?", importJobRunning, id, queued.generation, importJobQueued)
+	if err != nil {
+		log.Errorf("failed to start import job %v : %v", id, err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return
+	}
+
+	var job ImportJob
+	err = db.withState.Get(&job, "SELECT id, target, mode, format, options, file_path, status, rows_processed, summary, errors, error_count, duration_ms FROM import_job WHERE id = ?", id)
+	if err != nil {
+		log.Errorf("failed to load import job %v : %v", id, err)
+		finishImportJob(queued, importJobFailed, nil, []ImportRowError{{Reason: err.Error()}}, 1)
+		return
+	}
+
+	table, ok := importTables[job.Target]
+	if !ok {
+		finishImportJob(queued, importJobFailed, nil, []ImportRowError{{Reason: fmt.Sprintf("unknown import target %q", job.Target)}}, 1)
+		return
+	}
+	var opts ImportSourceOptions
+	if err := json.Unmarshal([]byte(job.Options), &opts); err != nil {
+		finishImportJob(queued, importJobFailed, nil, []ImportRowError{{Reason: fmt.Sprintf("invalid import options: %v", err)}}, 1)
+		return
+	}
+	f, err := os.Open(job.FilePath)
+	if err != nil {
+		finishImportJob(queued, importJobFailed, nil, []ImportRowError{{Reason: err.Error()}}, 1)
+		return
+	}
+	defer os.Remove(job.FilePath)
+	defer f.Close()
+
+	progress := func(rows int64) {
+		if _, err := db.withState.Exec("UPDATE import_job SET rows_processed = ? WHERE id = ? AND generation = ?", rows, id, queued.generation); err != nil {
+			log.Errorf("failed to update import job %v progress : %v", id, err)
+		}
+	}
+	result, report, err := importRows(ctx, table, job.Mode, false, newImportSource(job.Format, table, job.Mode, f, opts), progress)
+	switch {
+	case err != nil:
+		finishImportJob(queued, importJobFailed, nil, []ImportRowError{{Reason: err.Error()}}, 1)
+	case report.ErrorCount > 0:
+		finishImportJob(queued, importJobFailed, nil, report.Errors, report.ErrorCount)
+	default:
+		table.afterCommit(ctx, job.Mode, result)
+		finishImportJob(queued, importJobSucceeded, &result.Summary, nil, 0)
+	}
+}
+
+// finishImportJob errsはImportReportと同じく先頭の一部で、errorCountが全てのエラーの数。
+// 実行中に/initializeでテーブルが作り直されていたら、同じidの別のジョブは書き換えない
+func finishImportJob(queued queuedImportJob, status string, summary *ImportSummary, errs []ImportRowError, errorCount int) {
+	var summaryJSON []byte
+	if summary != nil {
+		summaryJSON, _ = json.Marshal(summary)
+	}
+	if errs == nil {
+		errs = []ImportRowError{}
+	}
+	errsJSON, _ := json.Marshal(errs)
+
+	var rows int64
+	if summary != nil {
+		rows = summary.Metrics.Rows
+	}
+	_, err := db.withState.Exec(`UPDATE import_job SET status = ?, summary = ?, errors = ?, error_count = ?,
+		rows_processed = IF(? > 0, ?, rows_processed), finished_at = NOW(6),
+		duration_ms = TIMESTAMPDIFF(MICROSECOND, started_at, NOW(6)) DIV 1000 WHERE id = ? AND generation = ?`,
+		status, string(summaryJSON), string(errsJSON), errorCount, rows, rows, queued.id, queued.generation)
+	if err != nil {
+		log.Errorf("failed to finish import job %v : %v", queued.id, err)
+	}
+	log.Infof("import job %v %v", queued.id, status)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRunImportJobChecksGeneration(t *testing.T) {
+	withTables(func(tables map[string]*fakeTable) {}, func(fake *fakeDB) {
+		// testTablesのジョブ1のファイルは無いので、開けずに失敗する
+		runImportJob(queuedImportJob{id: 1, generation: 7})
+
+		started := fake.executed("UPDATE import_job SET status = ?, rows_processed = 0")
+		if len(started) != 1 || started[0][1] != int64(1) || started[0][2] != int64(7) {
+			t.Fatalf("started %v, want job 1 of generation 7", started)
+		}
+		finished := fake.executed("UPDATE import_job SET status = ?, summary = ?")
+		if len(finished) != 1 {
+			t.Fatalf("finished %v, want once", finished)
+		}
+		args := finished[0]
+		if args[0] != importJobFailed || args[len(args)-2] != int64(1) || args[len(args)-1] != int64(7) {
+			t.Errorf("finished with %v, want job 1 of generation 7 failed", args)
+		}
+	})
+}
+
+func TestFailOrphanedImportJobs(t *testing.T) {
+	f, err := ioutil.TempFile("", "chair-*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	withTables(func(tables map[string]*fakeTable) {
+		tables["import_job"].rows[0][6] = f.Name()
+	}, func(fake *fakeDB) {
+		failOrphanedImportJobs()
+
+		failed := fake.executed("UPDATE import_job SET status = ?, errors = ?")
+		if len(failed) != 1 || failed[0][0] != importJobFailed || failed[0][2] != importNode {
+			t.Errorf("failed %v, want the jobs of this node failed", failed)
+		}
+		if requeued := fake.executed("UPDATE import_job SET status = ? WHERE"); len(requeued) > 0 {
+			t.Errorf("requeued %v", requeued)
+		}
+		if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+			t.Errorf("spooled file of an orphaned job is left: %v", err)
+		}
+	})
+}
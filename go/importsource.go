@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	importFormatCSV    = "csv"
+	importFormatJSON   = "json"
+	importFormatNDJSON = "ndjson"
+)
+
+// ndjsonMaxLineSize NDJSONの1行の最大サイズ
+const ndjsonMaxLineSize = 16 * 1024 * 1024
+
+// importSource インポートする行をテーブルの列順に1行ずつ読む
+type importSource interface {
+	// Next 次の行と行番号を返す。行単位の不正はerrsで返して読み続け、読み終わるとio.EOFを返す
+	Next() (line int, record []string, errs []ImportRowError, err error)
+}
+
+// importSyntaxError これ以降の行を読めなくなった構文エラー
+type importSyntaxError struct {
+	ImportRowError
+}
+
+func (e *importSyntaxError) Error() string {
+	return e.Reason
+}
+
+//ImportSourceOptions CSVのヘッダ行の扱い
+type ImportSourceOptions struct {
+	// Header 1行目を列名として読み、列名で値を対応づける
+	Header bool `json:"header"`
+	// ColumnMap CSVの列名からテーブルの列名への読み替え
+	ColumnMap map[string]string `json:"columnMap,omitempty"`
+}
+
+// parseColumnMap "partner_id:id,title:name" の形式の読み替えを解釈する
+func parseColumnMap(table importTable, s string) (map[string]string, error) {
+	m := map[string]string{}
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || normalizeColumnName(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid column mapping %q", pair)
+		}
+		if table.resolveColumn(kv[1], nil) == "" {
+			return nil, fmt.Errorf("unknown column %q in column mapping", kv[1])
+		}
+		m[normalizeColumnName(kv[0])] = kv[1]
+	}
+	return m, nil
+}
+
+func normalizeColumnName(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+// resolveColumn ヘッダの列名をテーブルの列名に読み替える。該当する列が無ければ空文字を返す
+func (t importTable) resolveColumn(name string, columnMap map[string]string) string {
+	n := normalizeColumnName(name)
+	if mapped, ok := columnMap[n]; ok {
+		n = normalizeColumnName(mapped)
+	}
+	for i, col := range t.columns {
+		if n == col || n == strings.ToLower(t.fields[i]) {
+			return col
+		}
+	}
+	if col, ok := t.aliases[n]; ok {
+		return col
+	}
+	return ""
+}
+
+func newImportSource(format string, table importTable, mode string, r io.Reader, opts ImportSourceOptions) importSource {
+	switch format {
+	case importFormatJSON:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return &jsonSource{dec: dec, table: table, mode: mode}
+	case importFormatNDJSON:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), ndjsonMaxLineSize)
+		return &ndjsonSource{scanner: s, table: table, mode: mode}
+	}
+	lines := &lineReader{r: bufio.NewReader(r)}
+	cr := csv.NewReader(lines)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return &csvSource{reader: cr, lines: lines, table: table, mode: mode, opts: opts}
+}
+
+// lineReader csv.Readerに1回のReadで1行までしか渡さず、渡し終えた行を数える。
+// csv.Readerは内部のbufioで先読みするが、Readが行の終わりで止まるので先読みも読んでいる行で止まる
+type lineReader struct {
+	r       *bufio.Reader
+	pending []byte
+	// lines 渡した改行の数。openは最後の改行の後に続きを渡したか
+	lines int
+	open  bool
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		line, err := l.r.ReadSlice('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		// ReadSliceの結果は次のReadSliceまでしか使えないが、渡し終えるまで次は読まない
+		l.pending = line
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	if newlines := bytes.Count(p[:n], []byte{'\n'}); newlines > 0 {
+		l.lines += newlines
+		l.open = false
+	}
+	if p[n-1] != '\n' {
+		l.open = true
+	}
+	return n, nil
+}
+
+// lastLine 渡し終えた最後の行の行番号
+func (l *lineReader) lastLine() int {
+	if l.open {
+		return l.lines + 1
+	}
+	return l.lines
+}
+
+// importFormatOf Content-Typeやファイル名の拡張子からインポートの形式を決める
+func importFormatOf(contentType, filename string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		return importFormatJSON
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return importFormatNDJSON
+	case "text/csv":
+		return importFormatCSV
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return importFormatJSON
+	case ".ndjson", ".jsonl":
+		return importFormatNDJSON
+	}
+	return importFormatCSV
+}
+
+// csvSource 行番号はファイルの行で、複数行にまたがるレコードは始まりの行、空行も数える
+type csvSource struct {
+	reader *csv.Reader
+	lines  *lineReader
+	table  importTable
+	mode   string
+	opts   ImportSourceOptions
+	line   int
+
+	// header ヘッダ行の列数。positionsはテーブルの各列がヘッダの何列目にあるか
+	header    int
+	positions []int
+}
+
+func (s *csvSource) Next() (int, []string, []ImportRowError, error) {
+	record, err := s.read()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if s.opts.Header && s.positions == nil {
+		if err := s.readHeader(record); err != nil {
+			return 0, nil, nil, err
+		}
+		if record, err = s.read(); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if s.positions == nil {
+		return s.line, record, nil, nil
+	}
+
+	if len(record) != s.header {
+		return s.line, nil, []ImportRowError{{Line: s.line, Reason: fmt.Sprintf("expected %d columns, got %d", s.header, len(record))}}, nil
+	}
+	mapped := make([]string, len(s.positions))
+	for i, pos := range s.positions {
+		mapped[i] = record[pos]
+	}
+	return s.line, mapped, nil, nil
+}
+
+func (s *csvSource) read() ([]string, error) {
+	record, err := s.reader.Read()
+	if err != nil {
+		if pe, ok := err.(*csv.ParseError); ok {
+			return nil, &importSyntaxError{ImportRowError{Line: pe.Line, Reason: pe.Err.Error()}}
+		}
+		return nil, err
+	}
+	// csv.Readerは引用符の中の改行を\nにして残すので、その数だけ終わりの行から戻れば始まりの行になる
+	s.line = s.lines.lastLine()
+	for _, field := range record {
+		s.line -= strings.Count(field, "\n")
+	}
+	return record, nil
+}
+
+// readHeader ヘッダの列名からテーブルの列の位置を決める。知らない列は無視し、必須の列が無ければ失敗する
+func (s *csvSource) readHeader(header []string) error {
+	required := s.table.columns
+	if s.mode == importModeDelete {
+		required = required[:1]
+	}
+	found := map[string]int{}
+	for i, name := range header {
+		col := s.table.resolveColumn(name, s.opts.ColumnMap)
+		if col == "" {
+			continue
+		}
+		if _, ok := found[col]; ok {
+			return &importSyntaxError{ImportRowError{Line: s.line, Column: col, Reason: fmt.Sprintf("duplicate column %q", name)}}
+		}
+		found[col] = i
+	}
+
+	positions := make([]int, 0, len(required))
+	missing := make([]string, 0)
+	for _, col := range required {
+		pos, ok := found[col]
+		if !ok {
+			missing = append(missing, col)
+			continue
+		}
+		positions = append(positions, pos)
+	}
+	if len(missing) > 0 {
+		return &importSyntaxError{ImportRowError{Line: s.line, Reason: "missing required columns: " + strings.Join(missing, ", ")}}
+	}
+	s.header = len(header)
+	s.positions = positions
+	return nil
+}
+
+// jsonSource オブジェクトの配列を1要素ずつ読む。行番号は配列の何番目の要素か
+type jsonSource struct {
+	dec     *json.Decoder
+	table   importTable
+	mode    string
+	line    int
+	started bool
+}
+
+func (s *jsonSource) Next() (int, []string, []ImportRowError, error) {
+	if !s.started {
+		tok, err := s.dec.Token()
+		if err == io.EOF {
+			return 0, nil, nil, io.EOF
+		}
+		if err != nil || tok != json.Delim('[') {
+			return 0, nil, nil, &importSyntaxError{ImportRowError{Line: 1, Reason: "expected a JSON array of objects"}}
+		}
+		s.started = true
+	}
+	if !s.dec.More() {
+		if _, err := s.dec.Token(); err != nil {
+			return 0, nil, nil, &importSyntaxError{ImportRowError{Line: s.line + 1, Reason: err.Error()}}
+		}
+		return 0, nil, nil, io.EOF
+	}
+
+	s.line++
+	var obj map[string]json.RawMessage
+	if err := s.dec.Decode(&obj); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return s.line, nil, []ImportRowError{{Line: s.line, Reason: "expected a JSON object"}}, nil
+		}
+		return 0, nil, nil, &importSyntaxError{ImportRowError{Line: s.line, Reason: err.Error()}}
+	}
+	record, errs := objectToRecord(s.line, obj, s.table, s.mode)
+	return s.line, record, errs, nil
+}
+
+// ndjsonSource 1行に1つのオブジェクトを読む。壊れた行があっても次の行から読み続ける
+type ndjsonSource struct {
+	scanner *bufio.Scanner
+	table   importTable
+	mode    string
+	line    int
+}
+
+func (s *ndjsonSource) Next() (int, []string, []ImportRowError, error) {
+	for s.scanner.Scan() {
+		s.line++
+		b := bytes.TrimSpace(s.scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var obj map[string]json.RawMessage
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return s.line, nil, []ImportRowError{{Line: s.line, Reason: err.Error()}}, nil
+		}
+		record, errs := objectToRecord(s.line, obj, s.table, s.mode)
+		return s.line, record, errs, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return 0, nil, nil, &importSyntaxError{ImportRowError{Line: s.line + 1, Reason: err.Error()}}
+	}
+	return 0, nil, nil, io.EOF
+}
+
+// objectToRecord JSONのオブジェクトをテーブルの列順に並べる。削除はidだけを読む
+func objectToRecord(line int, obj map[string]json.RawMessage, table importTable, mode string) ([]string, []ImportRowError) {
+	fields := table.fields
+	if mode == importModeDelete {
+		fields = fields[:1]
+	}
+	record := make([]string, len(fields))
+	var errs []ImportRowError
+	for i, field := range fields {
+		raw, ok := obj[field]
+		if !ok {
+			errs = append(errs, ImportRowError{Line: line, Column: field, Reason: "missing field"})
+			continue
+		}
+		raw = bytes.TrimSpace(raw)
+		switch {
+		case len(raw) > 0 && raw[0] == '"':
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				errs = append(errs, ImportRowError{Line: line, Column: field, Reason: err.Error()})
+				continue
+			}
+			record[i] = s
+		case len(raw) > 0 && (raw[0] == '-' || ('0' <= raw[0] && raw[0] <= '9')):
+			record[i] = string(raw)
+		default:
+			errs = append(errs, ImportRowError{Line: line, Column: field, Reason: "must be a string or a number"})
+		}
+	}
+	return record, errs
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// readSource importSourceを最後まで読み、行番号とレコードを返す
+func readSource(t *testing.T, src importSource) ([]int, [][]string) {
+	t.Helper()
+	var lines []int
+	var records [][]string
+	for {
+		line, record, errs, err := src.Next()
+		if err == io.EOF {
+			return lines, records
+		}
+		if err != nil {
+			t.Fatalf("line %v: %v", line, err)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("line %v: %+v", line, errs)
+		}
+		lines = append(lines, line)
+		records = append(records, append([]string(nil), record...))
+	}
+}
+
+func TestCSVSourceLines(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want []int
+	}{
+		{"plain", "1,a\n2,b\n3,c", []int{1, 2, 3}},
+		{"multi-line field", "1,a\n2,\"b\nb\nb\"\n\n5,c\n", []int{1, 2, 6}},
+		{"crlf", "1,\"a\r\na\"\r\n2,b\r\n", []int{1, 3}},
+	}
+	for _, tc := range cases {
+		src := newImportSource(importFormatCSV, chairImportTable, importModeInsert, strings.NewReader(tc.body), ImportSourceOptions{})
+		lines, _ := readSource(t, src)
+		if len(lines) != len(tc.want) {
+			t.Errorf("%v: lines %v, want %v", tc.name, lines, tc.want)
+			continue
+		}
+		for i := range lines {
+			if lines[i] != tc.want[i] {
+				t.Errorf("%v: lines %v, want %v", tc.name, lines, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCSVSourceSyntaxErrorLine(t *testing.T) {
+	body := "1,\"a\na\"\n2,b\"\n"
+	src := newImportSource(importFormatCSV, chairImportTable, importModeInsert, strings.NewReader(body), ImportSourceOptions{})
+	if line, _, _, err := src.Next(); err != nil || line != 1 {
+		t.Fatalf("first record at line %v: %v", line, err)
+	}
+	_, _, _, err := src.Next()
+	se, ok := err.(*importSyntaxError)
+	if !ok || se.Line != 3 {
+		t.Errorf("error %#v, want a syntax error at line 3", err)
+	}
+}
+
+const testEstateJSON = `{"id":1,"name":"家","description":"説明","thumbnail":"/images/estate/1.png","address":"東京都","latitude":35.5,"longitude":139.5,"rent":50000,"doorHeight":100,"doorWidth":80,"features":"","popularity":10}`
+
+func TestJSONSource(t *testing.T) {
+	body := "[\n" + testEstateJSON + ",\n" + `{"id":2,"name":true}` + ",\n" + `"not an object"` + "\n]"
+	src := newImportSource(importFormatJSON, estateImportTable, importModeInsert, strings.NewReader(body), ImportSourceOptions{})
+
+	line, record, errs, err := src.Next()
+	if err != nil || len(errs) > 0 || line != 1 {
+		t.Fatalf("first element at %v: %+v %v", line, errs, err)
+	}
+	want := []string{"1", "家", "説明", "/images/estate/1.png", "東京都", "35.5", "139.5", "50000", "100", "80", "", "10"}
+	if strings.Join(record, "|") != strings.Join(want, "|") {
+		t.Errorf("record %q, want %q", record, want)
+	}
+
+	line, _, errs, err = src.Next()
+	if err != nil || line != 2 {
+		t.Fatalf("second element at %v: %v", line, err)
+	}
+	// nameの型違いと残りの10列の欠落
+	if len(errs) != 11 || errs[0] != (ImportRowError{Line: 2, Column: "name", Reason: "must be a string or a number"}) {
+		t.Errorf("errors %+v, want name and 10 missing fields", errs)
+	}
+
+	line, _, errs, err = src.Next()
+	if err != nil || line != 3 || len(errs) != 1 || errs[0].Reason != "expected a JSON object" {
+		t.Errorf("third element at %v: %+v %v, want a non-object error", line, errs, err)
+	}
+	if _, _, _, err := src.Next(); err != io.EOF {
+		t.Errorf("got %v after the last element, want io.EOF", err)
+	}
+
+	src = newImportSource(importFormatJSON, estateImportTable, importModeInsert, strings.NewReader(testEstateJSON), ImportSourceOptions{})
+	if _, _, _, err := src.Next(); err == nil || err.(*importSyntaxError).Line != 1 {
+		t.Errorf("got %v for an object, want a syntax error at line 1", err)
+	}
+}
+
+func TestNDJSONSource(t *testing.T) {
+	body := testEstateJSON + "\n\n{broken\n" + `{"id":3}` + "\n"
+	src := newImportSource(importFormatNDJSON, estateImportTable, importModeDelete, strings.NewReader(body), ImportSourceOptions{})
+	var lines []int
+	var records [][]string
+	var failed []int
+	for {
+		line, record, errs, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) > 0 {
+			failed = append(failed, line)
+			continue
+		}
+		lines = append(lines, line)
+		records = append(records, record)
+	}
+	// 空行も数え、壊れた行の後も読み続ける。削除はidだけを読む
+	if len(lines) != 2 || lines[0] != 1 || lines[1] != 4 || records[0][0] != "1" || records[1][0] != "3" || len(records[1]) != 1 {
+		t.Errorf("read %v at lines %v, want ids 1 and 3 at lines 1 and 4", records, lines)
+	}
+	if len(failed) != 1 || failed[0] != 3 {
+		t.Errorf("failed at lines %v, want 3", failed)
+	}
+}
+
+func TestParseColumnMap(t *testing.T) {
+	m, err := parseColumnMap(chairImportTable, "Partner_ID:id, title:name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m["partner_id"] != "id" || m["title"] != "name" {
+		t.Errorf("mapping %v", m)
+	}
+	for _, s := range []string{"title", ":name", "title:unknown"} {
+		if _, err := parseColumnMap(chairImportTable, s); err == nil {
+			t.Errorf("%q: accepted", s)
+		}
+	}
+}
+
+func TestCSVSourceHeader(t *testing.T) {
+	cases := []struct {
+		name      string
+		body      string
+		columnMap string
+		want      string
+	}{
+		{"aliases", "ID,Name,Description,Thumbnail,Address,lat,lng,Rent,door_height,doorWidth,Features,Popularity,note\n" +
+			"1,家,説明,/images/estate/1.png,東京都,35.5,139.5,50000,100,80,,10,x\n", "", "1|家|35.5|139.5"},
+		{"lon", "id,name,description,thumbnail,address,latitude,lon,rent,door_height,door_width,features,popularity\n" +
+			"1,家,説明,/images/estate/1.png,東京都,35.5,139.5,50000,100,80,,10\n", "", "1|家|35.5|139.5"},
+		{"column map", "partner_id,title,description,thumbnail,address,y,x,rent,door_height,door_width,features,popularity\n" +
+			"1,家,説明,/images/estate/1.png,東京都,35.5,139.5,50000,100,80,,10\n", "partner_id:id,title:name,y:lat,x:longitude", "1|家|35.5|139.5"},
+	}
+	for _, tc := range cases {
+		columnMap, err := parseColumnMap(estateImportTable, tc.columnMap)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		src := newImportSource(importFormatCSV, estateImportTable, importModeInsert, strings.NewReader(tc.body), ImportSourceOptions{Header: true, ColumnMap: columnMap})
+		lines, records := readSource(t, src)
+		if len(records) != 1 || lines[0] != 2 {
+			t.Fatalf("%v: read %v at lines %v, want one record at line 2", tc.name, records, lines)
+		}
+		r := records[0]
+		if got := strings.Join([]string{r[0], r[1], r[5], r[6]}, "|"); got != tc.want {
+			t.Errorf("%v: id, name, latitude and longitude %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	src := newImportSource(importFormatCSV, estateImportTable, importModeInsert, strings.NewReader("id,lat,latitude\n"), ImportSourceOptions{Header: true})
+	if _, _, _, err := src.Next(); err == nil || err.(*importSyntaxError).Column != "latitude" {
+		t.Errorf("got %v for lat and latitude, want a duplicate column error", err)
+	}
+}
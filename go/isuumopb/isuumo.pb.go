@@ -0,0 +1,1420 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: isuumo.proto
+
+package isuumopb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Chair struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Description          string   `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Thumbnail            string   `protobuf:"bytes,4,opt,name=thumbnail,proto3" json:"thumbnail,omitempty"`
+	Price                int64    `protobuf:"varint,5,opt,name=price,proto3" json:"price,omitempty"`
+	Height               int64    `protobuf:"varint,6,opt,name=height,proto3" json:"height,omitempty"`
+	Width                int64    `protobuf:"varint,7,opt,name=width,proto3" json:"width,omitempty"`
+	Depth                int64    `protobuf:"varint,8,opt,name=depth,proto3" json:"depth,omitempty"`
+	Color                string   `protobuf:"bytes,9,opt,name=color,proto3" json:"color,omitempty"`
+	Features             string   `protobuf:"bytes,10,opt,name=features,proto3" json:"features,omitempty"`
+	Kind                 string   `protobuf:"bytes,11,opt,name=kind,proto3" json:"kind,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Chair) Reset()         { *m = Chair{} }
+func (m *Chair) String() string { return proto.CompactTextString(m) }
+func (*Chair) ProtoMessage()    {}
+func (*Chair) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{0}
+}
+
+func (m *Chair) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Chair.Unmarshal(m, b)
+}
+func (m *Chair) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Chair.Marshal(b, m, deterministic)
+}
+func (m *Chair) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Chair.Merge(m, src)
+}
+func (m *Chair) XXX_Size() int {
+	return xxx_messageInfo_Chair.Size(m)
+}
+func (m *Chair) XXX_DiscardUnknown() {
+	xxx_messageInfo_Chair.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Chair proto.InternalMessageInfo
+
+func (m *Chair) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Chair) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Chair) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+func (m *Chair) GetThumbnail() string {
+	if m != nil {
+		return m.Thumbnail
+	}
+	return ""
+}
+
+func (m *Chair) GetPrice() int64 {
+	if m != nil {
+		return m.Price
+	}
+	return 0
+}
+
+func (m *Chair) GetHeight() int64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *Chair) GetWidth() int64 {
+	if m != nil {
+		return m.Width
+	}
+	return 0
+}
+
+func (m *Chair) GetDepth() int64 {
+	if m != nil {
+		return m.Depth
+	}
+	return 0
+}
+
+func (m *Chair) GetColor() string {
+	if m != nil {
+		return m.Color
+	}
+	return ""
+}
+
+func (m *Chair) GetFeatures() string {
+	if m != nil {
+		return m.Features
+	}
+	return ""
+}
+
+func (m *Chair) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+type Estate struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Thumbnail            string   `protobuf:"bytes,2,opt,name=thumbnail,proto3" json:"thumbnail,omitempty"`
+	Name                 string   `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Description          string   `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Latitude             float64  `protobuf:"fixed64,5,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude            float64  `protobuf:"fixed64,6,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	Address              string   `protobuf:"bytes,7,opt,name=address,proto3" json:"address,omitempty"`
+	Rent                 int64    `protobuf:"varint,8,opt,name=rent,proto3" json:"rent,omitempty"`
+	DoorHeight           int64    `protobuf:"varint,9,opt,name=door_height,json=doorHeight,proto3" json:"door_height,omitempty"`
+	DoorWidth            int64    `protobuf:"varint,10,opt,name=door_width,json=doorWidth,proto3" json:"door_width,omitempty"`
+	Features             string   `protobuf:"bytes,11,opt,name=features,proto3" json:"features,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Estate) Reset()         { *m = Estate{} }
+func (m *Estate) String() string { return proto.CompactTextString(m) }
+func (*Estate) ProtoMessage()    {}
+func (*Estate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{1}
+}
+
+func (m *Estate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Estate.Unmarshal(m, b)
+}
+func (m *Estate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Estate.Marshal(b, m, deterministic)
+}
+func (m *Estate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Estate.Merge(m, src)
+}
+func (m *Estate) XXX_Size() int {
+	return xxx_messageInfo_Estate.Size(m)
+}
+func (m *Estate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Estate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Estate proto.InternalMessageInfo
+
+func (m *Estate) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Estate) GetThumbnail() string {
+	if m != nil {
+		return m.Thumbnail
+	}
+	return ""
+}
+
+func (m *Estate) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Estate) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+func (m *Estate) GetLatitude() float64 {
+	if m != nil {
+		return m.Latitude
+	}
+	return 0
+}
+
+func (m *Estate) GetLongitude() float64 {
+	if m != nil {
+		return m.Longitude
+	}
+	return 0
+}
+
+func (m *Estate) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *Estate) GetRent() int64 {
+	if m != nil {
+		return m.Rent
+	}
+	return 0
+}
+
+func (m *Estate) GetDoorHeight() int64 {
+	if m != nil {
+		return m.DoorHeight
+	}
+	return 0
+}
+
+func (m *Estate) GetDoorWidth() int64 {
+	if m != nil {
+		return m.DoorWidth
+	}
+	return 0
+}
+
+func (m *Estate) GetFeatures() string {
+	if m != nil {
+		return m.Features
+	}
+	return ""
+}
+
+type GetChairRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetChairRequest) Reset()         { *m = GetChairRequest{} }
+func (m *GetChairRequest) String() string { return proto.CompactTextString(m) }
+func (*GetChairRequest) ProtoMessage()    {}
+func (*GetChairRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{2}
+}
+
+func (m *GetChairRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetChairRequest.Unmarshal(m, b)
+}
+func (m *GetChairRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetChairRequest.Marshal(b, m, deterministic)
+}
+func (m *GetChairRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetChairRequest.Merge(m, src)
+}
+func (m *GetChairRequest) XXX_Size() int {
+	return xxx_messageInfo_GetChairRequest.Size(m)
+}
+func (m *GetChairRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetChairRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetChairRequest proto.InternalMessageInfo
+
+func (m *GetChairRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type SearchChairsRequest struct {
+	Page                 int32    `protobuf:"varint,1,opt,name=page,proto3" json:"page,omitempty"`
+	PerPage              int32    `protobuf:"varint,2,opt,name=per_page,json=perPage,proto3" json:"per_page,omitempty"`
+	PriceRangeId         string   `protobuf:"bytes,3,opt,name=price_range_id,json=priceRangeId,proto3" json:"price_range_id,omitempty"`
+	HeightRangeId        string   `protobuf:"bytes,4,opt,name=height_range_id,json=heightRangeId,proto3" json:"height_range_id,omitempty"`
+	WidthRangeId         string   `protobuf:"bytes,5,opt,name=width_range_id,json=widthRangeId,proto3" json:"width_range_id,omitempty"`
+	DepthRangeId         string   `protobuf:"bytes,6,opt,name=depth_range_id,json=depthRangeId,proto3" json:"depth_range_id,omitempty"`
+	Kind                 string   `protobuf:"bytes,7,opt,name=kind,proto3" json:"kind,omitempty"`
+	Color                string   `protobuf:"bytes,8,opt,name=color,proto3" json:"color,omitempty"`
+	Features             []string `protobuf:"bytes,9,rep,name=features,proto3" json:"features,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchChairsRequest) Reset()         { *m = SearchChairsRequest{} }
+func (m *SearchChairsRequest) String() string { return proto.CompactTextString(m) }
+func (*SearchChairsRequest) ProtoMessage()    {}
+func (*SearchChairsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{3}
+}
+
+func (m *SearchChairsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchChairsRequest.Unmarshal(m, b)
+}
+func (m *SearchChairsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchChairsRequest.Marshal(b, m, deterministic)
+}
+func (m *SearchChairsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchChairsRequest.Merge(m, src)
+}
+func (m *SearchChairsRequest) XXX_Size() int {
+	return xxx_messageInfo_SearchChairsRequest.Size(m)
+}
+func (m *SearchChairsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchChairsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchChairsRequest proto.InternalMessageInfo
+
+func (m *SearchChairsRequest) GetPage() int32 {
+	if m != nil {
+		return m.Page
+	}
+	return 0
+}
+
+func (m *SearchChairsRequest) GetPerPage() int32 {
+	if m != nil {
+		return m.PerPage
+	}
+	return 0
+}
+
+func (m *SearchChairsRequest) GetPriceRangeId() string {
+	if m != nil {
+		return m.PriceRangeId
+	}
+	return ""
+}
+
+func (m *SearchChairsRequest) GetHeightRangeId() string {
+	if m != nil {
+		return m.HeightRangeId
+	}
+	return ""
+}
+
+func (m *SearchChairsRequest) GetWidthRangeId() string {
+	if m != nil {
+		return m.WidthRangeId
+	}
+	return ""
+}
+
+func (m *SearchChairsRequest) GetDepthRangeId() string {
+	if m != nil {
+		return m.DepthRangeId
+	}
+	return ""
+}
+
+func (m *SearchChairsRequest) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *SearchChairsRequest) GetColor() string {
+	if m != nil {
+		return m.Color
+	}
+	return ""
+}
+
+func (m *SearchChairsRequest) GetFeatures() []string {
+	if m != nil {
+		return m.Features
+	}
+	return nil
+}
+
+type SearchChairsResponse struct {
+	Count                int64    `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	Chairs               []*Chair `protobuf:"bytes,2,rep,name=chairs,proto3" json:"chairs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchChairsResponse) Reset()         { *m = SearchChairsResponse{} }
+func (m *SearchChairsResponse) String() string { return proto.CompactTextString(m) }
+func (*SearchChairsResponse) ProtoMessage()    {}
+func (*SearchChairsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{4}
+}
+
+func (m *SearchChairsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchChairsResponse.Unmarshal(m, b)
+}
+func (m *SearchChairsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchChairsResponse.Marshal(b, m, deterministic)
+}
+func (m *SearchChairsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchChairsResponse.Merge(m, src)
+}
+func (m *SearchChairsResponse) XXX_Size() int {
+	return xxx_messageInfo_SearchChairsResponse.Size(m)
+}
+func (m *SearchChairsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchChairsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchChairsResponse proto.InternalMessageInfo
+
+func (m *SearchChairsResponse) GetCount() int64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *SearchChairsResponse) GetChairs() []*Chair {
+	if m != nil {
+		return m.Chairs
+	}
+	return nil
+}
+
+type ListLowPricedChairsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListLowPricedChairsRequest) Reset()         { *m = ListLowPricedChairsRequest{} }
+func (m *ListLowPricedChairsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListLowPricedChairsRequest) ProtoMessage()    {}
+func (*ListLowPricedChairsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{5}
+}
+
+func (m *ListLowPricedChairsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListLowPricedChairsRequest.Unmarshal(m, b)
+}
+func (m *ListLowPricedChairsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListLowPricedChairsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListLowPricedChairsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListLowPricedChairsRequest.Merge(m, src)
+}
+func (m *ListLowPricedChairsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListLowPricedChairsRequest.Size(m)
+}
+func (m *ListLowPricedChairsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListLowPricedChairsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListLowPricedChairsRequest proto.InternalMessageInfo
+
+type ChairList struct {
+	Chairs               []*Chair `protobuf:"bytes,1,rep,name=chairs,proto3" json:"chairs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ChairList) Reset()         { *m = ChairList{} }
+func (m *ChairList) String() string { return proto.CompactTextString(m) }
+func (*ChairList) ProtoMessage()    {}
+func (*ChairList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{6}
+}
+
+func (m *ChairList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChairList.Unmarshal(m, b)
+}
+func (m *ChairList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChairList.Marshal(b, m, deterministic)
+}
+func (m *ChairList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChairList.Merge(m, src)
+}
+func (m *ChairList) XXX_Size() int {
+	return xxx_messageInfo_ChairList.Size(m)
+}
+func (m *ChairList) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChairList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ChairList proto.InternalMessageInfo
+
+func (m *ChairList) GetChairs() []*Chair {
+	if m != nil {
+		return m.Chairs
+	}
+	return nil
+}
+
+type BuyChairRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Email                string   `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BuyChairRequest) Reset()         { *m = BuyChairRequest{} }
+func (m *BuyChairRequest) String() string { return proto.CompactTextString(m) }
+func (*BuyChairRequest) ProtoMessage()    {}
+func (*BuyChairRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{7}
+}
+
+func (m *BuyChairRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BuyChairRequest.Unmarshal(m, b)
+}
+func (m *BuyChairRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BuyChairRequest.Marshal(b, m, deterministic)
+}
+func (m *BuyChairRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BuyChairRequest.Merge(m, src)
+}
+func (m *BuyChairRequest) XXX_Size() int {
+	return xxx_messageInfo_BuyChairRequest.Size(m)
+}
+func (m *BuyChairRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BuyChairRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BuyChairRequest proto.InternalMessageInfo
+
+func (m *BuyChairRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *BuyChairRequest) GetEmail() string {
+	if m != nil {
+		return m.Email
+	}
+	return ""
+}
+
+type BuyChairResponse struct {
+	OrderId              int64    `protobuf:"varint,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BuyChairResponse) Reset()         { *m = BuyChairResponse{} }
+func (m *BuyChairResponse) String() string { return proto.CompactTextString(m) }
+func (*BuyChairResponse) ProtoMessage()    {}
+func (*BuyChairResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{8}
+}
+
+func (m *BuyChairResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BuyChairResponse.Unmarshal(m, b)
+}
+func (m *BuyChairResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BuyChairResponse.Marshal(b, m, deterministic)
+}
+func (m *BuyChairResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BuyChairResponse.Merge(m, src)
+}
+func (m *BuyChairResponse) XXX_Size() int {
+	return xxx_messageInfo_BuyChairResponse.Size(m)
+}
+func (m *BuyChairResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BuyChairResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BuyChairResponse proto.InternalMessageInfo
+
+func (m *BuyChairResponse) GetOrderId() int64 {
+	if m != nil {
+		return m.OrderId
+	}
+	return 0
+}
+
+type GetEstateRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetEstateRequest) Reset()         { *m = GetEstateRequest{} }
+func (m *GetEstateRequest) String() string { return proto.CompactTextString(m) }
+func (*GetEstateRequest) ProtoMessage()    {}
+func (*GetEstateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{9}
+}
+
+func (m *GetEstateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetEstateRequest.Unmarshal(m, b)
+}
+func (m *GetEstateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetEstateRequest.Marshal(b, m, deterministic)
+}
+func (m *GetEstateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetEstateRequest.Merge(m, src)
+}
+func (m *GetEstateRequest) XXX_Size() int {
+	return xxx_messageInfo_GetEstateRequest.Size(m)
+}
+func (m *GetEstateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetEstateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetEstateRequest proto.InternalMessageInfo
+
+func (m *GetEstateRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type SearchEstatesRequest struct {
+	Page                 int32    `protobuf:"varint,1,opt,name=page,proto3" json:"page,omitempty"`
+	PerPage              int32    `protobuf:"varint,2,opt,name=per_page,json=perPage,proto3" json:"per_page,omitempty"`
+	DoorHeightRangeId    string   `protobuf:"bytes,3,opt,name=door_height_range_id,json=doorHeightRangeId,proto3" json:"door_height_range_id,omitempty"`
+	DoorWidthRangeId     string   `protobuf:"bytes,4,opt,name=door_width_range_id,json=doorWidthRangeId,proto3" json:"door_width_range_id,omitempty"`
+	RentRangeId          string   `protobuf:"bytes,5,opt,name=rent_range_id,json=rentRangeId,proto3" json:"rent_range_id,omitempty"`
+	Features             []string `protobuf:"bytes,6,rep,name=features,proto3" json:"features,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchEstatesRequest) Reset()         { *m = SearchEstatesRequest{} }
+func (m *SearchEstatesRequest) String() string { return proto.CompactTextString(m) }
+func (*SearchEstatesRequest) ProtoMessage()    {}
+func (*SearchEstatesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{10}
+}
+
+func (m *SearchEstatesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchEstatesRequest.Unmarshal(m, b)
+}
+func (m *SearchEstatesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchEstatesRequest.Marshal(b, m, deterministic)
+}
+func (m *SearchEstatesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchEstatesRequest.Merge(m, src)
+}
+func (m *SearchEstatesRequest) XXX_Size() int {
+	return xxx_messageInfo_SearchEstatesRequest.Size(m)
+}
+func (m *SearchEstatesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchEstatesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchEstatesRequest proto.InternalMessageInfo
+
+func (m *SearchEstatesRequest) GetPage() int32 {
+	if m != nil {
+		return m.Page
+	}
+	return 0
+}
+
+func (m *SearchEstatesRequest) GetPerPage() int32 {
+	if m != nil {
+		return m.PerPage
+	}
+	return 0
+}
+
+func (m *SearchEstatesRequest) GetDoorHeightRangeId() string {
+	if m != nil {
+		return m.DoorHeightRangeId
+	}
+	return ""
+}
+
+func (m *SearchEstatesRequest) GetDoorWidthRangeId() string {
+	if m != nil {
+		return m.DoorWidthRangeId
+	}
+	return ""
+}
+
+func (m *SearchEstatesRequest) GetRentRangeId() string {
+	if m != nil {
+		return m.RentRangeId
+	}
+	return ""
+}
+
+func (m *SearchEstatesRequest) GetFeatures() []string {
+	if m != nil {
+		return m.Features
+	}
+	return nil
+}
+
+type SearchEstatesResponse struct {
+	Count                int64     `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	Estates              []*Estate `protobuf:"bytes,2,rep,name=estates,proto3" json:"estates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *SearchEstatesResponse) Reset()         { *m = SearchEstatesResponse{} }
+func (m *SearchEstatesResponse) String() string { return proto.CompactTextString(m) }
+func (*SearchEstatesResponse) ProtoMessage()    {}
+func (*SearchEstatesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{11}
+}
+
+func (m *SearchEstatesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchEstatesResponse.Unmarshal(m, b)
+}
+func (m *SearchEstatesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchEstatesResponse.Marshal(b, m, deterministic)
+}
+func (m *SearchEstatesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchEstatesResponse.Merge(m, src)
+}
+func (m *SearchEstatesResponse) XXX_Size() int {
+	return xxx_messageInfo_SearchEstatesResponse.Size(m)
+}
+func (m *SearchEstatesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchEstatesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchEstatesResponse proto.InternalMessageInfo
+
+func (m *SearchEstatesResponse) GetCount() int64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *SearchEstatesResponse) GetEstates() []*Estate {
+	if m != nil {
+		return m.Estates
+	}
+	return nil
+}
+
+type ListLowPricedEstatesRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListLowPricedEstatesRequest) Reset()         { *m = ListLowPricedEstatesRequest{} }
+func (m *ListLowPricedEstatesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListLowPricedEstatesRequest) ProtoMessage()    {}
+func (*ListLowPricedEstatesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{12}
+}
+
+func (m *ListLowPricedEstatesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListLowPricedEstatesRequest.Unmarshal(m, b)
+}
+func (m *ListLowPricedEstatesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListLowPricedEstatesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListLowPricedEstatesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListLowPricedEstatesRequest.Merge(m, src)
+}
+func (m *ListLowPricedEstatesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListLowPricedEstatesRequest.Size(m)
+}
+func (m *ListLowPricedEstatesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListLowPricedEstatesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListLowPricedEstatesRequest proto.InternalMessageInfo
+
+type EstateList struct {
+	Estates              []*Estate `protobuf:"bytes,1,rep,name=estates,proto3" json:"estates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *EstateList) Reset()         { *m = EstateList{} }
+func (m *EstateList) String() string { return proto.CompactTextString(m) }
+func (*EstateList) ProtoMessage()    {}
+func (*EstateList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{13}
+}
+
+func (m *EstateList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EstateList.Unmarshal(m, b)
+}
+func (m *EstateList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EstateList.Marshal(b, m, deterministic)
+}
+func (m *EstateList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EstateList.Merge(m, src)
+}
+func (m *EstateList) XXX_Size() int {
+	return xxx_messageInfo_EstateList.Size(m)
+}
+func (m *EstateList) XXX_DiscardUnknown() {
+	xxx_messageInfo_EstateList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EstateList proto.InternalMessageInfo
+
+func (m *EstateList) GetEstates() []*Estate {
+	if m != nil {
+		return m.Estates
+	}
+	return nil
+}
+
+type Coordinate struct {
+	Latitude             float64  `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude            float64  `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Coordinate) Reset()         { *m = Coordinate{} }
+func (m *Coordinate) String() string { return proto.CompactTextString(m) }
+func (*Coordinate) ProtoMessage()    {}
+func (*Coordinate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{14}
+}
+
+func (m *Coordinate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Coordinate.Unmarshal(m, b)
+}
+func (m *Coordinate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Coordinate.Marshal(b, m, deterministic)
+}
+func (m *Coordinate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Coordinate.Merge(m, src)
+}
+func (m *Coordinate) XXX_Size() int {
+	return xxx_messageInfo_Coordinate.Size(m)
+}
+func (m *Coordinate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Coordinate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Coordinate proto.InternalMessageInfo
+
+func (m *Coordinate) GetLatitude() float64 {
+	if m != nil {
+		return m.Latitude
+	}
+	return 0
+}
+
+func (m *Coordinate) GetLongitude() float64 {
+	if m != nil {
+		return m.Longitude
+	}
+	return 0
+}
+
+type SearchEstatesNazotteRequest struct {
+	Coordinates          []*Coordinate `protobuf:"bytes,1,rep,name=coordinates,proto3" json:"coordinates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *SearchEstatesNazotteRequest) Reset()         { *m = SearchEstatesNazotteRequest{} }
+func (m *SearchEstatesNazotteRequest) String() string { return proto.CompactTextString(m) }
+func (*SearchEstatesNazotteRequest) ProtoMessage()    {}
+func (*SearchEstatesNazotteRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{15}
+}
+
+func (m *SearchEstatesNazotteRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchEstatesNazotteRequest.Unmarshal(m, b)
+}
+func (m *SearchEstatesNazotteRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchEstatesNazotteRequest.Marshal(b, m, deterministic)
+}
+func (m *SearchEstatesNazotteRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchEstatesNazotteRequest.Merge(m, src)
+}
+func (m *SearchEstatesNazotteRequest) XXX_Size() int {
+	return xxx_messageInfo_SearchEstatesNazotteRequest.Size(m)
+}
+func (m *SearchEstatesNazotteRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchEstatesNazotteRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchEstatesNazotteRequest proto.InternalMessageInfo
+
+func (m *SearchEstatesNazotteRequest) GetCoordinates() []*Coordinate {
+	if m != nil {
+		return m.Coordinates
+	}
+	return nil
+}
+
+type ListRecommendedEstatesRequest struct {
+	ChairId              int64    `protobuf:"varint,1,opt,name=chair_id,json=chairId,proto3" json:"chair_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListRecommendedEstatesRequest) Reset()         { *m = ListRecommendedEstatesRequest{} }
+func (m *ListRecommendedEstatesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListRecommendedEstatesRequest) ProtoMessage()    {}
+func (*ListRecommendedEstatesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_1d42e253595dea4c, []int{16}
+}
+
+func (m *ListRecommendedEstatesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRecommendedEstatesRequest.Unmarshal(m, b)
+}
+func (m *ListRecommendedEstatesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRecommendedEstatesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListRecommendedEstatesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRecommendedEstatesRequest.Merge(m, src)
+}
+func (m *ListRecommendedEstatesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListRecommendedEstatesRequest.Size(m)
+}
+func (m *ListRecommendedEstatesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRecommendedEstatesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListRecommendedEstatesRequest proto.InternalMessageInfo
+
+func (m *ListRecommendedEstatesRequest) GetChairId() int64 {
+	if m != nil {
+		return m.ChairId
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*Chair)(nil), "isuumo.Chair")
+	proto.RegisterType((*Estate)(nil), "isuumo.Estate")
+	proto.RegisterType((*GetChairRequest)(nil), "isuumo.GetChairRequest")
+	proto.RegisterType((*SearchChairsRequest)(nil), "isuumo.SearchChairsRequest")
+	proto.RegisterType((*SearchChairsResponse)(nil), "isuumo.SearchChairsResponse")
+	proto.RegisterType((*ListLowPricedChairsRequest)(nil), "isuumo.ListLowPricedChairsRequest")
+	proto.RegisterType((*ChairList)(nil), "isuumo.ChairList")
+	proto.RegisterType((*BuyChairRequest)(nil), "isuumo.BuyChairRequest")
+	proto.RegisterType((*BuyChairResponse)(nil), "isuumo.BuyChairResponse")
+	proto.RegisterType((*GetEstateRequest)(nil), "isuumo.GetEstateRequest")
+	proto.RegisterType((*SearchEstatesRequest)(nil), "isuumo.SearchEstatesRequest")
+	proto.RegisterType((*SearchEstatesResponse)(nil), "isuumo.SearchEstatesResponse")
+	proto.RegisterType((*ListLowPricedEstatesRequest)(nil), "isuumo.ListLowPricedEstatesRequest")
+	proto.RegisterType((*EstateList)(nil), "isuumo.EstateList")
+	proto.RegisterType((*Coordinate)(nil), "isuumo.Coordinate")
+	proto.RegisterType((*SearchEstatesNazotteRequest)(nil), "isuumo.SearchEstatesNazotteRequest")
+	proto.RegisterType((*ListRecommendedEstatesRequest)(nil), "isuumo.ListRecommendedEstatesRequest")
+}
+
+func init() { proto.RegisterFile("isuumo.proto", fileDescriptor_1d42e253595dea4c) }
+
+var fileDescriptor_1d42e253595dea4c = []byte{
+	// 919 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0xdd, 0x6e, 0xdb, 0x36,
+	0x14, 0x86, 0xe5, 0x58, 0xb6, 0x8e, 0xf3, 0x57, 0xc6, 0xeb, 0x54, 0x25, 0xc1, 0x3c, 0xad, 0x1d,
+	0x72, 0x93, 0x64, 0xcb, 0xfe, 0x80, 0x15, 0xbd, 0x69, 0xb1, 0x75, 0x06, 0x82, 0xad, 0x50, 0x2e,
+	0x0a, 0xf4, 0xc6, 0x90, 0x45, 0xd6, 0x26, 0x66, 0x8b, 0x2a, 0x45, 0xa1, 0xe8, 0x5e, 0x61, 0x8f,
+	0xb0, 0xdb, 0x3d, 0xc0, 0x5e, 0x6a, 0xef, 0x31, 0xf0, 0x50, 0xb4, 0x7e, 0x62, 0x7b, 0x40, 0xaf,
+	0xcc, 0xf3, 0x9d, 0x4f, 0xe4, 0x39, 0x1f, 0xbf, 0x43, 0x18, 0xf6, 0x79, 0x5e, 0x14, 0x2b, 0x71,
+	0x95, 0x49, 0xa1, 0x04, 0x71, 0x4d, 0x14, 0xfe, 0xe9, 0x40, 0xef, 0xc5, 0x22, 0xe6, 0x92, 0x1c,
+	0x82, 0xc3, 0xa9, 0xdf, 0x19, 0x77, 0x2e, 0xba, 0x91, 0xc3, 0x29, 0x21, 0xb0, 0x97, 0xc6, 0x2b,
+	0xe6, 0x3b, 0xe3, 0xce, 0x85, 0x17, 0xe1, 0x9a, 0x8c, 0x61, 0x48, 0x59, 0x9e, 0x48, 0x9e, 0x29,
+	0x2e, 0x52, 0xbf, 0x8b, 0xa9, 0x3a, 0x44, 0xce, 0xc0, 0x53, 0x8b, 0x62, 0x35, 0x4b, 0x63, 0xbe,
+	0xf4, 0xf7, 0x30, 0x5f, 0x01, 0x64, 0x04, 0xbd, 0x4c, 0xf2, 0x84, 0xf9, 0x3d, 0x3c, 0xc6, 0x04,
+	0xe4, 0x21, 0xb8, 0x0b, 0xc6, 0xe7, 0x0b, 0xe5, 0xbb, 0x08, 0x97, 0x91, 0x66, 0xbf, 0xe7, 0x54,
+	0x2d, 0xfc, 0xbe, 0x61, 0x63, 0xa0, 0x51, 0xca, 0x32, 0xb5, 0xf0, 0x07, 0x06, 0xc5, 0x40, 0xa3,
+	0x89, 0x58, 0x0a, 0xe9, 0x7b, 0x78, 0xa6, 0x09, 0x48, 0x00, 0x83, 0xb7, 0x2c, 0x56, 0x85, 0x64,
+	0xb9, 0x0f, 0x98, 0x58, 0xc7, 0xba, 0xbf, 0xdf, 0x79, 0x4a, 0xfd, 0xa1, 0xe9, 0x4f, 0xaf, 0xc3,
+	0x7f, 0x1c, 0x70, 0x7f, 0xca, 0x55, 0xac, 0xd8, 0x3d, 0x39, 0x1a, 0x8d, 0x39, 0xed, 0xc6, 0xac,
+	0x58, 0xdd, 0xed, 0x62, 0xed, 0xdd, 0x17, 0x2b, 0x80, 0xc1, 0x32, 0x56, 0x5c, 0x15, 0xd4, 0x28,
+	0xd2, 0x89, 0xd6, 0xb1, 0x3e, 0x6f, 0x29, 0xd2, 0xb9, 0x49, 0xba, 0x98, 0xac, 0x00, 0xe2, 0x43,
+	0x3f, 0xa6, 0x54, 0xb2, 0x3c, 0x47, 0x71, 0xbc, 0xc8, 0x86, 0xba, 0x12, 0xc9, 0x52, 0x55, 0xaa,
+	0x83, 0x6b, 0xf2, 0x19, 0x0c, 0xa9, 0x10, 0x72, 0x5a, 0xaa, 0xec, 0x61, 0x0a, 0x34, 0xf4, 0x8b,
+	0x51, 0xfa, 0x1c, 0x30, 0x9a, 0x1a, 0xb9, 0x01, 0xf3, 0x9e, 0x46, 0x5e, 0xa3, 0xe4, 0x75, 0x19,
+	0x87, 0x4d, 0x19, 0xc3, 0xcf, 0xe1, 0xe8, 0x25, 0x53, 0x68, 0xa1, 0x88, 0xbd, 0x2b, 0x58, 0xae,
+	0xda, 0xd2, 0x85, 0x7f, 0x3b, 0x70, 0x72, 0xc7, 0x62, 0x99, 0x2c, 0x90, 0x96, 0x5b, 0x1e, 0x81,
+	0xbd, 0x2c, 0x9e, 0x33, 0x64, 0xf6, 0x22, 0x5c, 0x93, 0x47, 0x30, 0xc8, 0x98, 0x9c, 0x22, 0xee,
+	0x20, 0xde, 0xcf, 0x98, 0x7c, 0xa5, 0x53, 0x8f, 0xe1, 0x10, 0xfd, 0x32, 0x95, 0x71, 0x3a, 0x67,
+	0x53, 0x4e, 0x4b, 0xb5, 0xf7, 0x11, 0x8d, 0x34, 0x38, 0xa1, 0xe4, 0x4b, 0x38, 0x32, 0x6d, 0x56,
+	0x34, 0xa3, 0xfc, 0x81, 0x81, 0x2d, 0xef, 0x31, 0x1c, 0x62, 0xb7, 0x15, 0xad, 0x67, 0x76, 0x43,
+	0xb4, 0xc6, 0x42, 0x7f, 0x55, 0x2c, 0xd7, 0xb0, 0x10, 0xb5, 0x2c, 0x6b, 0xa5, 0x7e, 0x65, 0xa5,
+	0xca, 0x90, 0x83, 0x6d, 0x86, 0xf4, 0xc6, 0xdd, 0x86, 0x92, 0x77, 0x30, 0x6a, 0xaa, 0x94, 0x67,
+	0x22, 0xcd, 0x99, 0xd9, 0xa9, 0x48, 0x55, 0xa9, 0xa8, 0x09, 0xc8, 0x13, 0x70, 0x13, 0xe4, 0xf9,
+	0xce, 0xb8, 0x7b, 0x31, 0xbc, 0x39, 0xb8, 0x2a, 0xe7, 0xdb, 0x5c, 0x45, 0x99, 0x0c, 0xcf, 0x20,
+	0xb8, 0xe5, 0xb9, 0xba, 0x15, 0xef, 0x5f, 0x69, 0x95, 0x68, 0xe3, 0x06, 0xc2, 0x1b, 0xf0, 0x10,
+	0xd0, 0x94, 0xda, 0x8e, 0x9d, 0x5d, 0x3b, 0xfe, 0x00, 0x47, 0xcf, 0x8b, 0x0f, 0xbb, 0x2e, 0x5c,
+	0x57, 0xcc, 0x56, 0xd5, 0x9c, 0x98, 0x20, 0xbc, 0x84, 0xe3, 0xea, 0xc3, 0xb2, 0xb7, 0x47, 0x30,
+	0x10, 0x92, 0x32, 0x39, 0x5d, 0x7f, 0xdf, 0xc7, 0x78, 0x42, 0xc3, 0x10, 0x8e, 0x5f, 0x32, 0x65,
+	0xa6, 0x71, 0x9b, 0xb3, 0xfe, 0xed, 0x58, 0xcd, 0x0c, 0xef, 0x63, 0xad, 0x75, 0x0d, 0xa3, 0xda,
+	0x80, 0xb4, 0x0d, 0xf6, 0xa0, 0x9a, 0x14, 0x7b, 0xe3, 0x97, 0x70, 0x52, 0x0d, 0x4c, 0xdb, 0x69,
+	0xc7, 0xeb, 0xc9, 0xb1, 0xf4, 0x10, 0x0e, 0xf4, 0x20, 0xb6, 0xbd, 0x36, 0xd4, 0xa0, 0xe5, 0xd4,
+	0xad, 0xe1, 0xb6, 0xac, 0xf1, 0x1a, 0x3e, 0x69, 0xb5, 0xb9, 0xd3, 0x1b, 0x17, 0xd0, 0x67, 0x86,
+	0x58, 0x9a, 0xe3, 0xd0, 0x5e, 0x65, 0x29, 0xa7, 0x4d, 0x87, 0xe7, 0x70, 0xda, 0xb0, 0x47, 0x53,
+	0xc6, 0xf0, 0x7b, 0x00, 0x83, 0xa0, 0x41, 0x6a, 0xdb, 0x76, 0x76, 0x6f, 0xfb, 0x33, 0xc0, 0x0b,
+	0x21, 0x24, 0xe5, 0xa9, 0x7e, 0x4a, 0xeb, 0xcf, 0x5c, 0x67, 0xd7, 0x33, 0xe7, 0xb4, 0x9e, 0xb9,
+	0xf0, 0x0e, 0x4e, 0x1b, 0x7d, 0xff, 0x1a, 0xff, 0x21, 0x54, 0x65, 0x87, 0x6f, 0x61, 0x98, 0xac,
+	0x8f, 0xb1, 0x45, 0x91, 0xb5, 0x6d, 0xd7, 0xa9, 0xa8, 0x4e, 0x0b, 0x7f, 0x84, 0x73, 0xdd, 0x4e,
+	0xc4, 0x12, 0xb1, 0x5a, 0xb1, 0x94, 0xb6, 0xbb, 0xd6, 0x46, 0x41, 0xaf, 0xd7, 0x4c, 0x89, 0xf1,
+	0x84, 0xde, 0xfc, 0xd5, 0x03, 0x77, 0x82, 0xdb, 0x93, 0x1b, 0x18, 0xd8, 0x87, 0x8f, 0x7c, 0x6a,
+	0xcf, 0x6c, 0x3d, 0x85, 0x41, 0x73, 0x86, 0xc8, 0x04, 0xf6, 0xeb, 0x23, 0x4e, 0x4e, 0x6d, 0x7a,
+	0xc3, 0xf3, 0x18, 0x9c, 0x6d, 0x4e, 0x96, 0x37, 0x7f, 0x0b, 0x27, 0x1b, 0x06, 0x9b, 0x84, 0xf6,
+	0xa3, 0xed, 0x53, 0x1f, 0x3c, 0x68, 0x14, 0x85, 0x57, 0xfb, 0x0c, 0x06, 0x76, 0x36, 0xab, 0x66,
+	0x5a, 0x63, 0x1e, 0xf8, 0xf7, 0x13, 0x65, 0x31, 0xdf, 0x81, 0xb7, 0x9e, 0x55, 0xe2, 0xd7, 0xc4,
+	0x68, 0x8c, 0x6f, 0xd0, 0xf2, 0x0b, 0xb9, 0x85, 0x83, 0xc6, 0xf5, 0x92, 0x56, 0xcb, 0xcd, 0x7b,
+	0x09, 0xce, 0xb7, 0x64, 0xcb, 0x22, 0x7e, 0x83, 0xd1, 0x26, 0x2f, 0x93, 0x2f, 0x36, 0x4a, 0xd2,
+	0xda, 0x9b, 0x34, 0x4b, 0x43, 0x51, 0xde, 0xc0, 0x68, 0x93, 0xfb, 0xaa, 0x0d, 0x77, 0x78, 0xf3,
+	0xff, 0x8a, 0xbd, 0x83, 0x87, 0x9b, 0x4d, 0x48, 0x9e, 0xd4, 0xcb, 0xdd, 0x6a, 0xd2, 0x4d, 0x05,
+	0x3f, 0x7f, 0xf6, 0xe6, 0xe9, 0x9c, 0xab, 0x45, 0x31, 0xbb, 0x4a, 0xc4, 0xea, 0x9a, 0xe7, 0x45,
+	0x22, 0xd2, 0xf2, 0xe7, 0xeb, 0xaf, 0x2e, 0xdf, 0x15, 0xf1, 0x92, 0xbf, 0xfd, 0x70, 0x6d, 0xbe,
+	0x2b, 0x7f, 0xb2, 0xd9, 0x53, 0xbb, 0x98, 0xb9, 0xf8, 0xd7, 0xf0, 0x9b, 0xff, 0x06, 0x00, 0x87,
+	0xce, 0xf1, 0x27, 0x2a, 0x0a, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// IsuumoClient is the client API for Isuumo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type IsuumoClient interface {
+	GetChair(ctx context.Context, in *GetChairRequest, opts ...grpc.CallOption) (*Chair, error)
+	SearchChairs(ctx context.Context, in *SearchChairsRequest, opts ...grpc.CallOption) (*SearchChairsResponse, error)
+	ListLowPricedChairs(ctx context.Context, in *ListLowPricedChairsRequest, opts ...grpc.CallOption) (*ChairList, error)
+	BuyChair(ctx context.Context, in *BuyChairRequest, opts ...grpc.CallOption) (*BuyChairResponse, error)
+	GetEstate(ctx context.Context, in *GetEstateRequest, opts ...grpc.CallOption) (*Estate, error)
+	SearchEstates(ctx context.Context, in *SearchEstatesRequest, opts ...grpc.CallOption) (*SearchEstatesResponse, error)
+	ListLowPricedEstates(ctx context.Context, in *ListLowPricedEstatesRequest, opts ...grpc.CallOption) (*EstateList, error)
+	SearchEstatesNazotte(ctx context.Context, in *SearchEstatesNazotteRequest, opts ...grpc.CallOption) (*SearchEstatesResponse, error)
+	ListRecommendedEstates(ctx context.Context, in *ListRecommendedEstatesRequest, opts ...grpc.CallOption) (*EstateList, error)
+}
+
+type isuumoClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIsuumoClient(cc grpc.ClientConnInterface) IsuumoClient {
+	return &isuumoClient{cc}
+}
+
+func (c *isuumoClient) GetChair(ctx context.Context, in *GetChairRequest, opts ...grpc.CallOption) (*Chair, error) {
+	out := new(Chair)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/GetChair", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) SearchChairs(ctx context.Context, in *SearchChairsRequest, opts ...grpc.CallOption) (*SearchChairsResponse, error) {
+	out := new(SearchChairsResponse)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/SearchChairs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) ListLowPricedChairs(ctx context.Context, in *ListLowPricedChairsRequest, opts ...grpc.CallOption) (*ChairList, error) {
+	out := new(ChairList)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/ListLowPricedChairs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) BuyChair(ctx context.Context, in *BuyChairRequest, opts ...grpc.CallOption) (*BuyChairResponse, error) {
+	out := new(BuyChairResponse)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/BuyChair", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) GetEstate(ctx context.Context, in *GetEstateRequest, opts ...grpc.CallOption) (*Estate, error) {
+	out := new(Estate)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/GetEstate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) SearchEstates(ctx context.Context, in *SearchEstatesRequest, opts ...grpc.CallOption) (*SearchEstatesResponse, error) {
+	out := new(SearchEstatesResponse)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/SearchEstates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) ListLowPricedEstates(ctx context.Context, in *ListLowPricedEstatesRequest, opts ...grpc.CallOption) (*EstateList, error) {
+	out := new(EstateList)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/ListLowPricedEstates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) SearchEstatesNazotte(ctx context.Context, in *SearchEstatesNazotteRequest, opts ...grpc.CallOption) (*SearchEstatesResponse, error) {
+	out := new(SearchEstatesResponse)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/SearchEstatesNazotte", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *isuumoClient) ListRecommendedEstates(ctx context.Context, in *ListRecommendedEstatesRequest, opts ...grpc.CallOption) (*EstateList, error) {
+	out := new(EstateList)
+	err := c.cc.Invoke(ctx, "/isuumo.Isuumo/ListRecommendedEstates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IsuumoServer is the server API for Isuumo service.
+type IsuumoServer interface {
+	GetChair(context.Context, *GetChairRequest) (*Chair, error)
+	SearchChairs(context.Context, *SearchChairsRequest) (*SearchChairsResponse, error)
+	ListLowPricedChairs(context.Context, *ListLowPricedChairsRequest) (*ChairList, error)
+	BuyChair(context.Context, *BuyChairRequest) (*BuyChairResponse, error)
+	GetEstate(context.Context, *GetEstateRequest) (*Estate, error)
+	SearchEstates(context.Context, *SearchEstatesRequest) (*SearchEstatesResponse, error)
+	ListLowPricedEstates(context.Context, *ListLowPricedEstatesRequest) (*EstateList, error)
+	SearchEstatesNazotte(context.Context, *SearchEstatesNazotteRequest) (*SearchEstatesResponse, error)
+	ListRecommendedEstates(context.Context, *ListRecommendedEstatesRequest) (*EstateList, error)
+}
+
+// UnimplementedIsuumoServer can be embedded to have forward compatible implementations.
+type UnimplementedIsuumoServer struct {
+}
+
+func (*UnimplementedIsuumoServer) GetChair(ctx context.Context, req *GetChairRequest) (*Chair, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChair not implemented")
+}
+func (*UnimplementedIsuumoServer) SearchChairs(ctx context.Context, req *SearchChairsRequest) (*SearchChairsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchChairs not implemented")
+}
+func (*UnimplementedIsuumoServer) ListLowPricedChairs(ctx context.Context, req *ListLowPricedChairsRequest) (*ChairList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLowPricedChairs not implemented")
+}
+func (*UnimplementedIsuumoServer) BuyChair(ctx context.Context, req *BuyChairRequest) (*BuyChairResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BuyChair not implemented")
+}
+func (*UnimplementedIsuumoServer) GetEstate(ctx context.Context, req *GetEstateRequest) (*Estate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEstate not implemented")
+}
+func (*UnimplementedIsuumoServer) SearchEstates(ctx context.Context, req *SearchEstatesRequest) (*SearchEstatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchEstates not implemented")
+}
+func (*UnimplementedIsuumoServer) ListLowPricedEstates(ctx context.Context, req *ListLowPricedEstatesRequest) (*EstateList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLowPricedEstates not implemented")
+}
+func (*UnimplementedIsuumoServer) SearchEstatesNazotte(ctx context.Context, req *SearchEstatesNazotteRequest) (*SearchEstatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchEstatesNazotte not implemented")
+}
+func (*UnimplementedIsuumoServer) ListRecommendedEstates(ctx context.Context, req *ListRecommendedEstatesRequest) (*EstateList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRecommendedEstates not implemented")
+}
+
+func RegisterIsuumoServer(s *grpc.Server, srv IsuumoServer) {
+	s.RegisterService(&_Isuumo_serviceDesc, srv)
+}
+
+func _Isuumo_GetChair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).GetChair(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/GetChair",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).GetChair(ctx, req.(*GetChairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_SearchChairs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchChairsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).SearchChairs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/SearchChairs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).SearchChairs(ctx, req.(*SearchChairsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_ListLowPricedChairs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLowPricedChairsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).ListLowPricedChairs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/ListLowPricedChairs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).ListLowPricedChairs(ctx, req.(*ListLowPricedChairsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_BuyChair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BuyChairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).BuyChair(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/BuyChair",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).BuyChair(ctx, req.(*BuyChairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_GetEstate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEstateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).GetEstate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/GetEstate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).GetEstate(ctx, req.(*GetEstateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_SearchEstates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchEstatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).SearchEstates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/SearchEstates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).SearchEstates(ctx, req.(*SearchEstatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_ListLowPricedEstates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLowPricedEstatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).ListLowPricedEstates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/ListLowPricedEstates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).ListLowPricedEstates(ctx, req.(*ListLowPricedEstatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_SearchEstatesNazotte_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchEstatesNazotteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).SearchEstatesNazotte(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/SearchEstatesNazotte",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).SearchEstatesNazotte(ctx, req.(*SearchEstatesNazotteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Isuumo_ListRecommendedEstates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRecommendedEstatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IsuumoServer).ListRecommendedEstates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/isuumo.Isuumo/ListRecommendedEstates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IsuumoServer).ListRecommendedEstates(ctx, req.(*ListRecommendedEstatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Isuumo_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "isuumo.Isuumo",
+	HandlerType: (*IsuumoServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetChair",
+			Handler:    _Isuumo_GetChair_Handler,
+		},
+		{
+			MethodName: "SearchChairs",
+			Handler:    _Isuumo_SearchChairs_Handler,
+		},
+		{
+			MethodName: "ListLowPricedChairs",
+			Handler:    _Isuumo_ListLowPricedChairs_Handler,
+		},
+		{
+			MethodName: "BuyChair",
+			Handler:    _Isuumo_BuyChair_Handler,
+		},
+		{
+			MethodName: "GetEstate",
+			Handler:    _Isuumo_GetEstate_Handler,
+		},
+		{
+			MethodName: "SearchEstates",
+			Handler:    _Isuumo_SearchEstates_Handler,
+		},
+		{
+			MethodName: "ListLowPricedEstates",
+			Handler:    _Isuumo_ListLowPricedEstates_Handler,
+		},
+		{
+			MethodName: "SearchEstatesNazotte",
+			Handler:    _Isuumo_SearchEstatesNazotte_Handler,
+		},
+		{
+			MethodName: "ListRecommendedEstates",
+			Handler:    _Isuumo_ListRecommendedEstates_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "isuumo.proto",
+}
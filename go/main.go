@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -18,165 +19,227 @@ import (
 	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
 	_ "github.com/newrelic/go-agent/v3/integrations/nrmysql"
 	"github.com/newrelic/go-agent/v3/newrelic"
-	"github.com/patrickmn/go-cache"
 )
 
 const Limit = 20
 const NazotteLimit = 50
-const cacheSleep = 50
 
 var mySQLConnectionData MySQLConnectionEnv
 var chairSearchCondition ChairSearchCondition
 var estateSearchCondition EstateSearchCondition
+var chairSearchConditionETag string
+var estateSearchConditionETag string
 
-var estateCache *cache.Cache
-var chairCache *cache.Cache
-var stockCache *cache.Cache
+var estateCache *EstateCache
+var chairCache *ChairCache
 
 type InitializeResponse struct {
 	Language string `json:"language"`
 }
 
-type RecordMapper struct {
-	Record []string
-
-	offset int
-	err    error
+//ConnectDB isuumoデータベースに接続する
+func (mc MySQLConnectionEnv) ConnectDB() (dbType, error) {
+	withState, _ := sqlx.Open("nrmysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", mc.withState.User, mc.withState.Password, mc.withState.Host, mc.withState.Port, mc.withState.DBName))
+	noState, _ := sqlx.Open("nrmysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", mc.noState.User, mc.noState.Password, mc.noState.Host, mc.noState.Port, mc.noState.DBName))
+	return dbType{
+		withState: withState,
+		noState:   noState,
+	}, nil
 }
 
-func (r *RecordMapper) next() (string, error) {
-	if r.err != nil {
-		return "", r.err
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
-	if r.offset >= len(r.Record) {
-		r.err = fmt.Errorf("too many read")
-		return "", r.err
-	}
-	s := r.Record[r.offset]
-	r.offset++
-	return s, nil
+	return defaultValue
 }
 
-func (r *RecordMapper) NextInt() int {
-	s, err := r.next()
-	if err != nil {
-		return 0
-	}
-	i, err := strconv.Atoi(s)
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(getEnv(key, ""))
 	if err != nil {
-		r.err = err
-		return 0
+		return defaultValue
 	}
-	return i
+	return d
 }
 
-func (r *RecordMapper) NextFloat() float64 {
-	s, err := r.next()
+// loadSearchConditions dirの検索条件を読み、条件とOpenAPIの文書のETagを作る
+func loadSearchConditions(dir string) error {
+	jsonText, err := ioutil.ReadFile(filepath.Join(dir, "chair_condition.json"))
 	if err != nil {
-		return 0
+		return err
 	}
-	f, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		r.err = err
-		return 0
-	}
-	return f
-}
+	json.Unmarshal(jsonText, &chairSearchCondition)
 
-func (r *RecordMapper) NextString() string {
-	s, err := r.next()
+	jsonText, err = ioutil.ReadFile(filepath.Join(dir, "estate_condition.json"))
 	if err != nil {
-		return ""
+		return err
 	}
-	return s
-}
+	json.Unmarshal(jsonText, &estateSearchCondition)
 
-func (r *RecordMapper) Err() error {
-	return r.err
-}
+	chairSearchConditionETag = staticETag(chairSearchCondition)
+	estateSearchConditionETag = staticETag(estateSearchCondition)
 
-//ConnectDB isuumoデータベースに接続する
-func (mc MySQLConnectionEnv) ConnectDB() (dbType, error) {
-	withState, _ := sqlx.Open("nrmysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", mc.withState.User, mc.withState.Password, mc.withState.Host, mc.withState.Port, mc.withState.DBName))
-	noState, _ := sqlx.Open("nrmysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", mc.noState.User, mc.noState.Password, mc.noState.Host, mc.noState.Port, mc.noState.DBName))
-	return dbType{
-		withState: withState,
-		noState:   noState,
-	}, nil
+	openAPIDocument = buildOpenAPI()
+	openAPIETag = staticETag(openAPIDocument)
+	return nil
 }
 
-func init() {
-	jsonText, err := ioutil.ReadFile("../fixture/chair_condition.json")
-	if err != nil {
+func main() {
+	if err := loadSearchConditions(filepath.Join("..", "fixture")); err != nil {
 		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
-	json.Unmarshal(jsonText, &chairSearchCondition)
 
-	jsonText, err = ioutil.ReadFile("../fixture/estate_condition.json")
+	app, _ := newrelic.NewApplication(
+		newrelic.ConfigAppName("ISUCON10"),
+		newrelic.ConfigLicense("d3224d588a43c8ea493456a20f605978471bNRAL"),
+		newrelic.ConfigDistributedTracerEnabled(true),
+	)
+	e := newEcho(app)
+
+	mySQLConnectionData = NewMySQLConnectionEnv()
+
+	var err error
+	db, err = mySQLConnectionData.ConnectDB()
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
+		e.Logger.Fatalf("DB connection failed : %v", err)
 	}
-	json.Unmarshal(jsonText, &estateSearchCondition)
+	db.withState.SetMaxOpenConns(10)
+	db.noState.SetMaxOpenConns(10)
+	defer db.withState.Close()
+	defer db.noState.Close()
+
+	cacheStaleWindow = getEnvDuration("CACHE_STALE_WINDOW", cacheStaleWindow)
+	bus := newCacheBus()
+	estateCache = newEstateCache(newGoCacheStore, bus)
+	chairCache = newChairCache(newGoCacheStore, bus)
+	bus.start(getEnvDuration("CACHE_BUS_INTERVAL", 200*time.Millisecond))
+
+	if n, err := strconv.Atoi(getEnv("STOCK_STREAM_LIMIT", "")); err == nil && n > 0 {
+		stockStreamLimit = n
+	}
+	stockStreamHeartbeat = getEnvDuration("STOCK_STREAM_HEARTBEAT", stockStreamHeartbeat)
+	stockStreamRetry = getEnvDuration("STOCK_STREAM_RETRY", stockStreamRetry)
+	stockStream = newStockHub(stockStreamLimit)
+
+	if n, err := strconv.Atoi(getEnv("WARMUP_SEARCHES", "")); err == nil && n >= 0 {
+		warmupSearches = n
+	}
+	if n, err := strconv.Atoi(getEnv("WARMUP_RECOMMENDATIONS", "")); err == nil && n >= 0 {
+		warmupRecommendations = n
+	}
+	warmupTimeout = getEnvDuration("WARMUP_TIMEOUT", warmupTimeout)
+	go warmUp("startup")
+
+	idempotencyRetention = getEnvDuration("IDEMPOTENCY_RETENTION", idempotencyRetention)
+	idempotencyLease = getEnvDuration("IDEMPOTENCY_LEASE", idempotencyLease)
+	if s := getEnv("TRUSTED_PROXIES", ""); s != "" {
+		if trustedProxies, err = parseCIDRs(s); err != nil {
+			e.Logger.Fatalf("invalid TRUSTED_PROXIES : %v", err)
+		}
+	}
+	go purgeIdempotencyKeys(time.Minute)
+
+	restockSender = newRestockSender(getEnv("RESTOCK_SENDER", "outbox"))
+	adminToken = getEnv("ADMIN_TOKEN", "")
+	if n, err := strconv.Atoi(getEnv("IMPORT_BATCH_SIZE", "")); err == nil && n > 0 {
+		importBatchSize = n
+	}
+	importSpoolDir = getEnv("IMPORT_SPOOL_DIR", importSpoolDir)
+	importJobScanInterval = getEnvDuration("IMPORT_SCAN_INTERVAL", importJobScanInterval)
+	importWorkers, err := strconv.Atoi(getEnv("IMPORT_WORKERS", "1"))
+	if err != nil || importWorkers < 1 {
+		importWorkers = 1
+	}
+	startImportWorkers(importWorkers)
+
+	// gRPC. HTTPと同じキャッシュとDBを使い、別のポートで受ける
+	if port := getEnv("GRPC_PORT", "1324"); port != "off" {
+		if err := startGRPCServer(":" + port); err != nil {
+			e.Logger.Fatalf("gRPC listen failed : %v", err)
+		}
+	}
+
+	// Start server
+	serverPort := fmt.Sprintf(":%v", "1323")
+	e.Logger.Fatal(e.Start(serverPort))
 }
 
-func main() {
+// newEcho ミドルウェアと全てのルートを登録する。appがnilならNew Relicには送らない
+func newEcho(app *newrelic.Application) *echo.Echo {
 	// Echo instance
 	e := echo.New()
 	e.Debug = true
 	e.Logger.SetLevel(log.DEBUG)
 
+	e.HTTPErrorHandler = httpErrorHandler
+	e.Validator = requestValidator{}
+
 	// Middleware
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	app, _ := newrelic.NewApplication(
-		newrelic.ConfigAppName("ISUCON10"),
-		newrelic.ConfigLicense("d3224d588a43c8ea493456a20f605978471bNRAL"),
-		newrelic.ConfigDistributedTracerEnabled(true),
-	)
 	e.Use(nrecho.Middleware(app))
+	if getEnv("OPENAPI_CONTRACT_CHECK", "") == "true" {
+		e.Use(contractCheck())
+	}
 
 	// Initialize
 	e.POST("/initialize", initialize)
 
 	// Chair Handler
-	e.GET("/api/chair/:id", getChairDetail)
-	e.POST("/api/chair", postChair)
-	e.GET("/api/chair/search", searchChairs)
-	e.GET("/api/chair/low_priced", getLowPricedChair)
+	e.GET("/api/chair/:id", getChairDetail, admission("chair_detail"), bindRequest(IDRequest{}))
+	e.GET("/api/chair", getChairDetails, admission("chair_detail"), bindRequest(IDsRequest{}))
+	e.POST("/api/chair", postChair, admission("chair_import"), bindRequest(ImportQuery{}))
+	e.GET("/api/chair/search", searchChairs, admission("chair_search"), bindRequest(ChairSearchRequest{}))
+	e.GET("/api/chair/low_priced", getLowPricedChair, admission("chair_low_priced"))
 	e.GET("/api/chair/search/condition", getChairSearchCondition)
-	e.POST("/api/chair/buy/:id", buyChair)
+	e.POST("/api/chair/buy/:id", buyChair, admission("chair_buy"), bindRequest(BuyChairRequest{}), idempotency)
+	e.POST("/api/chair/order/:id/cancel", cancelChairOrder, bindRequest(ChairOrderCancelRequest{}), idempotency)
+	e.POST("/api/chair/:id/notify", postChairRestockNotify, bindRequest(RestockSubscribeRequest{}))
+	e.GET("/api/chair/:id/stream", streamChairStock, bindRequest(IDRequest{}))
+	e.GET("/api/chair/stream", streamChairsStock, bindRequest(IDsRequest{}))
 
 	// Estate Handler
-	e.GET("/api/estate/:id", getEstateDetail)
-	e.POST("/api/estate", postEstate)
-	e.GET("/api/estate/search", searchEstates)
-	e.GET("/api/estate/low_priced", getLowPricedEstate)
-	e.POST("/api/estate/req_doc/:id", postEstateRequestDocument)
-	e.POST("/api/estate/nazotte", searchEstateNazotte)
+	e.GET("/api/estate/:id", getEstateDetail, admission("estate_detail"), bindRequest(IDRequest{}))
+	e.GET("/api/estate", getEstateDetails, admission("estate_detail"), bindRequest(IDsRequest{}))
+	e.POST("/api/estate", postEstate, admission("estate_import"), bindRequest(ImportQuery{}))
+	e.GET("/api/estate/search", searchEstates, admission("estate_search"), bindRequest(EstateSearchRequest{}))
+	e.GET("/api/estate/low_priced", getLowPricedEstate, admission("estate_low_priced"))
+	e.POST("/api/estate/req_doc/:id", postEstateRequestDocument, bindRequest(RequestDocumentRequest{}), idempotency)
+	e.POST("/api/estate/nazotte", searchEstateNazotte, admission("estate_nazotte"), bindRequest(Coordinates{}))
 	e.GET("/api/estate/search/condition", getEstateSearchCondition)
-	e.GET("/api/recommended_estate/:id", searchRecommendedEstateWithChair)
+	e.GET("/api/recommended_estate/:id", searchRecommendedEstateWithChair, admission("recommended_estate"), bindRequest(IDRequest{}))
 
-	mySQLConnectionData = NewMySQLConnectionEnv()
+	// API v2. v1のレスポンスはそのまま残し、読み出しと、注文のidを返す購入をv2の形で返す
+	v2 := e.Group("/api/v2")
+	v2.GET("/chair/:id", getChairDetailV2, admission("chair_detail"), bindRequest(ChairDetailRequestV2{}))
+	v2.GET("/chair/search", searchChairsV2, admission("chair_search"), bindRequest(ChairSearchRequestV2{}))
+	v2.POST("/chair/buy/:id", buyChairV2, admission("chair_buy"), bindRequest(BuyChairRequest{}), idempotency)
+	v2.GET("/chair/low_priced", getLowPricedChairV2, admission("chair_low_priced"), bindRequest(ChairFieldsV2{}))
+	v2.GET("/estate/:id", getEstateDetailV2, admission("estate_detail"), bindRequest(EstateDetailRequestV2{}))
+	v2.GET("/estate/search", searchEstatesV2, admission("estate_search"), bindRequest(EstateSearchRequestV2{}))
+	v2.GET("/estate/low_priced", getLowPricedEstateV2, admission("estate_low_priced"), bindRequest(EstateFieldsV2{}))
+	v2.POST("/estate/nazotte", searchEstateNazotteV2, admission("estate_nazotte"), bindRequest(NazotteRequestV2{}))
+	v2.GET("/recommended_estate/:id", searchRecommendedEstateWithChairV2, admission("recommended_estate"), bindRequest(RecommendedEstateRequestV2{}))
 
-	var err error
-	db, err = mySQLConnectionData.ConnectDB()
-	if err != nil {
-		e.Logger.Fatalf("DB connection failed : %v", err)
-	}
-	db.withState.SetMaxOpenConns(10)
-	db.noState.SetMaxOpenConns(10)
-	defer db.withState.Close()
-	defer db.noState.Close()
+	// Import Job Handler
+	e.GET("/api/imports/:id", getImportJob, bindRequest(IDRequest{}))
 
-	estateCache = cache.New(5*time.Minute, 10*time.Minute)
-	chairCache = cache.New(5*time.Minute, 10*time.Minute)
-	stockCache = cache.New(5*time.Minute, 10*time.Minute)
+	// Admin Handler
+	admin := e.Group("/api/admin", adminAuth())
+	admin.PATCH("/chair/:id", patchAdminChair, bindRequest(ChairUpdateRequest{}))
+	admin.PATCH("/estate/:id", patchAdminEstate, bindRequest(EstateUpdateRequest{}))
+	admin.GET("/export/chairs", exportChairs, bindRequest(ChairExportQuery{}))
+	admin.GET("/export/estates", exportEstates, bindRequest(EstateExportQuery{}))
+	admin.GET("/cache/stats", getCacheStats)
+	admin.GET("/warmup", getWarmupReport)
 
-	// Start server
-	serverPort := fmt.Sprintf(":%v", "1323")
-	e.Logger.Fatal(e.Start(serverPort))
+	// OpenAPI
+	e.GET("/api/openapi.json", getOpenAPI)
+	checkOpenAPICoverage(e)
+
+	return e
 }
 
 func initialize(c echo.Context) error {
@@ -186,8 +249,8 @@ func initialize(c echo.Context) error {
 		filepath.Join(sqlDir, "1_DummyEstateData.sql"),
 		filepath.Join(sqlDir, "2_DummyChairData.sql"),
 	}
-	ch1 := make(chan bool)
-	ch2 := make(chan bool)
+	ch1 := make(chan error)
+	ch2 := make(chan error)
 	go func() {
 		for _, p := range paths {
 			sqlFile, _ := filepath.Abs(p)
@@ -200,11 +263,11 @@ func initialize(c echo.Context) error {
 				sqlFile,
 			)
 			if err := exec.Command("bash", "-c", cmdStr).Run(); err != nil {
-				c.Logger().Errorf("Initialize script error : %v", err)
-				panic(err)
+				ch1 <- err
+				return
 			}
 		}
-		ch1 <- true
+		ch1 <- nil
 	}()
 	go func() {
 		for _, p := range paths {
@@ -218,18 +281,26 @@ func initialize(c echo.Context) error {
 				sqlFile,
 			)
 			if err := exec.Command("bash", "-c", cmdStr).Run(); err != nil {
-				c.Logger().Errorf("Initialize script error : %v", err)
-				panic(err)
+				ch2 <- err
+				return
 			}
 		}
-		ch2 <- true
+		ch2 <- nil
 	}()
-	<-ch1
-	<-ch2
+	err1 := <-ch1
+	err2 := <-ch2
 
-	estateCache.Flush()
-	chairCache.Flush()
-	stockCache.Flush()
+	// 途中で失敗してもDBは書き換わっているので、キャッシュは消しておく
+	estateCache.Flush(c.Request().Context())
+	chairCache.Flush(c.Request().Context())
+	go stockStream.refresh(context.Background(), nil)
+	resetImportJobs()
+	for _, err := range []error{err1, err2} {
+		if err != nil {
+			return internalError("Initialize script error", err)
+		}
+	}
+	go warmUp("initialize")
 
 	return c.JSON(http.StatusOK, InitializeResponse{
 		Language: "go",
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testAdminToken 管理APIのテストで送るトークン
+const testAdminToken = "test-admin-token"
+
+// testTables fakeDBに入れておく行。椅子1と物件1はどの検索にも当たる
+func testTables() map[string]*fakeTable {
+	return map[string]*fakeTable{
+		"chair": {
+			columns: []string{"id", "name", "description", "thumbnail", "price", "height", "width", "depth", "color", "features", "kind", "popularity", "stock"},
+			rows: [][]driver.Value{
+				{int64(1), "ゲーミングチェア", "よく回る", "/images/chair/1.png", int64(5000), int64(100), int64(60), int64(60), "黒", "肘かけ,キャスター", "ゲーミングチェア", int64(1000), int64(3)},
+			},
+		},
+		"estate": {
+			columns: []string{"id", "thumbnail", "name", "description", "latitude", "longitude", "address", "rent", "door_height", "door_width", "features", "popularity"},
+			rows: [][]driver.Value{
+				{int64(1), "/images/estate/1.png", "イスウーモ荘", "駅から近い", 35.6, 139.7, "東京都千代田区", int64(80000), int64(200), int64(100), "オートロック", int64(500)},
+			},
+		},
+		"chair_order": {
+			columns: []string{"id", "chair_id", "email", "canceled"},
+			rows: [][]driver.Value{
+				{int64(1), int64(1), "buyer@example.com", false},
+			},
+		},
+		"import_job": {
+			columns: []string{"id", "generation", "target", "mode", "format", "options", "file_path", "status", "rows_processed", "summary", "errors", "error_count", "duration_ms"},
+			rows: [][]driver.Value{
+				{int64(1), int64(1), "chair", "insert", "csv", "{}", "/tmp/chair.csv", "queued", int64(0), "", "", int64(0), int64(0)},
+			},
+		},
+		"idempotency_key":            {columns: []string{"client", "idempotency_key", "fingerprint", "status_code", "content_type", "response_body"}},
+		"chair_restock_subscription": {columns: []string{"subscription_id", "email", "chair_id", "name", "stock"}},
+		"cache_invalidation":         {columns: []string{"id", "target", "op", "cache_keys", "created_at"}},
+	}
+}
+
+func TestMain(m *testing.M) {
+	if err := loadSearchConditions("testdata"); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	fake := newFakeDB(testTables())
+	db = dbType{withState: fake.open(), noState: fake.open()}
+	estateCache = newEstateCache(newGoCacheStore, nil)
+	chairCache = newChairCache(newGoCacheStore, nil)
+	stockStream = newStockHub(stockStreamLimit)
+	adminToken = testAdminToken
+
+	os.Exit(m.Run())
+}
+
+// serveRequest ルーターにリクエストを送る。bodyはJSONで送り、headerは追加で付ける
+func serveRequest(e *echo.Echo, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitFor 別のgoroutineの処理を待つ。1秒待ってもdoneにならなければ失敗する
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// withTables testTablesをchangeで書き換えたfakeDBでfを呼び、終わったら元のDBに戻す
+func withTables(change func(tables map[string]*fakeTable), f func(fake *fakeDB)) {
+	tables := testTables()
+	change(tables)
+	fake := newFakeDB(tables)
+	saved := db
+	db = dbType{withState: fake.open(), noState: fake.open()}
+	defer func() { db = saved }()
+	f(fake)
+}
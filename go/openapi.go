@@ -0,0 +1,584 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openAPIVersion ドキュメントのinfo.version。レスポンスの形やパラメータを変えたら上げる
+const openAPIVersion = "1.2.0"
+
+var openAPIDocument map[string]interface{}
+var openAPIETag string
+
+// apiOperation ドキュメントに書く1つのエンドポイント。pathはechoに登録したものと同じ書き方にする
+type apiOperation struct {
+	id      string
+	method  string
+	path    string
+	tag     string
+	summary string
+	// request bindRequestに渡すものと同じ型。param・query・jsonタグからparametersとrequestBodyを作る
+	request interface{}
+	// body requestから作れないリクエストボディ
+	body apiContent
+	// admin 管理APIのトークンが必要
+	admin bool
+	// idempotent Idempotency-Keyを受け付ける
+	idempotent bool
+	// conditional ETagを返し、If-None-Matchで304を返す
+	conditional bool
+	responses   []apiResponse
+}
+
+// apiResponse bodyがGoの値ならそのJSONの形、[]interface{}ならそのいずれか、apiContentならそのまま書く
+type apiResponse struct {
+	status      int
+	description string
+	body        interface{}
+}
+
+// apiContent メディアタイプごとのスキーマ
+type apiContent map[string]interface{}
+
+// importBody CSVのファイルかJSON/NDJSONの本文。fieldはmultipartでファイルを送るフィールド名
+func importBody(field string) apiContent {
+	return apiContent{
+		echo.MIMEMultipartForm: map[string]interface{}{
+			"type":     "object",
+			"required": []string{field},
+			"properties": map[string]interface{}{
+				field: map[string]interface{}{"type": "string", "format": "binary"},
+			},
+		},
+		"text/csv":               map[string]interface{}{"type": "string"},
+		echo.MIMEApplicationJSON: map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+		"application/x-ndjson":   map[string]interface{}{"type": "string"},
+	}
+}
+
+// importResponses インポートは同期なら結果、asyncなら202でジョブを返す。不正な行があれば400のdetailsに行ごとのエラーを返す
+var importResponses = []apiResponse{
+	{http.StatusCreated, "imported", ImportSummary{}},
+	{http.StatusOK, "deleted, or the result of a dry run", []interface{}{ImportSummary{}, ImportReport{}}},
+	{http.StatusAccepted, "queued as an import job", ImportJobResponse{}},
+	{http.StatusBadRequest, "invalid parameters, or invalid rows with the ImportReport in details", APIError{}},
+}
+
+// exportResponses 書き出しはformatの形式で全ての列を返す
+var exportResponses = []apiResponse{
+	{http.StatusOK, "all rows in the requested format", apiContent{
+		"text/csv":                          map[string]interface{}{"type": "string"},
+		echo.MIMEApplicationJSONCharsetUTF8: map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+		"application/x-ndjson":              map[string]interface{}{"type": "string"},
+	}},
+}
+
+// stockStreamResponses 在庫の変化はSSEで流す。各イベントのdataはStockEventのJSON
+var stockStreamResponses = []apiResponse{
+	{http.StatusOK, "stock and soldOut events with the current stock first, and heartbeat comments", apiContent{
+		"text/event-stream": map[string]interface{}{"type": "string"},
+	}},
+}
+
+// apiOperations main.goで登録している全てのエンドポイント。追加したらここにも書く
+var apiOperations = []apiOperation{
+	{id: "initialize", method: http.MethodPost, path: "/initialize", tag: "initialize", summary: "Recreate the database and flush the caches",
+		responses: []apiResponse{{http.StatusOK, "initialized", InitializeResponse{}}}},
+
+	{id: "getChairDetail", method: http.MethodGet, path: "/api/chair/:id", tag: "chair", summary: "Get a chair in stock",
+		request: IDRequest{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "the chair", Chair{}}}},
+	{id: "getChairDetails", method: http.MethodGet, path: "/api/chair", tag: "chair", summary: "Get chairs in stock by ids",
+		request: IDsRequest{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "chairs in the order of ids, and the ids not found or sold out", ChairDetailsResponse{}}}},
+	{id: "postChair", method: http.MethodPost, path: "/api/chair", tag: "chair", summary: "Import chairs",
+		request: ImportQuery{}, body: importBody("chairs"), responses: importResponses},
+	{id: "searchChairs", method: http.MethodGet, path: "/api/chair/search", tag: "chair", summary: "Search chairs in stock",
+		request:   ChairSearchRequest{},
+		responses: []apiResponse{{http.StatusOK, "a page of chairs and the total count", ChairSearchResponse{}}}},
+	{id: "getLowPricedChair", method: http.MethodGet, path: "/api/chair/low_priced", tag: "chair", summary: "List the lowest priced chairs in stock",
+		conditional: true,
+		responses:   []apiResponse{{http.StatusOK, "chairs in price order", ChairListResponse{}}}},
+	{id: "getChairSearchCondition", method: http.MethodGet, path: "/api/chair/search/condition", tag: "chair", summary: "Get the chair search conditions",
+		conditional: true,
+		responses:   []apiResponse{{http.StatusOK, "ranges and lists used by the search", ChairSearchCondition{}}}},
+	{id: "buyChair", method: http.MethodPost, path: "/api/chair/buy/:id", tag: "chair", summary: "Buy a chair",
+		request: BuyChairRequest{}, idempotent: true,
+		responses: []apiResponse{{http.StatusOK, "ordered. buyChairV2 returns the order id", nil}}},
+	{id: "cancelChairOrder", method: http.MethodPost, path: "/api/chair/order/:id/cancel", tag: "chair", summary: "Cancel a chair order",
+		request: ChairOrderCancelRequest{}, idempotent: true,
+		responses: []apiResponse{{http.StatusOK, "cancelled", nil}}},
+	{id: "postChairRestockNotify", method: http.MethodPost, path: "/api/chair/:id/notify", tag: "chair", summary: "Subscribe to a restock notification of a sold-out chair",
+		request:   RestockSubscribeRequest{},
+		responses: []apiResponse{{http.StatusCreated, "subscribed", nil}}},
+	{id: "streamChairStock", method: http.MethodGet, path: "/api/chair/:id/stream", tag: "chair", summary: "Stream stock changes of a chair",
+		request: IDRequest{}, responses: stockStreamResponses},
+	{id: "streamChairsStock", method: http.MethodGet, path: "/api/chair/stream", tag: "chair", summary: "Stream stock changes of chairs by ids",
+		request: IDsRequest{}, responses: stockStreamResponses},
+
+	{id: "getEstateDetail", method: http.MethodGet, path: "/api/estate/:id", tag: "estate", summary: "Get an estate",
+		request: IDRequest{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "the estate", Estate{}}}},
+	{id: "getEstateDetails", method: http.MethodGet, path: "/api/estate", tag: "estate", summary: "Get estates by ids",
+		request: IDsRequest{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "estates in the order of ids, and the ids not found", EstateDetailsResponse{}}}},
+	{id: "postEstate", method: http.MethodPost, path: "/api/estate", tag: "estate", summary: "Import estates",
+		request: ImportQuery{}, body: importBody("estates"), responses: importResponses},
+	{id: "searchEstates", method: http.MethodGet, path: "/api/estate/search", tag: "estate", summary: "Search estates",
+		request:   EstateSearchRequest{},
+		responses: []apiResponse{{http.StatusOK, "a page of estates and the total count", EstateSearchResponse{}}}},
+	{id: "getLowPricedEstate", method: http.MethodGet, path: "/api/estate/low_priced", tag: "estate", summary: "List the lowest rent estates",
+		conditional: true,
+		responses:   []apiResponse{{http.StatusOK, "estates in rent order", EstateListResponse{}}}},
+	{id: "postEstateRequestDocument", method: http.MethodPost, path: "/api/estate/req_doc/:id", tag: "estate", summary: "Request the documents of an estate",
+		request: RequestDocumentRequest{}, idempotent: true,
+		responses: []apiResponse{{http.StatusOK, "requested", nil}}},
+	{id: "searchEstateNazotte", method: http.MethodPost, path: "/api/estate/nazotte", tag: "estate", summary: "Search estates inside a polygon",
+		request:   Coordinates{},
+		responses: []apiResponse{{http.StatusOK, "estates inside the polygon", EstateSearchResponse{}}}},
+	{id: "getEstateSearchCondition", method: http.MethodGet, path: "/api/estate/search/condition", tag: "estate", summary: "Get the estate search conditions",
+		conditional: true,
+		responses:   []apiResponse{{http.StatusOK, "ranges and lists used by the search", EstateSearchCondition{}}}},
+	{id: "searchRecommendedEstateWithChair", method: http.MethodGet, path: "/api/recommended_estate/:id", tag: "estate", summary: "List estates the chair fits through",
+		request:   IDRequest{},
+		responses: []apiResponse{{http.StatusOK, "estates in popularity order", EstateListResponse{}}}},
+
+	{id: "getChairDetailV2", method: http.MethodGet, path: "/api/v2/chair/:id", tag: "v2", summary: "Get a chair with its stock and availability",
+		request: ChairDetailRequestV2{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "the chair, including a sold out one", ChairV2{}}}},
+	{id: "searchChairsV2", method: http.MethodGet, path: "/api/v2/chair/search", tag: "v2", summary: "Search chairs in stock",
+		request:   ChairSearchRequestV2{},
+		responses: []apiResponse{{http.StatusOK, "a page of chairs with pagination and links", ChairListV2{}}}},
+	{id: "buyChairV2", method: http.MethodPost, path: "/api/v2/chair/buy/:id", tag: "v2", summary: "Buy a chair and get the order id",
+		request: BuyChairRequest{}, idempotent: true,
+		responses: []apiResponse{{http.StatusOK, "ordered", ChairOrderResponse{}}}},
+	{id: "getLowPricedChairV2", method: http.MethodGet, path: "/api/v2/chair/low_priced", tag: "v2", summary: "List the lowest priced chairs in stock",
+		request: ChairFieldsV2{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "chairs in price order", ChairListV2{}}}},
+	{id: "getEstateDetailV2", method: http.MethodGet, path: "/api/v2/estate/:id", tag: "v2", summary: "Get an estate with its popularity",
+		request: EstateDetailRequestV2{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "the estate", EstateV2{}}}},
+	{id: "searchEstatesV2", method: http.MethodGet, path: "/api/v2/estate/search", tag: "v2", summary: "Search estates",
+		request:   EstateSearchRequestV2{},
+		responses: []apiResponse{{http.StatusOK, "a page of estates with pagination and links", EstateListV2{}}}},
+	{id: "getLowPricedEstateV2", method: http.MethodGet, path: "/api/v2/estate/low_priced", tag: "v2", summary: "List the lowest rent estates",
+		request: EstateFieldsV2{}, conditional: true,
+		responses: []apiResponse{{http.StatusOK, "estates in rent order", EstateListV2{}}}},
+	{id: "searchEstateNazotteV2", method: http.MethodPost, path: "/api/v2/estate/nazotte", tag: "v2", summary: "Search estates inside a polygon",
+		request:   NazotteRequestV2{},
+		responses: []apiResponse{{http.StatusOK, "estates inside the polygon", EstateListV2{}}}},
+	{id: "searchRecommendedEstateWithChairV2", method: http.MethodGet, path: "/api/v2/recommended_estate/:id", tag: "v2", summary: "List estates the chair fits through",
+		request:   RecommendedEstateRequestV2{},
+		responses: []apiResponse{{http.StatusOK, "estates in popularity order", EstateListV2{}}}},
+
+	{id: "getImportJob", method: http.MethodGet, path: "/api/imports/:id", tag: "import", summary: "Get an import job",
+		request:   IDRequest{},
+		responses: []apiResponse{{http.StatusOK, "the import job", ImportJobResponse{}}}},
+
+	{id: "patchAdminChair", method: http.MethodPatch, path: "/api/admin/chair/:id", tag: "admin", summary: "Update fields of a chair",
+		request: ChairUpdateRequest{}, admin: true,
+		responses: []apiResponse{{http.StatusOK, "the updated chair", AdminChair{}}}},
+	{id: "patchAdminEstate", method: http.MethodPatch, path: "/api/admin/estate/:id", tag: "admin", summary: "Update fields of an estate",
+		request: EstateUpdateRequest{}, admin: true,
+		responses: []apiResponse{{http.StatusOK, "the updated estate", AdminEstate{}}}},
+	{id: "exportChairs", method: http.MethodGet, path: "/api/admin/export/chairs", tag: "admin", summary: "Export chairs",
+		request: ChairExportQuery{}, admin: true, responses: exportResponses},
+	{id: "exportEstates", method: http.MethodGet, path: "/api/admin/export/estates", tag: "admin", summary: "Export estates",
+		request: EstateExportQuery{}, admin: true, responses: exportResponses},
+	{id: "getCacheStats", method: http.MethodGet, path: "/api/admin/cache/stats", tag: "admin", summary: "Get cache statistics",
+		admin:     true,
+		responses: []apiResponse{{http.StatusOK, "statistics per namespace", []CacheStats{}}}},
+	{id: "getWarmupReport", method: http.MethodGet, path: "/api/admin/warmup", tag: "admin", summary: "Get the result of the last warm-up",
+		admin:     true,
+		responses: []apiResponse{{http.StatusOK, "the last warm-up", WarmupReport{}}}},
+
+	{id: "getOpenAPI", method: http.MethodGet, path: "/api/openapi.json", tag: "meta", summary: "Get this document",
+		conditional: true,
+		responses:   []apiResponse{{http.StatusOK, "OpenAPI 3 document", apiContent{echo.MIMEApplicationJSON: map[string]interface{}{"type": "object"}}}}},
+}
+
+// buildOpenAPI apiOperationsと型の定義からOpenAPI 3のドキュメントを作る。
+// enumや範囲は検索条件のファイルから取るので、loadSearchConditionsで読む時に呼ぶ
+func buildOpenAPI() map[string]interface{} {
+	schemas := openAPISchemas{}
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations {
+		path := openAPIPath(op.path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.method)] = schemas.operation(op)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ISUUMO API",
+			"version": openAPIVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}(schemas),
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// openAPIPath echoの:idをOpenAPIの{id}にする
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func getOpenAPI(c echo.Context) error {
+	if notModified(c, openAPIETag, startedAt, conditionCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, openAPIDocument)
+}
+
+// checkOpenAPICoverage 登録したルートとドキュメントの食い違いを起動時にログに出す
+func checkOpenAPICoverage(e *echo.Echo) {
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.method+" "+op.path] = true
+	}
+	registered := map[string]bool{}
+	for _, r := range e.Routes() {
+		// Groupのミドルウェアのためにechoが足すルート
+		if strings.HasPrefix(r.Name, "github.com/labstack/echo") {
+			continue
+		}
+		key := r.Method + " " + r.Path
+		registered[key] = true
+		if !documented[key] {
+			e.Logger.Warnf("%v is not in the OpenAPI document", key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			e.Logger.Warnf("%v is in the OpenAPI document but not registered", key)
+		}
+	}
+}
+
+// openAPISchemas components.schemas。名前のある構造体は型の名前で入れて参照する
+type openAPISchemas map[string]interface{}
+
+func (s openAPISchemas) operation(op apiOperation) map[string]interface{} {
+	res := map[string]interface{}{
+		"operationId": op.id,
+		"tags":        []string{op.tag},
+		"summary":     op.summary,
+	}
+
+	params := []interface{}{}
+	if op.request != nil {
+		params = append(params, s.parameters(reflect.TypeOf(op.request))...)
+	}
+	if op.idempotent {
+		params = append(params, map[string]interface{}{
+			"name":        idempotencyKeyHeader,
+			"in":          "header",
+			"description": "A retry with the same key from the same client returns the recorded response",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	if op.conditional {
+		params = append(params, map[string]interface{}{
+			"name":   "If-None-Match",
+			"in":     "header",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		res["parameters"] = params
+	}
+
+	if op.body != nil {
+		res["requestBody"] = map[string]interface{}{"required": true, "content": s.content(op.body)}
+	} else if op.method != http.MethodGet && op.request != nil && hasJSONFields(reflect.TypeOf(op.request)) {
+		res["requestBody"] = map[string]interface{}{"required": true, "content": s.content(op.request)}
+	}
+
+	responses := map[string]interface{}{}
+	for _, r := range op.responses {
+		resp := map[string]interface{}{"description": r.description}
+		if r.body != nil {
+			resp["content"] = s.content(r.body)
+		}
+		responses[strconv.Itoa(r.status)] = resp
+	}
+	if op.conditional {
+		responses[strconv.Itoa(http.StatusNotModified)] = map[string]interface{}{"description": "not modified since the ETag in If-None-Match"}
+	}
+	responses["default"] = map[string]interface{}{"description": "error", "content": s.content(APIError{})}
+	res["responses"] = responses
+
+	if op.admin {
+		res["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+	}
+	return res
+}
+
+// content Goの値はJSONのスキーマにし、[]interface{}はoneOfにする
+func (s openAPISchemas) content(body interface{}) map[string]interface{} {
+	switch b := body.(type) {
+	case apiContent:
+		content := map[string]interface{}{}
+		for mime, schema := range b {
+			content[mime] = map[string]interface{}{"schema": schema}
+		}
+		return content
+	case []interface{}:
+		alts := make([]interface{}, 0, len(b))
+		for _, v := range b {
+			alts = append(alts, s.schemaOf(reflect.TypeOf(v)))
+		}
+		return map[string]interface{}{echo.MIMEApplicationJSON: map[string]interface{}{"schema": map[string]interface{}{"oneOf": alts}}}
+	}
+	return map[string]interface{}{echo.MIMEApplicationJSON: map[string]interface{}{"schema": s.schemaOf(reflect.TypeOf(body))}}
+}
+
+// parameters paramタグはパス、queryタグはクエリのパラメータにする
+func (s openAPISchemas) parameters(t reflect.Type) []interface{} {
+	params := []interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			params = append(params, s.parameters(f.Type)...)
+			continue
+		}
+		in, name := "path", f.Tag.Get("param")
+		if name == "" {
+			in, name = "query", f.Tag.Get("query")
+		}
+		if name == "" {
+			continue
+		}
+		schema := s.schemaOf(f.Type)
+		applyRules(schema, f.Tag.Get("validate"))
+		p := map[string]interface{}{
+			"name":     name,
+			"in":       in,
+			"required": in == "path" || hasRule(f, "required"),
+			"schema":   schema,
+		}
+		if f.Type.Kind() == reflect.Slice {
+			// decodeValuesはカンマで区切った1つの値として読む
+			p["explode"] = false
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+// schemaOf encoding/jsonで書いたtの値の形
+func (s openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if _, ok := s[t.Name()]; !ok {
+			// 自分を含む型のために先に名前を入れておく
+			s[t.Name()] = map[string]interface{}{}
+			s[t.Name()] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema jsonタグの名前でpropertiesを作る。
+// validateタグのある型はリクエストなのでrequiredのルールで、それ以外はレスポンスなのでomitemptyでないものを必須にする。
+// json:"-"でDBの列を隠しているフィールドはx-hidden-fieldsに書く
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	hidden := []string{}
+	isRequest := hasValidateTags(t)
+
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		var embedded []reflect.Type
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				if column := f.Tag.Get("db"); column != "" {
+					hidden = append(hidden, column)
+				}
+				continue
+			}
+			if f.Anonymous && tag == "" {
+				embedded = append(embedded, f.Type)
+				continue
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if name == "" {
+				name = f.Name
+			}
+			// 埋め込んだ型より外側のフィールドが優先される
+			if _, ok := props[name]; ok {
+				continue
+			}
+			schema := s.schemaOf(f.Type)
+			applyRules(schema, f.Tag.Get("validate"))
+			props[name] = schema
+			if isRequest && hasRule(f, "required") || !isRequest && !strings.Contains(tag, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+		for _, e := range embedded {
+			add(e)
+		}
+	}
+	add(t)
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	visible := []string{}
+	for _, column := range hidden {
+		if _, ok := props[column]; !ok {
+			visible = append(visible, column)
+		}
+	}
+	if len(visible) > 0 {
+		schema["x-hidden-fields"] = visible
+		schema["description"] = "Never includes " + strings.Join(visible, ", ")
+	}
+	return schema
+}
+
+// applyRules validateタグのルールをスキーマの制約にする。スライスはminitemsとmaxitems以外を要素に付ける
+func applyRules(schema map[string]interface{}, tag string) {
+	if tag == "" {
+		return
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for _, rule := range strings.Split(tag, ",") {
+			if strings.HasPrefix(rule, "minitems=") {
+				if n, err := strconv.Atoi(strings.TrimPrefix(rule, "minitems=")); err == nil {
+					schema["minItems"] = n
+				}
+			}
+			if strings.HasPrefix(rule, "maxitems=") {
+				if n, err := strconv.Atoi(strings.TrimPrefix(rule, "maxitems=")); err == nil {
+					schema["maxItems"] = n
+				}
+			}
+		}
+		applyRules(items, tag)
+		return
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		key, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+		switch key {
+		case "min":
+			schema["minimum"] = json.Number(arg)
+		case "max":
+			schema["maximum"] = json.Number(arg)
+		case "maxlen":
+			if n, err := strconv.Atoi(arg); err == nil {
+				schema["maxLength"] = n
+			}
+		case "email":
+			schema["format"] = "email"
+		case "enum":
+			if list, ok := paramLists[arg]; ok && len(list()) > 0 {
+				schema["enum"] = list()
+			}
+		case "features":
+			if list, ok := paramLists[arg]; ok && len(list()) > 0 {
+				quoted := make([]string, 0, len(list()))
+				for _, f := range list() {
+					quoted = append(quoted, regexp.QuoteMeta(f))
+				}
+				one := "(" + strings.Join(quoted, "|") + ")"
+				schema["pattern"] = "^(" + one + "(," + one + ")*)?$"
+			}
+		case "range":
+			if cond, ok := paramRanges[arg]; ok && len(cond().Ranges) > 0 {
+				ids := []string{}
+				for _, r := range cond().Ranges {
+					ids = append(ids, strconv.FormatInt(r.ID, 10))
+				}
+				schema["enum"] = ids
+			}
+		}
+	}
+}
+
+func hasJSONFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && hasJSONFields(f.Type) {
+			return true
+		}
+		if tag := f.Tag.Get("json"); tag != "" && tag != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasValidateTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && hasValidateTags(f.Type) {
+			return true
+		}
+		if f.Tag.Get("validate") != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// contractCase 1つのエンドポイントへのリクエストと、返るはずのステータスコード
+type contractCase struct {
+	name        string
+	target      string
+	contentType string
+	body        string
+	// setup リクエストの前に状態を用意する
+	setup  func()
+	status int
+}
+
+// nazottePolygon testTablesの物件を囲む多角形
+const nazottePolygon = `{"coordinates":[{"latitude":35.5,"longitude":139.6},{"latitude":35.5,"longitude":139.8},{"latitude":35.7,"longitude":139.8},{"latitude":35.7,"longitude":139.6},{"latitude":35.5,"longitude":139.6}]}`
+
+// nazotteLine 多角形にならない2点
+const nazotteLine = `{"coordinates":[{"latitude":35.5,"longitude":139.6},{"latitude":35.7,"longitude":139.8}]}`
+
+// chairsMultipart multipartのフィールドとファイルでpostChairに送る本文
+func chairsMultipart(field, value string) string {
+	return "--b\r\nContent-Disposition: form-data; name=\"" + field + "\"\r\n\r\n" + value + "\r\n" +
+		"--b\r\nContent-Disposition: form-data; name=\"chairs\"; filename=\"chairs.csv\"\r\nContent-Type: text/csv\r\n\r\n" +
+		"2,椅子,説明,/images/chair/2.png,5000,100,50,60,黒,肘かけ,座椅子,10,3\n\r\n--b--\r\n"
+}
+
+// contractCases apiOperationsのidごとのリクエスト。全てのエンドポイントに少なくとも1つ書く
+var contractCases = map[string][]contractCase{
+	"getChairDetail": {
+		{name: "ok", target: "/api/chair/1", status: http.StatusOK},
+		{name: "not found", target: "/api/chair/2", status: http.StatusNotFound},
+		{name: "invalid id", target: "/api/chair/abc", status: http.StatusBadRequest},
+	},
+	"getChairDetails": {
+		{name: "ok", target: "/api/chair?ids=1,2", status: http.StatusOK},
+		{name: "missing ids", target: "/api/chair", status: http.StatusBadRequest},
+	},
+	"postChair": {
+		{name: "csv", target: "/api/chair", contentType: "text/csv",
+			body: "2,椅子,説明,/images/chair/2.png,5000,100,50,60,黒,肘かけ,座椅子,10,3\n", status: http.StatusCreated},
+		{name: "invalid rows", target: "/api/chair", contentType: "text/csv", body: "2,椅子\n", status: http.StatusBadRequest},
+		{name: "invalid mode", target: "/api/chair?mode=merge", contentType: "text/csv", body: "", status: http.StatusBadRequest},
+		{name: "invalid dryRun", target: "/api/chair?dryRun=maybe", contentType: "text/csv", body: "", status: http.StatusBadRequest},
+		{name: "multipart", target: "/api/chair", contentType: "multipart/form-data; boundary=b", body: chairsMultipart("dryRun", "true"), status: http.StatusOK},
+		{name: "multipart invalid mode", target: "/api/chair", contentType: "multipart/form-data; boundary=b", body: chairsMultipart("mode", "merge"), status: http.StatusBadRequest},
+	},
+	"searchChairs": {
+		{name: "ok", target: "/api/chair/search?priceRangeId=1&page=0&perPage=20", status: http.StatusOK},
+		{name: "no condition", target: "/api/chair/search?page=0&perPage=20", status: http.StatusBadRequest},
+	},
+	"getLowPricedChair":       {{name: "ok", target: "/api/chair/low_priced", status: http.StatusOK}},
+	"getChairSearchCondition": {{name: "ok", target: "/api/chair/search/condition", status: http.StatusOK}},
+	"buyChair": {
+		{name: "ok", target: "/api/chair/buy/1", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusOK},
+		{name: "invalid email", target: "/api/chair/buy/1", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer"}`, status: http.StatusBadRequest},
+	},
+	"cancelChairOrder": {
+		{name: "ok", target: "/api/chair/order/1/cancel", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusOK},
+		{name: "other buyer", target: "/api/chair/order/1/cancel", contentType: echo.MIMEApplicationJSON, body: `{"email":"other@example.com"}`, status: http.StatusNotFound},
+	},
+	"postChairRestockNotify": {
+		{name: "in stock", target: "/api/chair/1/notify", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusConflict},
+		{name: "not found", target: "/api/chair/2/notify", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusNotFound},
+	},
+	"streamChairStock": {
+		{name: "ok", target: "/api/chair/1/stream", status: http.StatusOK},
+		{name: "not found", target: "/api/chair/2/stream", status: http.StatusNotFound},
+	},
+	"streamChairsStock": {{name: "ok", target: "/api/chair/stream?ids=1,2", status: http.StatusOK}},
+
+	"getEstateDetail": {
+		{name: "ok", target: "/api/estate/1", status: http.StatusOK},
+		{name: "not found", target: "/api/estate/2", status: http.StatusNotFound},
+	},
+	"getEstateDetails": {{name: "ok", target: "/api/estate?ids=1,2", status: http.StatusOK}},
+	"postEstate": {
+		{name: "csv", target: "/api/estate", contentType: "text/csv",
+			body: "2,イスウーモ荘,説明,/images/estate/2.png,東京都,35.6,139.7,50000,200,100,オートロック,10\n", status: http.StatusCreated},
+	},
+	"searchEstates": {
+		{name: "ok", target: "/api/estate/search?rentRangeId=1&features=オートロック&page=0&perPage=20", status: http.StatusOK},
+		{name: "unknown feature", target: "/api/estate/search?features=unknown&page=0&perPage=20", status: http.StatusBadRequest},
+	},
+	"getLowPricedEstate": {{name: "ok", target: "/api/estate/low_priced", status: http.StatusOK}},
+	"postEstateRequestDocument": {
+		{name: "ok", target: "/api/estate/req_doc/1", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusOK},
+		{name: "not found", target: "/api/estate/req_doc/2", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusNotFound},
+	},
+	"searchEstateNazotte": {
+		{name: "ok", target: "/api/estate/nazotte", contentType: echo.MIMEApplicationJSON, body: nazottePolygon, status: http.StatusOK},
+		{name: "invalid body", target: "/api/estate/nazotte", contentType: echo.MIMEApplicationJSON, body: `{`, status: http.StatusBadRequest},
+		{name: "two points", target: "/api/estate/nazotte", contentType: echo.MIMEApplicationJSON, body: nazotteLine, status: http.StatusBadRequest},
+	},
+	"getEstateSearchCondition":         {{name: "ok", target: "/api/estate/search/condition", status: http.StatusOK}},
+	"searchRecommendedEstateWithChair": {{name: "ok", target: "/api/recommended_estate/1", status: http.StatusOK}},
+
+	"getChairDetailV2": {
+		{name: "ok", target: "/api/v2/chair/1", status: http.StatusOK},
+		{name: "unknown field", target: "/api/v2/chair/1?fields=secret", status: http.StatusBadRequest},
+	},
+	"searchChairsV2": {{name: "ok", target: "/api/v2/chair/search?kind=ゲーミングチェア&page=0&perPage=20", status: http.StatusOK}},
+	"buyChairV2": {
+		{name: "ok", target: "/api/v2/chair/buy/1", contentType: echo.MIMEApplicationJSON, body: `{"email":"buyer@example.com"}`, status: http.StatusOK},
+	},
+	"getLowPricedChairV2": {{name: "ok", target: "/api/v2/chair/low_priced", status: http.StatusOK}},
+	"getEstateDetailV2":   {{name: "ok", target: "/api/v2/estate/1", status: http.StatusOK}},
+	"searchEstatesV2":     {{name: "ok", target: "/api/v2/estate/search?doorWidthRangeId=1&page=0&perPage=20", status: http.StatusOK}},
+	"getLowPricedEstateV2": {
+		{name: "ok", target: "/api/v2/estate/low_priced", status: http.StatusOK},
+	},
+	"searchEstateNazotteV2": {
+		{name: "ok", target: "/api/v2/estate/nazotte", contentType: echo.MIMEApplicationJSON, body: nazottePolygon, status: http.StatusOK},
+		{name: "two points", target: "/api/v2/estate/nazotte", contentType: echo.MIMEApplicationJSON, body: nazotteLine, status: http.StatusBadRequest},
+	},
+	"searchRecommendedEstateWithChairV2": {{name: "ok", target: "/api/v2/recommended_estate/1", status: http.StatusOK}},
+
+	"getImportJob": {
+		{name: "ok", target: "/api/imports/1", status: http.StatusOK},
+		{name: "not found", target: "/api/imports/2", status: http.StatusNotFound},
+	},
+
+	"patchAdminChair": {
+		{name: "ok", target: "/api/admin/chair/1", contentType: echo.MIMEApplicationJSON, body: `{"price":4000}`, status: http.StatusOK},
+		{name: "features", target: "/api/admin/chair/1", contentType: echo.MIMEApplicationJSON, body: `{"features":"肘かけ,キャスター"}`, status: http.StatusOK},
+		{name: "unknown feature", target: "/api/admin/chair/1", contentType: echo.MIMEApplicationJSON, body: `{"features":"肘かけ,空を飛べる"}`, status: http.StatusBadRequest},
+	},
+	"patchAdminEstate": {
+		{name: "ok", target: "/api/admin/estate/1", contentType: echo.MIMEApplicationJSON, body: `{"rent":70000}`, status: http.StatusOK},
+		{name: "unknown feature", target: "/api/admin/estate/1", contentType: echo.MIMEApplicationJSON, body: `{"features":"オートロック,空を飛べる"}`, status: http.StatusBadRequest},
+	},
+	"exportChairs": {
+		{name: "csv", target: "/api/admin/export/chairs", status: http.StatusOK},
+		{name: "json", target: "/api/admin/export/chairs?format=json", status: http.StatusOK},
+		{name: "filtered", target: "/api/admin/export/chairs?minId=1&maxId=0&kind=座椅子&inStock=true&header=true", status: http.StatusOK},
+		{name: "unknown format", target: "/api/admin/export/chairs?format=xml", status: http.StatusBadRequest},
+		{name: "invalid minId", target: "/api/admin/export/chairs?minId=abc", status: http.StatusBadRequest},
+	},
+	"exportEstates": {
+		{name: "ndjson", target: "/api/admin/export/estates?format=ndjson", status: http.StatusOK},
+		{name: "invalid maxRent", target: "/api/admin/export/estates?maxRent=1.5", status: http.StatusBadRequest},
+	},
+	"getCacheStats": {{name: "ok", target: "/api/admin/cache/stats", status: http.StatusOK}},
+	"getWarmupReport": {
+		{name: "not finished", target: "/api/admin/warmup", status: http.StatusNotFound},
+		{name: "ok", target: "/api/admin/warmup", setup: func() { warmUp("test") }, status: http.StatusOK},
+	},
+
+	"getOpenAPI": {{name: "ok", target: "/api/openapi.json", status: http.StatusOK}},
+}
+
+// contractSkipped 呼べないエンドポイントと、その理由
+var contractSkipped = map[string]string{
+	"initialize": "recreates the databases with the mysql command",
+}
+
+// streamTimeout SSEは切られるまで続くので、最初のイベントを待ってから切る
+const streamTimeout = 100 * time.Millisecond
+
+func TestOpenAPIContract(t *testing.T) {
+	e := newEcho(nil)
+	for _, op := range apiOperations {
+		op := op
+		cases, ok := contractCases[op.id]
+		if !ok {
+			if reason, skipped := contractSkipped[op.id]; skipped {
+				t.Logf("%v is not called: %v", op.id, reason)
+			} else {
+				t.Errorf("%v %v has no contract case", op.method, op.path)
+			}
+			continue
+		}
+		for _, tc := range cases {
+			tc := tc
+			t.Run(op.id+"/"+tc.name, func(t *testing.T) {
+				if tc.setup != nil {
+					tc.setup()
+				}
+				rec := serveContractCase(e, op, tc)
+				if rec.Code != tc.status {
+					t.Fatalf("%v %v returned %v, want %v: %s", op.method, tc.target, rec.Code, tc.status, rec.Body.String())
+				}
+				contentType := rec.Header().Get(echo.HeaderContentType)
+				var problems []string
+				if streamsBody(op) && rec.Code < http.StatusBadRequest {
+					problems = streamProblems(op, rec.Code, contentType)
+				} else {
+					problems = contractProblems(op, rec.Code, contentType, rec.Body.Bytes())
+				}
+				for _, problem := range problems {
+					t.Errorf("%v %v %v : %v", op.method, tc.target, rec.Code, problem)
+				}
+			})
+		}
+	}
+}
+
+func serveContractCase(e *echo.Echo, op apiOperation, tc contractCase) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(op.method, tc.target, strings.NewReader(tc.body))
+	if tc.contentType != "" {
+		req.Header.Set(echo.HeaderContentType, tc.contentType)
+	}
+	if op.admin {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+testAdminToken)
+	}
+	rec := httptest.NewRecorder()
+	if !streamsBody(op) || op.method != http.MethodGet || !strings.Contains(op.path, "stream") {
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), streamTimeout)
+	defer cancel()
+	e.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+// streamProblems 本文を流すエンドポイントはJSONでないので、ステータスコードとメディアタイプだけを確かめる
+func streamProblems(op apiOperation, status int, contentType string) []string {
+	for _, r := range op.responses {
+		if r.status != status {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return []string{"invalid content type " + contentType}
+		}
+		for documented := range r.body.(apiContent) {
+			if d, _, _ := mime.ParseMediaType(documented); d == mediaType {
+				return nil
+			}
+		}
+		return []string{"undocumented content type " + contentType}
+	}
+	return []string{"undocumented status"}
+}
+
+// TestOpenAPIRoutes 登録したルートとapiOperationsが一致する
+func TestOpenAPIRoutes(t *testing.T) {
+	e := newEcho(nil)
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.method+" "+op.path] = true
+	}
+	registered := map[string]bool{}
+	for _, r := range e.Routes() {
+		if strings.HasPrefix(r.Name, "github.com/labstack/echo") {
+			continue
+		}
+		key := r.Method + " " + r.Path
+		registered[key] = true
+		if !documented[key] {
+			t.Errorf("%v is not in the OpenAPI document", key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			t.Errorf("%v is in the OpenAPI document but not registered", key)
+		}
+	}
+}
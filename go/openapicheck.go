@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// contractCheck 実際のレスポンスをOpenAPIのドキュメントと突き合わせ、合わなければログに出す。
+// ベンチマークや結合テストの間だけOPENAPI_CONTRACT_CHECKで有効にする
+func contractCheck() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			op, ok := openAPIOperation(c.Request().Method, c.Path())
+			// v2のfields=で項目を絞ったレスポンスはスキーマのrequiredに合わない
+			if !ok || streamsBody(op) || c.QueryParam("fields") != "" {
+				return next(c)
+			}
+
+			res := c.Response()
+			w := &recordingWriter{ResponseWriter: res.Writer}
+			res.Writer = w
+			err := next(c)
+			if err != nil {
+				// エラーのレスポンスも確かめるので、ここで書かせる
+				c.Error(err)
+			}
+			res.Writer = w.ResponseWriter
+
+			for _, problem := range contractProblems(op, res.Status, res.Header().Get(echo.HeaderContentType), w.body.Bytes()) {
+				c.Logger().Errorf("contract violation %v %v %v : %v", op.method, op.path, res.Status, problem)
+			}
+			return nil
+		}
+	}
+}
+
+// recordingWriter 書いた本文を手元にも残す
+type recordingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+func openAPIOperation(method, path string) (apiOperation, bool) {
+	for _, op := range apiOperations {
+		if op.method == method && op.path == path {
+			return op, true
+		}
+	}
+	return apiOperation{}, false
+}
+
+// streamsBody 書き出しのように本文を流すエンドポイントは残さない
+func streamsBody(op apiOperation) bool {
+	for _, r := range op.responses {
+		if _, ok := r.body.(apiContent); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contractProblems ステータスコードが書かれているか、JSONの本文がスキーマに合うかを確かめる
+func contractProblems(op apiOperation, status int, contentType string, body []byte) []string {
+	paths := openAPIDocument["paths"].(map[string]interface{})
+	operation := paths[openAPIPath(op.path)].(map[string]interface{})[strings.ToLower(op.method)].(map[string]interface{})
+	responses := operation["responses"].(map[string]interface{})
+
+	resp, ok := responses[strconv.Itoa(status)].(map[string]interface{})
+	if !ok {
+		if status < http.StatusBadRequest {
+			return []string{"undocumented status"}
+		}
+		resp = responses["default"].(map[string]interface{})
+	}
+	content, ok := resp["content"].(map[string]interface{})
+	if !ok {
+		if len(body) > 0 {
+			return []string{"documented without a body"}
+		}
+		return nil
+	}
+	if !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) || len(body) == 0 {
+		return []string{fmt.Sprintf("expected a JSON body, got %q with %v bytes", contentType, len(body))}
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []string{"invalid JSON: " + err.Error()}
+	}
+	schema := content[echo.MIMEApplicationJSON].(map[string]interface{})["schema"].(map[string]interface{})
+	return schemaProblems(schema, v, "$")
+}
+
+// schemaProblems buildOpenAPIが作るスキーマの範囲で、vが合わない場所ごとに理由を返す
+func schemaProblems(schema map[string]interface{}, v interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		schemas := openAPIDocument["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		return schemaProblems(schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{}), v, at)
+	}
+	if alts, ok := schema["oneOf"].([]interface{}); ok {
+		for _, alt := range alts {
+			if len(schemaProblems(alt.(map[string]interface{}), v, at)) == 0 {
+				return nil
+			}
+		}
+		return []string{at + " matches none of the alternatives"}
+	}
+
+	typ, _ := schema["type"].(string)
+	if typ == "" {
+		return nil
+	}
+	if v == nil {
+		return []string{at + " is null"}
+	}
+	switch typ {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{at + " is not an object"}
+		}
+		return objectProblems(schema, m, at)
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return []string{at + " is not an array"}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		var problems []string
+		for i, item := range a {
+			problems = append(problems, schemaProblems(items, item, fmt.Sprintf("%v[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return []string{at + " is not a string"}
+		}
+		if enum, ok := schema["enum"].([]string); ok && !containsString(enum, s) {
+			return []string{fmt.Sprintf("%v %q is not one of %v", at, s, strings.Join(enum, ", "))}
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return []string{at + " is not an integer"}
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return []string{at + " is not a number"}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{at + " is not a boolean"}
+		}
+	}
+	return nil
+}
+
+// objectProblems 必須のフィールドが無いか、書かれていないフィールドがあれば違反にする。
+// 隠しているはずの列がレスポンスに出た場合もここで分かる
+func objectProblems(schema map[string]interface{}, m map[string]interface{}, at string) []string {
+	var problems []string
+	props, _ := schema["properties"].(map[string]interface{})
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if _, ok := m[name]; !ok {
+			problems = append(problems, at+"."+name+" is missing")
+		}
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := props[name].(map[string]interface{}); ok {
+			problems = append(problems, schemaProblems(prop, m[name], at+"."+name)...)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				problems = append(problems, at+"."+name+" is not documented")
+			}
+		case map[string]interface{}:
+			problems = append(problems, schemaProblems(extra, m[name], at+"."+name)...)
+		}
+	}
+	return problems
+}
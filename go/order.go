@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//ChairOrder 椅子の購入履歴
+type ChairOrder struct {
+	ID       int64  `db:"id"`
+	ChairID  int64  `db:"chair_id"`
+	Email    string `db:"email"`
+	Canceled bool   `db:"canceled"`
+}
+
+type ChairOrderResponse struct {
+	OrderID int64 `json:"orderId"`
+}
+
+type ChairOrderCancelRequest struct {
+	ID     int64  `json:"-" param:"id" validate:"required,min=1"`
+	Email  string `json:"email" validate:"required,email"`
+	Reason string `json:"reason" validate:"maxlen=1024"`
+}
+
+func cancelChairOrder(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*ChairOrderCancelRequest)
+	id := req.ID
+
+	tx, err := db.withState.Beginx()
+	if err != nil {
+		return internalError("failed to create transaction", err)
+	}
+	defer tx.Rollback()
+
+	var order ChairOrder
+	err = tx.GetContext(ctx, &order, "SELECT id, chair_id, email, canceled_at IS NOT NULL AS canceled FROM chair_order WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("order %v not found", id)
+		}
+		return internalError("DB Execution Error: on getting a chair order by id", err)
+	}
+	if order.Email != req.Email {
+		// 他人の注文があることは明かさない
+		return notFound("order %v not found", id)
+	}
+	if order.Canceled {
+		return conflict("order %v is already canceled", id)
+	}
+
+	var chair Chair
+	err = tx.GetContext(ctx, &chair, "SELECT * FROM chair WHERE id = ? FOR UPDATE", order.ChairID)
+	if err != nil {
+		return internalError("DB Execution Error: on getting a chair by id", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE chair SET stock = stock + 1 WHERE id = ?", order.ChairID)
+	if err != nil {
+		return internalError("chair stock restore failed", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE chair_order SET canceled_at = ?, cancel_reason = ? WHERE id = ?", time.Now(), req.Reason, id)
+	if err != nil {
+		return internalError("chair order cancel failed", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return internalError("transaction commit error", err)
+	}
+
+	restocked := chair
+	restocked.Stock++
+	invalidateChairCache(ctx, chair, restocked)
+	if chair.Stock <= 0 {
+		go notifyRestock(context.Background(), []int64{order.ChairID})
+	}
+
+	return c.NoContent(http.StatusOK)
+}
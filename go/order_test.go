@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"testing"
+)
+
+const stockRestore = "UPDATE chair SET stock = stock + 1"
+
+func TestCancelChairOrder(t *testing.T) {
+	cases := []struct {
+		name     string
+		email    string
+		canceled bool
+		status   int
+		restored bool
+	}{
+		{"ok", "buyer@example.com", false, http.StatusOK, true},
+		{"other buyer", "other@example.com", false, http.StatusNotFound, false},
+		{"already canceled", "buyer@example.com", true, http.StatusConflict, false},
+	}
+	e := newEcho(nil)
+	for _, tc := range cases {
+		withTables(func(tables map[string]*fakeTable) {
+			tables["chair_order"].rows = [][]driver.Value{{int64(1), int64(1), "buyer@example.com", tc.canceled}}
+		}, func(fake *fakeDB) {
+			rec := serveRequest(e, http.MethodPost, "/api/chair/order/1/cancel", `{"email":"`+tc.email+`","reason":"changed my mind"}`, nil)
+			if rec.Code != tc.status {
+				t.Fatalf("%v: status %v, want %v: %s", tc.name, rec.Code, tc.status, rec.Body.String())
+			}
+			restores := fake.executed(stockRestore)
+			if restored := len(restores) > 0; restored != tc.restored {
+				t.Fatalf("%v: stock restored = %v, want %v", tc.name, restored, tc.restored)
+			}
+			if !tc.restored {
+				if canceled := fake.executed("UPDATE chair_order SET canceled_at"); len(canceled) > 0 {
+					t.Errorf("%v: order canceled with %v", tc.name, canceled)
+				}
+				return
+			}
+			if len(restores) != 1 || restores[0][0] != int64(1) {
+				t.Errorf("%v: stock restored with %v, want once for chair 1", tc.name, restores)
+			}
+			canceled := fake.executed("UPDATE chair_order SET canceled_at")
+			if len(canceled) != 1 || canceled[0][1] != "changed my mind" || canceled[0][2] != int64(1) {
+				t.Errorf("%v: order canceled with %v, want order 1 with the reason", tc.name, canceled)
+			}
+		})
+	}
+}
+
+func TestCancelChairOrderClearsSoldOut(t *testing.T) {
+	e := newEcho(nil)
+	withTables(func(tables map[string]*fakeTable) {
+		// 最後の1つを買われた椅子
+		tables["chair"].rows[0][12] = int64(0)
+	}, func(fake *fakeDB) {
+		chairCache.SetSoldOut(context.Background(), 1)
+		defer chairCache.ClearSoldOut(context.Background(), 1)
+
+		rec := serveRequest(e, http.MethodPost, "/api/chair/order/1/cancel", `{"email":"buyer@example.com"}`, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %v, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if chairCache.SoldOut(1) {
+			t.Errorf("chair 1 is still sold out after the cancellation restored its stock")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//ImportRowError インポートする行の不正な箇所
+type ImportRowError struct {
+	Line   int    `json:"line"`
+	Column string `json:"column,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// recordReader 列名を指定して1行を読み、不正な値を列ごとに記録する
+type recordReader struct {
+	line    int
+	record  []string
+	columns []string
+	errors  []ImportRowError
+}
+
+func newRecordReader(line int, record []string, columns []string) *recordReader {
+	r := &recordReader{line: line, record: record, columns: columns}
+	if len(record) != len(columns) {
+		r.errors = append(r.errors, ImportRowError{
+			Line:   line,
+			Reason: fmt.Sprintf("expected %d columns, got %d", len(columns), len(record)),
+		})
+	}
+	return r
+}
+
+func (r *recordReader) fail(column, format string, args ...interface{}) {
+	r.errors = append(r.errors, ImportRowError{Line: r.line, Column: column, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (r *recordReader) value(column string) (string, bool) {
+	for i, c := range r.columns {
+		if c == column {
+			if i < len(r.record) {
+				return r.record[i], true
+			}
+			break
+		}
+	}
+	return "", false
+}
+
+func (r *recordReader) String(column string, maxLen int) string {
+	s, ok := r.value(column)
+	if !ok {
+		return ""
+	}
+	if utf8.RuneCountInString(s) > maxLen {
+		r.fail(column, "must be at most %d characters", maxLen)
+	}
+	return s
+}
+
+func (r *recordReader) Int(column string, min int64) int64 {
+	s, ok := r.value(column)
+	if !ok {
+		return 0
+	}
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			r.fail(column, "%q is out of range", s)
+		} else {
+			r.fail(column, "%q is not an integer", s)
+		}
+		return 0
+	}
+	if i < min {
+		r.fail(column, "must be greater than or equal to %d", min)
+	}
+	return i
+}
+
+func (r *recordReader) Float(column string, min, max float64) float64 {
+	s, ok := r.value(column)
+	if !ok {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		r.fail(column, "%q is not a number", s)
+		return 0
+	}
+	if f < min || max < f {
+		r.fail(column, "must be between %v and %v", min, max)
+	}
+	return f
+}
+
+// OneOf 検索条件のリストに含まれる値か確かめる。リストが空の場合は確かめない
+func (r *recordReader) OneOf(column string, list []string) string {
+	s, ok := r.value(column)
+	if !ok {
+		return ""
+	}
+	if len(list) > 0 && !containsString(list, s) {
+		r.fail(column, "unknown value %q", s)
+	}
+	return s
+}
+
+// Features カンマ区切りの各値が検索条件のリストに含まれるか確かめる
+func (r *recordReader) Features(column string, maxLen int, list []string) string {
+	s := r.String(column, maxLen)
+	if s == "" || len(list) == 0 {
+		return s
+	}
+	for _, f := range strings.Split(s, ",") {
+		if !containsString(list, f) {
+			r.fail(column, "unknown feature %q", f)
+		}
+	}
+	return s
+}
+
+func (r *recordReader) Errors() []ImportRowError {
+	return r.errors
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
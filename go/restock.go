@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const restockNotificationKind = "chair_restock"
+
+var restockSender RestockSender = outboxRestockSender{}
+
+//RestockNotification 再入荷通知の内容
+type RestockNotification struct {
+	ChairID   int64  `json:"chairId"`
+	ChairName string `json:"chairName"`
+	Email     string `json:"email"`
+	Stock     int64  `json:"stock"`
+}
+
+//RestockSender 再入荷通知の送信先
+type RestockSender interface {
+	Send(ctx context.Context, n RestockNotification) error
+}
+
+// outboxRestockSender notification_outboxテーブルに書き込む。実際の送信は別プロセスに任せる
+type outboxRestockSender struct{}
+
+func (outboxRestockSender) Send(ctx context.Context, n RestockNotification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = db.withState.ExecContext(ctx, "INSERT INTO notification_outbox(kind, email, payload, created_at) VALUES(?,?,?,?)", restockNotificationKind, n.Email, string(payload), time.Now())
+	return err
+}
+
+// fileRestockSender 1通知1行のJSONとしてファイルに追記する
+type fileRestockSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileRestockSender) Send(ctx context.Context, n RestockNotification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(payload, '\n'))
+	return err
+}
+
+// newRestockSender "outbox" または "file:<path>" から送信先を作る
+func newRestockSender(spec string) RestockSender {
+	if strings.HasPrefix(spec, "file:") {
+		return &fileRestockSender{path: strings.TrimPrefix(spec, "file:")}
+	}
+	return outboxRestockSender{}
+}
+
+type RestockSubscribeRequest struct {
+	ID    int64  `json:"-" param:"id" validate:"required,min=1"`
+	Email string `json:"email" validate:"required,email"`
+}
+
+// postChairRestockNotify 売り切れた椅子だけ購読できる。
+// 在庫のある椅子の未通知の購読は売り切れてから在庫が戻ったものなので、notifyRestockは在庫を見るだけで再入荷を判定できる
+func postChairRestockNotify(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*RestockSubscribeRequest)
+	id := req.ID
+
+	var stock int64
+	err := db.withState.GetContext(ctx, &stock, "SELECT stock FROM chair WHERE id = ?", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("chair %v not found", id)
+		}
+		return internalError("DB Execution Error: on getting a chair by id", err)
+	}
+	if stock > 0 {
+		return conflict("chair %v is in stock", id)
+	}
+
+	_, err = db.withState.ExecContext(ctx, "INSERT INTO chair_restock_subscription(chair_id, email, created_at) VALUES(?,?,?) ON DUPLICATE KEY UPDATE created_at = VALUES(created_at), notified_at = NULL", id, req.Email, time.Now())
+	if err != nil {
+		return internalError("restock subscription insert failed", err)
+	}
+
+	return c.NoContent(http.StatusCreated)
+}
+
+type restockTarget struct {
+	SubscriptionID int64  `db:"subscription_id"`
+	Email          string `db:"email"`
+	ChairID        int64  `db:"chair_id"`
+	ChairName      string `db:"name"`
+	Stock          int64  `db:"stock"`
+}
+
+// notifyRestock 在庫が戻った椅子の購読者に通知する。
+// chairIDsがnilなら、一括の取り込みの後のように在庫のある全ての椅子の未通知の購読者をJOINで探す。
+// 購読は売り切れた椅子にしかできないので、在庫のある椅子の未通知の購読者は再入荷を待っていた人だけになる
+func notifyRestock(ctx context.Context, chairIDs []int64) {
+	query := `SELECT s.id AS subscription_id, s.email, c.id AS chair_id, c.name, c.stock FROM chair_restock_subscription s JOIN chair c ON c.id = s.chair_id WHERE s.notified_at IS NULL AND c.stock > 0`
+	var params []interface{}
+	if chairIDs != nil {
+		if len(chairIDs) == 0 {
+			return
+		}
+		var err error
+		query, params, err = sqlx.In(query+` AND s.chair_id IN (?)`, chairIDs)
+		if err != nil {
+			log.Errorf("notifyRestock query build error : %v", err)
+			return
+		}
+	}
+	var targets []restockTarget
+	if err := db.withState.SelectContext(ctx, &targets, query, params...); err != nil {
+		log.Errorf("notifyRestock DB execution error : %v", err)
+		return
+	}
+
+	for _, t := range targets {
+		// 複数の更新が同時に走っても一度だけ送るように先に通知済みにする
+		res, err := db.withState.ExecContext(ctx, "UPDATE chair_restock_subscription SET notified_at = ? WHERE id = ? AND notified_at IS NULL", time.Now(), t.SubscriptionID)
+		if err != nil {
+			log.Errorf("notifyRestock claim error : %v", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		err = restockSender.Send(ctx, RestockNotification{
+			ChairID:   t.ChairID,
+			ChairName: t.ChairName,
+			Email:     t.Email,
+			Stock:     t.Stock,
+		})
+		if err != nil {
+			log.Errorf("notifyRestock send error : %v", err)
+			if _, err := db.withState.ExecContext(ctx, "UPDATE chair_restock_subscription SET notified_at = NULL WHERE id = ?", t.SubscriptionID); err != nil {
+				log.Errorf("notifyRestock release error : %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPostChairRestockNotifySoldOut(t *testing.T) {
+	e := newEcho(nil)
+	withTables(func(tables map[string]*fakeTable) {
+		tables["chair"].rows[0][12] = int64(0)
+	}, func(fake *fakeDB) {
+		rec := serveRequest(e, http.MethodPost, "/api/chair/1/notify", `{"email":"buyer@example.com"}`, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %v for a sold-out chair, want 201: %s", rec.Code, rec.Body.String())
+		}
+		if subscribed := fake.executed("INSERT INTO chair_restock_subscription"); len(subscribed) != 1 {
+			t.Errorf("subscribed %v times, want once", len(subscribed))
+		}
+	})
+}
+
+// readRestockFile fileRestockSenderが書いた通知を行の順に読む
+func readRestockFile(t *testing.T, path string) []RestockNotification {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var res []RestockNotification
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var n RestockNotification
+		if err := json.Unmarshal(s.Bytes(), &n); err != nil {
+			t.Fatalf("invalid notification %q : %v", s.Text(), err)
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+func TestNotifyRestockSendsToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restock.ndjson")
+
+	saved := restockSender
+	restockSender = newRestockSender("file:" + path)
+	defer func() { restockSender = saved }()
+
+	withTables(func(tables map[string]*fakeTable) {
+		// 売り切れていた椅子1を購読していて、取り込みで在庫が3に戻った
+		tables["chair_restock_subscription"].rows = [][]driver.Value{
+			{int64(7), "waiter@example.com", int64(1), "ゲーミングチェア", int64(3)},
+		}
+	}, func(fake *fakeDB) {
+		notifyRestock(context.Background(), nil)
+
+		sent := readRestockFile(t, path)
+		want := RestockNotification{ChairID: 1, ChairName: "ゲーミングチェア", Email: "waiter@example.com", Stock: 3}
+		if len(sent) != 1 || sent[0] != want {
+			t.Fatalf("sent %+v, want [%+v]", sent, want)
+		}
+		claimed := fake.executed("UPDATE chair_restock_subscription SET notified_at = ?")
+		if len(claimed) != 1 || claimed[0][1] != int64(7) {
+			t.Errorf("claimed %v, want subscription 7 once", claimed)
+		}
+	})
+
+	// 購読者がいなければ何も送らない
+	os.Remove(path)
+	notifyRestock(context.Background(), []int64{1})
+	if sent := readRestockFile(t, path); len(sent) != 0 {
+		t.Errorf("sent %+v without subscribers", sent)
+	}
+}
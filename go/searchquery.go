@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errSearchConditionNotFound 検索条件が1つも無い
+var errSearchConditionNotFound = newAPIError(http.StatusBadRequest, errCodeMissingParameter, "search condition not found", nil)
+
+// searchQuery 検索条件を正規化したもの。キャッシュのキーとタグはここから組み立てる
+type searchQuery struct {
+	filters []searchFilter
+}
+
+// searchFilter nameは固定の識別子で、範囲はrangeIdではなく範囲のidを値にする
+type searchFilter struct {
+	name  string
+	value string
+}
+
+func (q *searchQuery) add(name, value string) {
+	q.filters = append(q.filters, searchFilter{name: name, value: value})
+}
+
+func (q *searchQuery) addRange(name string, r *Range) {
+	q.add(name, strconv.FormatInt(r.ID, 10))
+}
+
+// addFeatures 特徴は全てを含むかの検索なので、順序と重複を無視する
+func (q *searchQuery) addFeatures(features []string) {
+	for _, f := range features {
+		if !q.has("feature", f) {
+			q.add("feature", f)
+		}
+	}
+}
+
+func (q *searchQuery) has(name, value string) bool {
+	for _, f := range q.filters {
+		if f.name == name && f.value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// countKey 条件を名前と値の順に並べ、値をエスケープして繋げる。異なる条件が同じキーになることはない
+func (q searchQuery) countKey() string {
+	filters := append([]searchFilter{}, q.filters...)
+	sort.Slice(filters, func(i, j int) bool {
+		if filters[i].name != filters[j].name {
+			return filters[i].name < filters[j].name
+		}
+		return filters[i].value < filters[j].value
+	})
+	pairs := make([]string, 0, len(filters))
+	for _, f := range filters {
+		pairs = append(pairs, f.name+"="+url.QueryEscape(f.value))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (q searchQuery) pageKey(page, perPage int) string {
+	return q.countKey() + "&page=" + strconv.Itoa(page) + "&perPage=" + strconv.Itoa(perPage)
+}
+
+// tags 条件ごとのキャッシュのタグ。chairTagsやestateTagsと同じ名前を使う
+func (q searchQuery) tags(features []string) []string {
+	tags := make([]string, 0, len(q.filters))
+	for _, f := range q.filters {
+		if f.name == "feature" {
+			tags = append(tags, featureSearchTag(features, f.value))
+			continue
+		}
+		tags = append(tags, f.name+":"+f.value)
+	}
+	return uniqueTags(tags)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 値にはキーの区切りやエスケープに使う文字と、他の条件に見える文字列を混ぜる
+var searchQueryAlphabet = []string{"a", "b", "1", "&", "=", "%", "+", " ", ",", "%26", "&color=", "&feature=", "黒", "折りたたみ可"}
+
+var searchQueryNames = []string{"price", "height", "width", "depth", "kind", "color", "rent", "feature"}
+
+func randomSearchValue(r *rand.Rand) string {
+	n := 1 + r.Intn(4)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(searchQueryAlphabet[r.Intn(len(searchQueryAlphabet))])
+	}
+	return b.String()
+}
+
+// randomSearchFilters 特徴以外は名前ごとに高々1つの条件
+func randomSearchFilters(r *rand.Rand) []searchFilter {
+	filters := make([]searchFilter, 0)
+	for _, name := range searchQueryNames {
+		if name == "feature" {
+			for i := r.Intn(4); i > 0; i-- {
+				filters = append(filters, searchFilter{name: name, value: randomSearchValue(r)})
+			}
+			continue
+		}
+		if r.Intn(2) == 0 {
+			filters = append(filters, searchFilter{name: name, value: randomSearchValue(r)})
+		}
+	}
+	return filters
+}
+
+// buildSearchQuery filtersを並べ替え、特徴を重複させてから組み立てる
+func buildSearchQuery(r *rand.Rand, filters []searchFilter) searchQuery {
+	shuffled := append([]searchFilter{}, filters...)
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	q := searchQuery{}
+	features := make([]string, 0)
+	for _, f := range shuffled {
+		if f.name == "feature" {
+			features = append(features, f.value)
+			if r.Intn(2) == 0 {
+				features = append(features, f.value)
+			}
+			continue
+		}
+		q.add(f.name, f.value)
+	}
+	r.Shuffle(len(features), func(i, j int) { features[i], features[j] = features[j], features[i] })
+	q.addFeatures(features)
+	return q
+}
+
+// canonicalSearchFilters 同じ条件の集合なら同じ文字列になる
+func canonicalSearchFilters(filters []searchFilter) string {
+	set := map[string]struct{}{}
+	for _, f := range filters {
+		set[fmt.Sprintf("%q=%q", f.name, f.value)] = struct{}{}
+	}
+	pairs := make([]string, 0, len(set))
+	for p := range set {
+		pairs = append(pairs, p)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+func TestSearchQueryKeyIgnoresOrderAndDuplicates(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		filters := randomSearchFilters(r)
+		a := buildSearchQuery(r, filters)
+		b := buildSearchQuery(r, filters)
+		if a.countKey() != b.countKey() {
+			t.Fatalf("same filters %v gave different keys %q and %q", filters, a.countKey(), b.countKey())
+		}
+		if a.pageKey(0, 20) != b.pageKey(0, 20) {
+			t.Fatalf("same filters %v gave different page keys %q and %q", filters, a.pageKey(0, 20), b.pageKey(0, 20))
+		}
+	}
+}
+
+func TestSearchQueryKeyDistinct(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	seen := map[string]string{}
+	for i := 0; i < 20000; i++ {
+		filters := randomSearchFilters(r)
+		canonical := canonicalSearchFilters(filters)
+		key := buildSearchQuery(r, filters).countKey()
+		if other, ok := seen[key]; ok && other != canonical {
+			t.Fatalf("filters %s and %s collide on key %q", other, canonical, key)
+		}
+		seen[key] = canonical
+
+		// 隣り合う2つの条件を、繋げると同じ文字列に見える1つの値にまとめても別のキーになる
+		if merged, ok := mergeSearchFilters(r, filters); ok && canonicalSearchFilters(merged) != canonical {
+			if mk := buildSearchQuery(r, merged).countKey(); mk == key {
+				t.Fatalf("filters %s and %s collide on key %q", canonical, canonicalSearchFilters(merged), key)
+			}
+		}
+	}
+}
+
+// mergeSearchFilters 並べた時に隣り合う2つの条件を、前の条件の値に後ろの条件を繋げた1つの条件にする
+func mergeSearchFilters(r *rand.Rand, filters []searchFilter) ([]searchFilter, bool) {
+	if len(filters) < 2 {
+		return nil, false
+	}
+	sorted := append([]searchFilter{}, filters...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].name != sorted[j].name {
+			return sorted[i].name < sorted[j].name
+		}
+		return sorted[i].value < sorted[j].value
+	})
+	i := r.Intn(len(sorted) - 1)
+	merged := append([]searchFilter{}, sorted[:i]...)
+	merged = append(merged, searchFilter{name: sorted[i].name, value: sorted[i].value + "&" + sorted[i+1].name + "=" + sorted[i+1].value})
+	merged = append(merged, sorted[i+2:]...)
+	return merged, true
+}
+
+func TestSearchQueryPageKeyDistinct(t *testing.T) {
+	q := searchQuery{}
+	q.add("kind", "a")
+	keys := map[string]bool{}
+	for page := 0; page < 20; page++ {
+		for perPage := 1; perPage <= 20; perPage++ {
+			key := q.pageKey(page, perPage)
+			if keys[key] {
+				t.Fatalf("page %v perPage %v reused key %q", page, perPage, key)
+			}
+			keys[key] = true
+		}
+	}
+}
+
+// encodeQueryValue 安全な文字もランダムにパーセントエンコードし、空白は+か%20にする
+func encodeQueryValue(r *rand.Rand, s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' && r.Intn(2) == 0:
+			b.WriteByte('+')
+		case ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') && r.Intn(2) == 0:
+			b.WriteByte(c)
+		case r.Intn(2) == 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			fmt.Fprintf(&b, "%%%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// encodeSearchParams パラメータの順序、特徴の順序と重複、エンコードを変えたクエリ文字列
+func encodeSearchParams(r *rand.Rand, params map[string]string, features []string) string {
+	pairs := make([]string, 0, len(params)+1)
+	for name, value := range params {
+		pairs = append(pairs, name+"="+encodeQueryValue(r, value))
+	}
+	if len(features) > 0 {
+		fs := append([]string{}, features...)
+		for _, f := range features {
+			if r.Intn(3) == 0 {
+				fs = append(fs, f)
+			}
+		}
+		r.Shuffle(len(fs), func(i, j int) { fs[i], fs[j] = fs[j], fs[i] })
+		encoded := make([]string, 0, len(fs))
+		for _, f := range fs {
+			encoded = append(encoded, encodeQueryValue(r, f))
+		}
+		sep := ","
+		if r.Intn(2) == 0 {
+			sep = "%2C"
+		}
+		pairs = append(pairs, "features="+strings.Join(encoded, sep))
+	}
+	r.Shuffle(len(pairs), func(i, j int) { pairs[i], pairs[j] = pairs[j], pairs[i] })
+	return strings.Join(pairs, "&")
+}
+
+func randomRangeID(r *rand.Rand, cond RangeCondition) string {
+	return strconv.Itoa(r.Intn(len(cond.Ranges)))
+}
+
+func randomFeatures(r *rand.Rand, list []string) []string {
+	features := make([]string, 0)
+	for _, i := range r.Perm(len(list))[:r.Intn(4)] {
+		features = append(features, list[i])
+	}
+	return features
+}
+
+func randomChairSearchParams(r *rand.Rand) (map[string]string, []string) {
+	cond := chairSearchCondition
+	for {
+		params := map[string]string{"page": strconv.Itoa(r.Intn(3)), "perPage": strconv.Itoa(1 + r.Intn(100))}
+		if r.Intn(2) == 0 {
+			params["priceRangeId"] = randomRangeID(r, cond.Price)
+		}
+		if r.Intn(2) == 0 {
+			params["heightRangeId"] = randomRangeID(r, cond.Height)
+		}
+		if r.Intn(2) == 0 {
+			params["widthRangeId"] = randomRangeID(r, cond.Width)
+		}
+		if r.Intn(2) == 0 {
+			params["depthRangeId"] = randomRangeID(r, cond.Depth)
+		}
+		if r.Intn(2) == 0 {
+			params["kind"] = cond.Kind.List[r.Intn(len(cond.Kind.List))]
+		}
+		if r.Intn(2) == 0 {
+			params["color"] = cond.Color.List[r.Intn(len(cond.Color.List))]
+		}
+		features := randomFeatures(r, cond.Feature.List)
+		if len(params) > 2 || len(features) > 0 {
+			return params, features
+		}
+	}
+}
+
+func randomEstateSearchParams(r *rand.Rand) (map[string]string, []string) {
+	cond := estateSearchCondition
+	for {
+		params := map[string]string{"page": strconv.Itoa(r.Intn(3)), "perPage": strconv.Itoa(1 + r.Intn(100))}
+		if r.Intn(2) == 0 {
+			params["doorHeightRangeId"] = randomRangeID(r, cond.DoorHeight)
+		}
+		if r.Intn(2) == 0 {
+			params["doorWidthRangeId"] = randomRangeID(r, cond.DoorWidth)
+		}
+		if r.Intn(2) == 0 {
+			params["rentRangeId"] = randomRangeID(r, cond.Rent)
+		}
+		features := randomFeatures(r, cond.Feature.List)
+		if len(params) > 2 || len(features) > 0 {
+			return params, features
+		}
+	}
+}
+
+// canonicalSearchParams 特徴の順序と重複を無視した、クエリの意味
+func canonicalSearchParams(params map[string]string, features []string) string {
+	pairs := make([]string, 0, len(params)+len(features))
+	for name, value := range params {
+		pairs = append(pairs, fmt.Sprintf("%q=%q", name, value))
+	}
+	for _, f := range features {
+		pairs = append(pairs, fmt.Sprintf("feature=%q", f))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// testSearchKeys 同じ検索をエンコードし直しても同じキーになり、違う検索は違うキーになることを確かめる
+func testSearchKeys(t *testing.T, seed int64, random func(*rand.Rand) (map[string]string, []string), key func(url.Values) (string, error)) {
+	r := rand.New(rand.NewSource(seed))
+	seen := map[string]string{}
+	for i := 0; i < 2000; i++ {
+		params, features := random(r)
+		canonical := canonicalSearchParams(params, features)
+
+		var first string
+		for j := 0; j < 3; j++ {
+			raw := encodeSearchParams(r, params, features)
+			v, err := url.ParseQuery(raw)
+			if err != nil {
+				t.Fatalf("failed to parse %q : %v", raw, err)
+			}
+			k, err := key(v)
+			if err != nil {
+				t.Fatalf("failed to build search from %q : %v", raw, err)
+			}
+			if j == 0 {
+				first = k
+			} else if k != first {
+				t.Fatalf("%s gave different keys %q and %q (query %q)", canonical, first, k, raw)
+			}
+		}
+		if other, ok := seen[first]; ok && other != canonical {
+			t.Fatalf("searches %s and %s collide on key %q", other, canonical, first)
+		}
+		seen[first] = canonical
+	}
+}
+
+func TestChairSearchKey(t *testing.T) {
+	testSearchKeys(t, 3, randomChairSearchParams, func(v url.Values) (string, error) {
+		s, err := parseChairSearch(v)
+		if err != nil {
+			return "", err
+		}
+		return s.query.pageKey(s.page, s.perPage), nil
+	})
+}
+
+func TestEstateSearchKey(t *testing.T) {
+	testSearchKeys(t, 4, randomEstateSearchParams, func(v url.Values) (string, error) {
+		s, err := parseEstateSearch(v)
+		if err != nil {
+			return "", err
+		}
+		return s.query.pageKey(s.page, s.perPage), nil
+	})
+}
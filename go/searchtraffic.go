@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// searchTrafficLimit 覚えておく検索の数
+const searchTrafficLimit = 1024
+
+// searchTraffic 1ページ目の検索を数え、ウォームアップで多い順に読み直す。
+// initializeでDBを作り直しても前回までの検索の傾向は残しておく
+var searchTraffic = newSearchTracker(searchTrafficLimit)
+
+// searchTracker 検索の種類と正規化したキーごとに回数を数える
+type searchTracker struct {
+	mu       sync.Mutex
+	limit    int
+	searches map[string]*trackedSearch
+}
+
+type trackedSearch struct {
+	kind  string
+	query url.Values
+	count int64
+}
+
+func newSearchTracker(limit int) *searchTracker {
+	return &searchTracker{limit: limit, searches: map[string]*trackedSearch{}}
+}
+
+// record keyにはsearchQuery.pageKeyを渡す。同じ検索の書き方の違いは最初のクエリで代表させる
+func (t *searchTracker) record(kind, key string, query url.Values) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.searches[kind+" "+key]; ok {
+		s.count++
+		return
+	}
+	if len(t.searches) >= t.limit {
+		t.decay()
+	}
+	t.searches[kind+" "+key] = &trackedSearch{kind: kind, query: copyValues(query), count: 1}
+}
+
+// decay 上限に達したら回数を半分にし、0になった検索を忘れる。古い検索ほど軽くなる。
+// 半分にしても新しい検索を入れる空きが無ければ、回数の少ない検索から忘れる
+func (t *searchTracker) decay() {
+	for key, s := range t.searches {
+		s.count /= 2
+		if s.count == 0 {
+			delete(t.searches, key)
+		}
+	}
+	excess := len(t.searches) - (t.limit - 1)
+	if excess <= 0 {
+		return
+	}
+	keys := make([]string, 0, len(t.searches))
+	for key := range t.searches {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return t.searches[keys[i]].count < t.searches[keys[j]].count
+	})
+	for _, key := range keys[:excess] {
+		delete(t.searches, key)
+	}
+}
+
+// top 回数の多い順にn件までのクエリを返す
+func (t *searchTracker) top(kind string, n int) []url.Values {
+	t.mu.Lock()
+	searches := make([]trackedSearch, 0, len(t.searches))
+	for _, s := range t.searches {
+		if s.kind == kind {
+			searches = append(searches, *s)
+		}
+	}
+	t.mu.Unlock()
+
+	sort.Slice(searches, func(i, j int) bool {
+		return searches[i].count > searches[j].count
+	})
+	if len(searches) > n {
+		searches = searches[:n]
+	}
+	res := make([]url.Values, 0, len(searches))
+	for _, s := range searches {
+		res = append(res, s.query)
+	}
+	return res
+}
+
+func copyValues(v url.Values) url.Values {
+	res := make(url.Values, len(v))
+	for key, vs := range v {
+		res[key] = append([]string{}, vs...)
+	}
+	return res
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSearchTrackerDecayEvictsLowestCounts(t *testing.T) {
+	tracker := newSearchTracker(3)
+	// 半分にしても0にならない回数にしておく
+	for i, key := range []string{"a", "b", "c"} {
+		for n := 0; n < (i+1)*2; n++ {
+			tracker.record("chair", key, url.Values{"key": {key}})
+		}
+	}
+	tracker.record("chair", "d", url.Values{"key": {"d"}})
+
+	if n := len(tracker.searches); n > 3 {
+		t.Fatalf("tracking %v searches, want at most the limit 3", n)
+	}
+	if _, ok := tracker.searches["chair a"]; ok {
+		t.Errorf("the least searched query was kept")
+	}
+	for _, key := range []string{"b", "c", "d"} {
+		if _, ok := tracker.searches["chair "+key]; !ok {
+			t.Errorf("query %v was evicted", key)
+		}
+	}
+	if top := tracker.top("chair", 1); len(top) != 1 || top[0].Get("key") != "c" {
+		t.Errorf("top %v, want the most searched query c", top)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// SSEのイベント名
+const (
+	stockEventStock   = "stock"
+	stockEventSoldOut = "soldOut"
+)
+
+// stockStreamBuffer 1つの接続に溜められるイベントの数。溢れた接続は切り、再接続で今の在庫から送り直す
+const stockStreamBuffer = 16
+
+// 同時に開ける接続の数と、無通信で切られないように送るコメントの間隔と、切れた時にクライアントが再接続するまでの間隔
+var (
+	stockStreamLimit     = 1024
+	stockStreamHeartbeat = 15 * time.Second
+	stockStreamRetry     = 3 * time.Second
+)
+
+var stockStream *stockHub
+
+//StockEvent SSEで送る椅子の在庫
+type StockEvent struct {
+	ChairID int64 `json:"chairId"`
+	Stock   int64 `json:"stock"`
+	SoldOut bool  `json:"soldOut"`
+	// seq hubが配った順番
+	seq uint64
+}
+
+func newStockEvent(chairID, stock int64) StockEvent {
+	return StockEvent{ChairID: chairID, Stock: stock, SoldOut: stock <= 0}
+}
+
+// stockHub 椅子の在庫の変化を購読しているSSEの接続に配る
+type stockHub struct {
+	mu    sync.Mutex
+	limit int
+	count int
+	// seq 最後に配ったイベントの順番
+	seq  uint64
+	subs map[int64]map[*stockSubscriber]struct{}
+}
+
+type stockSubscriber struct {
+	ids    []int64
+	events chan StockEvent
+	// done 購読を外してeventsを閉じた。hubのmuで守る
+	done bool
+	// snapshot 今の在庫を読み始めた時のhubのseq。これ以前のイベントは読んだ在庫に含まれている
+	snapshot uint64
+}
+
+func newStockHub(limit int) *stockHub {
+	return &stockHub{limit: limit, subs: map[int64]map[*stockSubscriber]struct{}{}}
+}
+
+// subscribe 上限に達していれば503のエラーを返す
+func (h *stockHub) subscribe(ids []int64) (*stockSubscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count >= h.limit {
+		return nil, newAPIError(http.StatusServiceUnavailable, errCodeOverloaded, "too many stock streams", nil)
+	}
+	s := &stockSubscriber{ids: ids, events: make(chan StockEvent, stockStreamBuffer)}
+	for _, id := range ids {
+		subs, ok := h.subs[id]
+		if !ok {
+			subs = map[*stockSubscriber]struct{}{}
+			h.subs[id] = subs
+		}
+		subs[s] = struct{}{}
+	}
+	h.count++
+	return s, nil
+}
+
+// mark 今の在庫を読む直前に呼び、それまでに溜まったイベントを古いものとして捨てさせる
+func (h *stockHub) mark(s *stockSubscriber) {
+	h.mu.Lock()
+	s.snapshot = h.seq
+	h.mu.Unlock()
+}
+
+// stale 今の在庫を読む前に配られたイベント
+func (s *stockSubscriber) stale(ev StockEvent) bool {
+	return ev.seq <= s.snapshot
+}
+
+func (h *stockHub) unsubscribe(s *stockSubscriber) {
+	h.mu.Lock()
+	h.remove(s)
+	h.mu.Unlock()
+}
+
+func (h *stockHub) remove(s *stockSubscriber) {
+	if s.done {
+		return
+	}
+	s.done = true
+	for _, id := range s.ids {
+		delete(h.subs[id], s)
+		if len(h.subs[id]) == 0 {
+			delete(h.subs, id)
+		}
+	}
+	h.count--
+	close(s.events)
+}
+
+// publish 待たずに配る。受け取りが追いつかない接続は切る
+func (h *stockHub) publish(events ...StockEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range events {
+		h.seq++
+		ev.seq = h.seq
+		for s := range h.subs[ev.ChairID] {
+			select {
+			case s.events <- ev:
+			default:
+				log.Infof("stock stream dropped a slow subscriber of chair %v", ev.ChairID)
+				h.remove(s)
+			}
+		}
+	}
+}
+
+// watched idsのうち購読されているもの。idsがnilなら購読されている全てのid
+func (h *stockHub) watched(ids []int64) []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]int64, 0)
+	if ids == nil {
+		for id := range h.subs {
+			res = append(res, id)
+		}
+		return res
+	}
+	for _, id := range ids {
+		if _, ok := h.subs[id]; ok {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// refresh 購読されている椅子だけ在庫をDBから読んで配る。在庫が分からない他のノードでの変更や取り込みの後に使う。
+// idsがnilなら購読されている全ての椅子を読む
+func (h *stockHub) refresh(ctx context.Context, ids []int64) {
+	if h == nil {
+		return
+	}
+	ids = h.watched(ids)
+	if len(ids) == 0 {
+		return
+	}
+	stocks, err := loadChairStocks(ctx, ids)
+	if err != nil {
+		log.Errorf("failed to refresh stock streams : %v", err)
+		return
+	}
+	events := make([]StockEvent, 0, len(ids))
+	for _, id := range ids {
+		// 消された椅子は売り切れとして送る
+		events = append(events, newStockEvent(id, stocks[id]))
+	}
+	h.publish(events...)
+}
+
+// relayStockChange 他のノードから届いた椅子のキャッシュの更新を、このノードの購読者にも伝える
+func relayStockChange(op string, keys []string) {
+	switch op {
+	case cacheOpSoldOut, cacheOpClearSoldOut:
+		ids := make([]int64, 0, len(keys))
+		for _, key := range keys {
+			if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		go stockStream.refresh(context.Background(), ids)
+	case cacheOpInvalidate:
+		// 在庫が変わった椅子はidのタグで消される
+		ids := make([]int64, 0)
+		for _, tag := range keys {
+			if !strings.HasPrefix(tag, "chair:") {
+				continue
+			}
+			if id, err := strconv.ParseInt(strings.TrimPrefix(tag, "chair:"), 10, 64); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			go stockStream.refresh(context.Background(), ids)
+		}
+	case cacheOpFlush:
+		go stockStream.refresh(context.Background(), nil)
+	}
+}
+
+// loadChairStocks idsの椅子の在庫。無い椅子は含めない
+func loadChairStocks(ctx context.Context, ids []int64) (map[int64]int64, error) {
+	query, params, err := sqlx.In(`SELECT id, stock FROM chair WHERE id IN (?)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	var chairs []Chair
+	if err := db.withState.SelectContext(ctx, &chairs, query, params...); err != nil {
+		return nil, err
+	}
+	stocks := make(map[int64]int64, len(chairs))
+	for _, chair := range chairs {
+		stocks[chair.ID] = chair.Stock
+	}
+	return stocks, nil
+}
+
+func streamChairStock(c echo.Context) error {
+	return serveStockStream(c, []int64{c.Get(requestKey).(*IDRequest).ID})
+}
+
+func streamChairsStock(c echo.Context) error {
+	return serveStockStream(c, c.Get(requestKey).(*IDsRequest).unique())
+}
+
+// serveStockStream 今の在庫を送ってから、変化があるたびにSSEで送る。
+// 売り切れはsoldOut、それ以外はstockのイベントにし、同じ在庫は続けて送らない
+func serveStockStream(c echo.Context, ids []int64) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	// 読んでから購読するまでの変化を取りこぼさないように先に購読する。
+	// 読む前に溜まったイベントは読んだ在庫より古いことがあるので捨てる
+	sub, err := stockStream.subscribe(ids)
+	if err != nil {
+		return err
+	}
+	defer stockStream.unsubscribe(sub)
+	stockStream.mark(sub)
+
+	stocks, err := loadChairStocks(ctx, ids)
+	if err != nil {
+		return internalError("failed to load chair stocks", err)
+	}
+	if len(stocks) == 0 {
+		return notFound("chairs %v not found", ids)
+	}
+
+	res := c.Response()
+	h := res.Header()
+	h.Set(echo.HeaderContentType, "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// nginxにバッファさせない
+	h.Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	last := make(map[int64]int64, len(ids))
+	send := func(ev StockEvent) error {
+		if stock, ok := last[ev.ChairID]; ok && stock == ev.Stock {
+			return nil
+		}
+		last[ev.ChairID] = ev.Stock
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		name := stockEventStock
+		if ev.SoldOut {
+			name = stockEventSoldOut
+		}
+		if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(res, "retry: %d\n\n", stockStreamRetry.Milliseconds()); err != nil {
+		return nil
+	}
+	for _, id := range ids {
+		if stock, ok := stocks[id]; ok {
+			if err := send(newStockEvent(id, stock)); err != nil {
+				return nil
+			}
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(stockStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-sub.events:
+			if !ok {
+				// 溢れて切られた。クライアントは再接続して今の在庫から受け取り直す
+				return nil
+			}
+			if sub.stale(ev) {
+				continue
+			}
+			if err := send(ev); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
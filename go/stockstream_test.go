@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStockHubDropsSlowSubscriber(t *testing.T) {
+	h := newStockHub(2)
+	slow, err := h.subscribe([]int64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := h.subscribe([]int64{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.unsubscribe(other)
+
+	// 溜められる数より1つ多く配ると、読まない接続は切られる
+	for i := 0; i <= stockStreamBuffer; i++ {
+		h.publish(newStockEvent(1, int64(i)))
+	}
+	received := 0
+	for range slow.events {
+		received++
+	}
+	if received != stockStreamBuffer {
+		t.Errorf("received %v events, want %v before the stream was closed", received, stockStreamBuffer)
+	}
+	if ids := h.watched(nil); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("watched %v, want only chair 2", ids)
+	}
+	// 切った接続の分は空くので、もう1つ購読できる
+	again, err := h.subscribe([]int64{1})
+	if err != nil {
+		t.Fatalf("subscribe after the drop: %v", err)
+	}
+	h.unsubscribe(again)
+	h.unsubscribe(slow)
+}
+
+func TestStockSubscriberDropsEventsBeforeSnapshot(t *testing.T) {
+	h := newStockHub(1)
+	sub, err := h.subscribe([]int64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.unsubscribe(sub)
+
+	h.publish(newStockEvent(1, 2))
+	h.mark(sub)
+	h.publish(newStockEvent(1, 1))
+
+	if ev := <-sub.events; !sub.stale(ev) {
+		t.Errorf("event %+v published before the snapshot was not stale", ev)
+	}
+	if ev := <-sub.events; sub.stale(ev) || ev.Stock != 1 {
+		t.Errorf("event %+v published after the snapshot was stale", ev)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/integrations/nrecho-v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// v2の椅子のavailability
+const (
+	availabilityInStock = "in_stock"
+	availabilitySoldOut = "sold_out"
+)
+
+//ChairV2 v2の椅子。v1では隠している人気度と在庫も返す
+type ChairV2 struct {
+	ID           int64        `json:"id"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Thumbnail    string       `json:"thumbnail"`
+	Price        int64        `json:"price"`
+	Height       int64        `json:"height"`
+	Width        int64        `json:"width"`
+	Depth        int64        `json:"depth"`
+	Color        string       `json:"color"`
+	Features     string       `json:"features"`
+	Kind         string       `json:"kind"`
+	Popularity   int64        `json:"popularity"`
+	Stock        int64        `json:"stock"`
+	Availability string       `json:"availability"`
+	Links        ChairLinksV2 `json:"links"`
+}
+
+//ChairLinksV2 椅子から辿れるエンドポイント。buyは在庫がある場合だけ
+type ChairLinksV2 struct {
+	Self               string `json:"self"`
+	RecommendedEstates string `json:"recommendedEstates"`
+	Buy                string `json:"buy,omitempty"`
+}
+
+//EstateV2 v2の物件。v1では隠している人気度も返す
+type EstateV2 struct {
+	ID          int64         `json:"id"`
+	Thumbnail   string        `json:"thumbnail"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Latitude    float64       `json:"latitude"`
+	Longitude   float64       `json:"longitude"`
+	Address     string        `json:"address"`
+	Rent        int64         `json:"rent"`
+	DoorHeight  int64         `json:"doorHeight"`
+	DoorWidth   int64         `json:"doorWidth"`
+	Features    string        `json:"features"`
+	Popularity  int64         `json:"popularity"`
+	Links       EstateLinksV2 `json:"links"`
+}
+
+//EstateLinksV2 物件から辿れるエンドポイント
+type EstateLinksV2 struct {
+	Self            string `json:"self"`
+	RequestDocument string `json:"requestDocument"`
+}
+
+//PaginationV2 検索結果のページ。pageは0から数える
+type PaginationV2 struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"perPage"`
+	Total      int64 `json:"total"`
+	TotalPages int64 `json:"totalPages"`
+	HasNext    bool  `json:"hasNext"`
+}
+
+//LinksV2 一覧のリンク。nextとprevは前後のページがある場合だけ
+type LinksV2 struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+//ChairListV2 v2の椅子の一覧。paginationは検索の場合だけ
+type ChairListV2 struct {
+	Items      []ChairV2     `json:"items"`
+	Pagination *PaginationV2 `json:"pagination,omitempty"`
+	Links      LinksV2       `json:"links"`
+}
+
+//EstateListV2 v2の物件の一覧。paginationは検索の場合だけ
+type EstateListV2 struct {
+	Items      []EstateV2    `json:"items"`
+	Pagination *PaginationV2 `json:"pagination,omitempty"`
+	Links      LinksV2       `json:"links"`
+}
+
+//ChairFieldsV2 fields=で返す椅子の項目を選ぶ。指定が無ければ全ての項目を返す
+type ChairFieldsV2 struct {
+	Fields []string `json:"-" query:"fields" validate:"enum=v2.chair"`
+}
+
+//EstateFieldsV2 fields=で返す物件の項目を選ぶ
+type EstateFieldsV2 struct {
+	Fields []string `json:"-" query:"fields" validate:"enum=v2.estate"`
+}
+
+type ChairDetailRequestV2 struct {
+	IDRequest
+	ChairFieldsV2
+}
+
+type ChairSearchRequestV2 struct {
+	ChairSearchRequest
+	ChairFieldsV2
+}
+
+type EstateDetailRequestV2 struct {
+	IDRequest
+	EstateFieldsV2
+}
+
+type EstateSearchRequestV2 struct {
+	EstateSearchRequest
+	EstateFieldsV2
+}
+
+type RecommendedEstateRequestV2 struct {
+	IDRequest
+	EstateFieldsV2
+}
+
+type NazotteRequestV2 struct {
+	Coordinates
+	EstateFieldsV2
+}
+
+// fields=で選べる項目。validateタグのenumで使う
+var (
+	chairV2Fields  = jsonFieldNames(reflect.TypeOf(ChairV2{}))
+	estateV2Fields = jsonFieldNames(reflect.TypeOf(EstateV2{}))
+)
+
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return names
+}
+
+func newChairV2(chair Chair) ChairV2 {
+	res := ChairV2{
+		ID:           chair.ID,
+		Name:         chair.Name,
+		Description:  chair.Description,
+		Thumbnail:    chair.Thumbnail,
+		Price:        chair.Price,
+		Height:       chair.Height,
+		Width:        chair.Width,
+		Depth:        chair.Depth,
+		Color:        chair.Color,
+		Features:     chair.Features,
+		Kind:         chair.Kind,
+		Popularity:   chair.Popularity,
+		Stock:        chair.Stock,
+		Availability: availabilitySoldOut,
+		Links: ChairLinksV2{
+			Self:               fmt.Sprintf("/api/v2/chair/%d", chair.ID),
+			RecommendedEstates: fmt.Sprintf("/api/v2/recommended_estate/%d", chair.ID),
+		},
+	}
+	if chair.Stock > 0 {
+		res.Availability = availabilityInStock
+		res.Links.Buy = fmt.Sprintf("/api/v2/chair/buy/%d", chair.ID)
+	}
+	return res
+}
+
+func newChairsV2(chairs []Chair) []ChairV2 {
+	res := make([]ChairV2, 0, len(chairs))
+	for _, chair := range chairs {
+		res = append(res, newChairV2(chair))
+	}
+	return res
+}
+
+func newEstateV2(estate Estate) EstateV2 {
+	return EstateV2{
+		ID:          estate.ID,
+		Thumbnail:   estate.Thumbnail,
+		Name:        estate.Name,
+		Description: estate.Description,
+		Latitude:    estate.Latitude,
+		Longitude:   estate.Longitude,
+		Address:     estate.Address,
+		Rent:        estate.Rent,
+		DoorHeight:  estate.DoorHeight,
+		DoorWidth:   estate.DoorWidth,
+		Features:    estate.Features,
+		Popularity:  estate.Popularity,
+		Links: EstateLinksV2{
+			Self:            fmt.Sprintf("/api/v2/estate/%d", estate.ID),
+			RequestDocument: fmt.Sprintf("/api/estate/req_doc/%d", estate.ID),
+		},
+	}
+}
+
+func newEstatesV2(estates []Estate) []EstateV2 {
+	res := make([]EstateV2, 0, len(estates))
+	for _, estate := range estates {
+		res = append(res, newEstateV2(estate))
+	}
+	return res
+}
+
+func newPaginationV2(page, perPage int, total int64) *PaginationV2 {
+	pages := (total + int64(perPage) - 1) / int64(perPage)
+	return &PaginationV2{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: pages,
+		HasNext:    int64(page+1) < pages,
+	}
+}
+
+// pageLinksV2 リクエストのクエリのpageだけを変えて前後のページのリンクを作る
+func pageLinksV2(c echo.Context, p *PaginationV2) LinksV2 {
+	links := LinksV2{Self: c.Request().URL.RequestURI()}
+	pageURL := func(page int) string {
+		q := copyValues(c.QueryParams())
+		q.Set("page", strconv.Itoa(page))
+		return c.Request().URL.Path + "?" + q.Encode()
+	}
+	if p.HasNext {
+		links.Next = pageURL(p.Page + 1)
+	}
+	if p.Page > 0 {
+		links.Prev = pageURL(p.Page - 1)
+	}
+	return links
+}
+
+// respondV2 fields=があれば、一覧はitemsの各要素を、詳細はそのものを指定した項目だけにして返す
+func respondV2(c echo.Context, v interface{}, fields []string) error {
+	if len(fields) == 0 {
+		return c.JSON(http.StatusOK, v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return internalError("failed to encode the response", err)
+	}
+	// 数値は書いた通りに返すのでfloat64にしない
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return internalError("failed to decode the response", err)
+	}
+	if items, ok := m["items"].([]interface{}); ok {
+		for i, item := range items {
+			items[i] = selectFields(item.(map[string]interface{}), fields)
+		}
+		return c.JSON(http.StatusOK, m)
+	}
+	return c.JSON(http.StatusOK, selectFields(m, fields))
+}
+
+func selectFields(m map[string]interface{}, fields []string) map[string]interface{} {
+	res := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := m[f]; ok {
+			res[f] = v
+		}
+	}
+	return res
+}
+
+func getChairDetailV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*ChairDetailRequestV2)
+
+	etag, modified := chairCache.Version()
+	if notModified(c, etag, modified, chairCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	chair, hit, err := fetchChairV2(ctx, req.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("chair %v not found", req.ID)
+		}
+		return internalError("Failed to get the chair from id", err)
+	}
+	setCacheStatus(c, hit)
+
+	return respondV2(c, newChairV2(chair), req.Fields)
+}
+
+// fetchChairV2 v2は売り切れの椅子もavailabilityを付けて返す。売り切れの椅子はキャッシュしていないのでDBから読む
+func fetchChairV2(ctx context.Context, id int64) (Chair, bool, error) {
+	if !chairCache.SoldOut(id) {
+		chair, hit, err := chairCache.FetchDetail(ctx, id, func(ctx context.Context) (Chair, error) {
+			return loadChairDetail(ctx, id)
+		})
+		if err != errChairSoldOut {
+			return chair, hit, err
+		}
+	}
+	chair := Chair{}
+	err := db.withState.GetContext(ctx, &chair, `SELECT * FROM chair WHERE id = ?`, id)
+	return chair, false, err
+}
+
+func searchChairsV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*ChairSearchRequestV2)
+	s, err := newChairSearch(&req.ChairSearchRequest)
+	if err != nil {
+		return err
+	}
+
+	res, hit, err := s.fetch(ctx)
+	if err != nil {
+		return internalError("searchChairsV2 DB execution error", err)
+	}
+	if s.page == 0 {
+		searchTraffic.record("chair", s.query.pageKey(s.page, s.perPage), c.QueryParams())
+	}
+	setCacheStatus(c, hit)
+
+	p := newPaginationV2(s.page, s.perPage, res.Count)
+	return respondV2(c, ChairListV2{Items: newChairsV2(res.Chairs), Pagination: p, Links: pageLinksV2(c, p)}, req.Fields)
+}
+
+// buyChairV2 v1と同じく購入し、キャンセルに使う注文のidを返す
+func buyChairV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	orderID, err := purchaseChair(ctx, c.Get(requestKey).(*BuyChairRequest))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ChairOrderResponse{OrderID: orderID})
+}
+
+func getLowPricedChairV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*ChairFieldsV2)
+
+	etag, modified := chairCache.Version()
+	if notModified(c, etag, modified, chairCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	chairs, hit, err := chairCache.FetchLowPriced(ctx, loadLowPricedChairs)
+	if err != nil {
+		return internalError("getLowPricedChairV2 DB execution error", err)
+	}
+	setCacheStatus(c, hit)
+
+	return respondV2(c, ChairListV2{Items: newChairsV2(chairs), Links: LinksV2{Self: c.Request().URL.RequestURI()}}, req.Fields)
+}
+
+func getEstateDetailV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*EstateDetailRequestV2)
+
+	etag, modified := estateCache.Version()
+	if notModified(c, etag, modified, estateCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	var estate Estate
+	err := db.noState.GetContext(ctx, &estate, "SELECT * FROM estate WHERE id = ?", req.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("estate %v not found", req.ID)
+		}
+		return internalError("Database Execution error", err)
+	}
+
+	return respondV2(c, newEstateV2(estate), req.Fields)
+}
+
+func searchEstatesV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*EstateSearchRequestV2)
+	s, err := newEstateSearch(&req.EstateSearchRequest)
+	if err != nil {
+		return err
+	}
+
+	res, hit, err := s.fetch(ctx)
+	if err != nil {
+		return internalError("searchEstatesV2 DB execution error", err)
+	}
+	if s.page == 0 {
+		searchTraffic.record("estate", s.query.pageKey(s.page, s.perPage), c.QueryParams())
+	}
+	setCacheStatus(c, hit)
+
+	p := newPaginationV2(s.page, s.perPage, res.Count)
+	return respondV2(c, EstateListV2{Items: newEstatesV2(res.Estates), Pagination: p, Links: pageLinksV2(c, p)}, req.Fields)
+}
+
+func getLowPricedEstateV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*EstateFieldsV2)
+
+	etag, modified := estateCache.Version()
+	if notModified(c, etag, modified, estateCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	estates, hit, err := estateCache.FetchLowPriced(ctx, loadLowPricedEstates)
+	if err != nil {
+		return internalError("getLowPricedEstateV2 DB execution error", err)
+	}
+	setCacheStatus(c, hit)
+
+	return respondV2(c, EstateListV2{Items: newEstatesV2(estates), Links: LinksV2{Self: c.Request().URL.RequestURI()}}, req.Fields)
+}
+
+// searchRecommendedEstateWithChairV2 v1と違い、椅子が無ければ404を返す
+func searchRecommendedEstateWithChairV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*RecommendedEstateRequestV2)
+
+	estates, hit, err := estateCache.FetchRecommended(ctx, req.ID, func(ctx context.Context) ([]Estate, error) {
+		return loadRecommendedEstates(ctx, req.ID)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("chair %v not found", req.ID)
+		}
+		return internalError("Database execution error", err)
+	}
+	setCacheStatus(c, hit)
+
+	return respondV2(c, EstateListV2{Items: newEstatesV2(estates), Links: LinksV2{Self: c.Request().URL.RequestURI()}}, req.Fields)
+}
+
+func searchEstateNazotteV2(c echo.Context) error {
+	ctx := newrelic.NewContext(c.Request().Context(), nrecho.FromContext(c))
+
+	req := c.Get(requestKey).(*NazotteRequestV2)
+
+	estates, err := findEstatesInPolygon(ctx, &req.Coordinates)
+	if err != nil {
+		return err
+	}
+
+	return respondV2(c, EstateListV2{Items: newEstatesV2(estates), Links: LinksV2{Self: c.Request().URL.RequestURI()}}, req.Fields)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestChairV2BuyLink(t *testing.T) {
+	e := newEcho(nil)
+	rec := serveRequest(e, http.MethodGet, "/api/v2/chair/1", "", nil)
+	var chair ChairV2
+	if err := json.Unmarshal(rec.Body.Bytes(), &chair); err != nil {
+		t.Fatalf("GET /api/v2/chair/1 : %v : %s", err, rec.Body.String())
+	}
+	if chair.Links.Buy == "" {
+		t.Fatalf("no buy link for a chair in stock")
+	}
+
+	// v2のリンクを辿れば、キャンセルに使う注文のidが返る
+	rec = serveRequest(e, http.MethodPost, chair.Links.Buy, `{"email":"buyer@example.com"}`, nil)
+	var order ChairOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &order); err != nil || order.OrderID == 0 {
+		t.Fatalf("POST %v returned %v %q, want the order id", chair.Links.Buy, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// requestKey bindRequestで読んだリクエストをecho.Contextに入れるキー
+const requestKey = "request"
+
+//IDRequest パスのidだけを受け取るリクエスト
+type IDRequest struct {
+	ID int64 `param:"id" validate:"required,min=1"`
+}
+
+//IDsRequest カンマで区切ったidをまとめて受け取るリクエスト
+type IDsRequest struct {
+	IDs []int64 `query:"ids" validate:"required,maxitems=100,min=1"`
+}
+
+// unique 重複を除いたidを指定された順に返す
+func (r *IDsRequest) unique() []int64 {
+	seen := make(map[int64]bool, len(r.IDs))
+	ids := make([]int64, 0, len(r.IDs))
+	for _, id := range r.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// paramLists validateタグのenumで使う値の一覧。検索条件のファイルは起動時に読むので、使う時に引く
+var paramLists = map[string]func() []string{
+	"chair.kind":     func() []string { return chairSearchCondition.Kind.List },
+	"chair.color":    func() []string { return chairSearchCondition.Color.List },
+	"chair.feature":  func() []string { return chairSearchCondition.Feature.List },
+	"estate.feature": func() []string { return estateSearchCondition.Feature.List },
+	"import.mode": func() []string {
+		return []string{importModeInsert, importModeUpsert, importModeReplace, importModeDelete}
+	},
+	"export.format": func() []string {
+		return []string{importFormatCSV, importFormatJSON, importFormatNDJSON}
+	},
+	"v2.chair":  func() []string { return chairV2Fields },
+	"v2.estate": func() []string { return estateV2Fields },
+}
+
+// paramRanges validateタグのrangeで使う範囲の一覧
+var paramRanges = map[string]func() RangeCondition{
+	"chair.price":       func() RangeCondition { return chairSearchCondition.Price },
+	"chair.height":      func() RangeCondition { return chairSearchCondition.Height },
+	"chair.width":       func() RangeCondition { return chairSearchCondition.Width },
+	"chair.depth":       func() RangeCondition { return chairSearchCondition.Depth },
+	"estate.doorHeight": func() RangeCondition { return estateSearchCondition.DoorHeight },
+	"estate.doorWidth":  func() RangeCondition { return estateSearchCondition.DoorWidth },
+	"estate.rent":       func() RangeCondition { return estateSearchCondition.Rent },
+}
+
+// bindRequest ハンドラーの前にリクエストを読んで検証する。
+// protoと同じ型の値をリクエストごとに作り、ハンドラーにはc.Get(requestKey)で渡す
+func bindRequest(proto interface{}) echo.MiddlewareFunc {
+	typ := reflect.TypeOf(proto)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := reflect.New(typ).Interface()
+			if err := decodeRequest(c, req); err != nil {
+				return err
+			}
+			if err := c.Validate(req); err != nil {
+				return err
+			}
+			c.Set(requestKey, req)
+			return next(c)
+		}
+	}
+}
+
+// decodeRequest GETとDELETE以外でjsonタグがあれば本文をJSONとして読み、その後でパスとクエリのパラメータを入れる。
+// jsonタグの無いリクエストの本文はハンドラーが読む。multipartのフィールドも読まないので、必要ならハンドラーが読んで入れる
+func decodeRequest(c echo.Context, req interface{}) error {
+	method := c.Request().Method
+	query := c.QueryParams()
+	if method != http.MethodGet && method != http.MethodDelete && hasJSONFields(reflect.TypeOf(req).Elem()) {
+		if err := c.Bind(req); err != nil {
+			return invalidBody(err)
+		}
+	}
+	path := url.Values{}
+	for i, name := range c.ParamNames() {
+		path.Set(name, c.ParamValues()[i])
+	}
+	if err := decodeValues(path, "param", req); err != nil {
+		return err
+	}
+	return decodeValues(query, "query", req)
+}
+
+// decodeValues tagの名前のパラメータをフィールドの型に変換して入れる。文字列のスライスはカンマで区切り、埋め込んだ構造体にも入れる。
+// 整数は0と指定なしを区別できないので、requiredはここで確かめる。区別したい時はポインタにすると、指定された時だけ入れる
+func decodeValues(v url.Values, tag string, dst interface{}) error {
+	rv := reflect.ValueOf(dst).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := decodeValues(v, tag, rv.Field(i).Addr().Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		name := f.Tag.Get(tag)
+		if name == "" {
+			continue
+		}
+		s := v.Get(name)
+		if s == "" {
+			if hasRule(f, "required") {
+				return missingParam(name)
+			}
+			continue
+		}
+		field := rv.Field(i)
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return invalidParam(name, err)
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return invalidParam(name, err)
+			}
+			field.SetInt(n)
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return invalidParam(name, err)
+			}
+			field.SetFloat(n)
+		case reflect.Slice:
+			parts := strings.Split(s, ",")
+			switch field.Type().Elem().Kind() {
+			case reflect.String:
+				field.Set(reflect.ValueOf(parts))
+			case reflect.Int64:
+				ns := make([]int64, 0, len(parts))
+				for _, p := range parts {
+					n, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+					if err != nil {
+						return invalidParam(name, err)
+					}
+					ns = append(ns, n)
+				}
+				field.Set(reflect.ValueOf(ns))
+			default:
+				return fmt.Errorf("%v.%v : unsupported %v field", rt.Name(), f.Name, tag)
+			}
+		default:
+			return fmt.Errorf("%v.%v : unsupported %v field", rt.Name(), f.Name, tag)
+		}
+	}
+	return nil
+}
+
+// requestValidator validateタグで検証する。echo.Echo.Validatorに設定する
+type requestValidator struct{}
+
+func (requestValidator) Validate(i interface{}) error {
+	return validateRequest(i)
+}
+
+// validateRequest validateタグのルールをフィールドの順に確かめ、最初に違反したフィールドのエラーを返す。
+// ルールはカンマで区切り、required・min・max・maxlen・minitems・maxitems・email・enum・features・rangeを使える。
+// featuresはカンマで区切った文字列の全ての値がenumと同じ一覧にあるかを確かめる。
+// ポインタのフィールドはnilなら飛ばし、スライスは要素ごとに、埋め込んだ構造体はそのフィールドを確かめる
+func validateRequest(req interface{}) error {
+	rv := reflect.ValueOf(req)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := validateRequest(rv.Field(i).Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := fieldName(f)
+		v := rv.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			if rule == "required" {
+				if isEmpty(v) {
+					return missingParam(name)
+				}
+				continue
+			}
+			// minitemsとmaxitemsはスライスの要素ではなく数を確かめる
+			if strings.HasPrefix(rule, "minitems=") {
+				limit, err := strconv.Atoi(strings.TrimPrefix(rule, "minitems="))
+				if err != nil {
+					return fmt.Errorf("invalid validation rule %q : %v", rule, err)
+				}
+				if v.Len() < limit {
+					return invalidParamf(name, "must have at least %v items", limit)
+				}
+				continue
+			}
+			if strings.HasPrefix(rule, "maxitems=") {
+				limit, err := strconv.Atoi(strings.TrimPrefix(rule, "maxitems="))
+				if err != nil {
+					return fmt.Errorf("invalid validation rule %q : %v", rule, err)
+				}
+				if v.Len() > limit {
+					return invalidParamf(name, "must have at most %v items", limit)
+				}
+				continue
+			}
+			if err := checkRule(name, v, rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkRule(name string, v reflect.Value, rule string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return checkRule(name, v.Elem(), rule)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := checkRule(name, v.Index(i), rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	key, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		key, arg = rule[:i], rule[i+1:]
+	}
+	switch key {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid validation rule %q : %v", rule, err)
+		}
+		n, ok := number(v)
+		if !ok {
+			return fmt.Errorf("validation rule %q needs a number", rule)
+		}
+		if key == "min" && n < limit {
+			return invalidParamf(name, "must be at least %v", arg)
+		}
+		if key == "max" && n > limit {
+			return invalidParamf(name, "must be at most %v", arg)
+		}
+	case "maxlen":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid validation rule %q : %v", rule, err)
+		}
+		if len(v.String()) > limit {
+			return invalidParamf(name, "must be at most %v bytes", limit)
+		}
+	case "email":
+		s := v.String()
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+			return invalidParamf(name, "must be an email address")
+		}
+	case "enum":
+		list, ok := paramLists[arg]
+		if !ok {
+			return fmt.Errorf("unknown enum %q", arg)
+		}
+		if s := v.String(); s != "" && !containsString(list(), s) {
+			return invalidParamf(name, "must be one of %v", strings.Join(list(), ", "))
+		}
+	case "features":
+		list, ok := paramLists[arg]
+		if !ok {
+			return fmt.Errorf("unknown enum %q", arg)
+		}
+		if s := v.String(); s != "" {
+			for _, f := range strings.Split(s, ",") {
+				if !containsString(list(), f) {
+					return invalidParamf(name, "unknown feature %q", f)
+				}
+			}
+		}
+	case "range":
+		cond, ok := paramRanges[arg]
+		if !ok {
+			return fmt.Errorf("unknown range %q", arg)
+		}
+		if s := v.String(); s != "" {
+			if _, err := getRange(cond(), s); err != nil {
+				return invalidParamf(name, "must be one of the range ids from the search condition")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown validation rule %q", rule)
+	}
+	return nil
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// isEmpty requiredの判定。整数はdecodeValuesで確かめるのでここでは見ない
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// fieldName エラーのfieldに使う名前。クライアントが送った名前に合わせる
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"query", "param", "json"} {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func hasRule(f reflect.StructField, rule string) bool {
+	for _, r := range strings.Split(f.Tag.Get("validate"), ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+// validatedRequest 各ルールを1つずつ付けたリクエスト
+type validatedRequest struct {
+	Count    int64    `query:"count" validate:"min=1,max=10"`
+	Ratio    float64  `query:"ratio" validate:"min=0.5"`
+	Kind     string   `query:"kind" validate:"enum=chair.kind"`
+	Price    string   `query:"price" validate:"range=chair.price"`
+	Features string   `query:"features" validate:"features=chair.feature"`
+	Tags     []string `query:"tags" validate:"minitems=2,maxitems=3"`
+	Limit    *int64   `query:"limit" validate:"max=5"`
+}
+
+func TestValidateRequestRules(t *testing.T) {
+	valid := func() validatedRequest {
+		return validatedRequest{Count: 1, Ratio: 0.5, Tags: []string{"a", "b"}}
+	}
+	six := int64(6)
+	cases := []struct {
+		name   string
+		change func(r *validatedRequest)
+		field  string // 空なら通る
+	}{
+		{"valid", func(r *validatedRequest) {}, ""},
+		{"min", func(r *validatedRequest) { r.Count = 0 }, "count"},
+		{"max", func(r *validatedRequest) { r.Count = 11 }, "count"},
+		{"max bound", func(r *validatedRequest) { r.Count = 10 }, ""},
+		{"float min", func(r *validatedRequest) { r.Ratio = 0.4 }, "ratio"},
+		{"pointer max", func(r *validatedRequest) { r.Limit = &six }, "limit"},
+		{"enum", func(r *validatedRequest) { r.Kind = "座椅子" }, ""},
+		{"enum unknown", func(r *validatedRequest) { r.Kind = "ソファ" }, "kind"},
+		{"range", func(r *validatedRequest) { r.Price = "5" }, ""},
+		{"range out of bounds", func(r *validatedRequest) { r.Price = "6" }, "price"},
+		{"range not a number", func(r *validatedRequest) { r.Price = "cheap" }, "price"},
+		{"features", func(r *validatedRequest) { r.Features = "肘かけ,キャスター" }, ""},
+		{"features unknown", func(r *validatedRequest) { r.Features = "肘かけ,ふかふか" }, "features"},
+		{"features empty item", func(r *validatedRequest) { r.Features = "肘かけ," }, "features"},
+		{"minitems", func(r *validatedRequest) { r.Tags = []string{"a"} }, "tags"},
+		{"maxitems", func(r *validatedRequest) { r.Tags = []string{"a", "b", "c", "d"} }, "tags"},
+	}
+	for _, tc := range cases {
+		req := valid()
+		tc.change(&req)
+		err := validateRequest(&req)
+		if tc.field == "" {
+			if err != nil {
+				t.Errorf("%v: %v, want valid", tc.name, err)
+			}
+			continue
+		}
+		apiErr, ok := err.(*APIError)
+		if !ok || apiErr.Code != errCodeInvalidParameter || apiErr.Field != tc.field {
+			t.Errorf("%v: %#v, want an invalid %v", tc.name, err, tc.field)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// ウォームアップで読み直す数と全体の制限時間
+var (
+	warmupSearches        = 50
+	warmupRecommendations = 100
+	warmupTimeout         = 30 * time.Second
+)
+
+//WarmupReport 直近のウォームアップの結果
+type WarmupReport struct {
+	Reason          string    `json:"reason"`
+	StartedAt       time.Time `json:"startedAt"`
+	DurationMs      int64     `json:"durationMs"`
+	LowPriced       int       `json:"lowPriced"`
+	SoldOut         int       `json:"soldOut"`
+	Searches        int       `json:"searches"`
+	Recommendations int       `json:"recommendations"`
+	Errors          []string  `json:"errors"`
+}
+
+// warmupMu ウォームアップは1つずつ実行する。initializeが続いた場合は後のものがflush後のキャッシュを温める
+var warmupMu sync.Mutex
+
+var (
+	lastWarmupMu sync.Mutex
+	lastWarmup   *WarmupReport
+)
+
+// warmUp initializeや起動の直後に、最初のリクエストが来る前にキャッシュを読み込んでおく
+func warmUp(reason string) {
+	warmupMu.Lock()
+	defer warmupMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+
+	r := &WarmupReport{Reason: reason, StartedAt: time.Now(), Errors: []string{}}
+	fail := func(step string, err error) {
+		log.Errorf("warm-up %v failed : %v", step, err)
+		r.Errors = append(r.Errors, step+": "+err.Error())
+	}
+
+	chairs, _, err := chairCache.FetchLowPriced(ctx, loadLowPricedChairs)
+	if err != nil {
+		fail("low priced chairs", err)
+	}
+	r.LowPriced += len(chairs)
+	estates, _, err := estateCache.FetchLowPriced(ctx, loadLowPricedEstates)
+	if err != nil {
+		fail("low priced estates", err)
+	}
+	r.LowPriced += len(estates)
+
+	r.SoldOut, err = chairCache.LoadSoldOut(ctx, func(ctx context.Context) ([]int64, error) {
+		var ids []int64
+		err := db.withState.SelectContext(ctx, &ids, "SELECT id FROM chair WHERE stock <= 0")
+		return ids, err
+	})
+	if err != nil {
+		fail("sold out chairs", err)
+	}
+
+	r.Searches = warmSearches(ctx, fail)
+
+	// 安い順の一覧と人気の椅子は詳細を見られやすいので、おすすめも先に読んでおく
+	var popular []int64
+	err = db.withState.SelectContext(ctx, &popular, "SELECT id FROM chair WHERE stock > 0 ORDER BY popularity DESC, id ASC LIMIT ?", warmupRecommendations)
+	if err != nil {
+		fail("popular chairs", err)
+	}
+	ids := make([]int64, 0, len(chairs)+len(popular))
+	seen := map[int64]bool{}
+	for _, chair := range chairs {
+		ids = append(ids, chair.ID)
+		seen[chair.ID] = true
+	}
+	for _, id := range popular {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		id := id
+		_, _, err := estateCache.FetchRecommended(ctx, id, func(ctx context.Context) ([]Estate, error) {
+			return loadRecommendedEstates(ctx, id)
+		})
+		if err != nil {
+			fail("recommended estates", err)
+			break
+		}
+		r.Recommendations++
+	}
+
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+	log.Infof("warm-up after %v finished in %vms : %v low priced, %v sold out, %v searches, %v recommendations",
+		reason, r.DurationMs, r.LowPriced, r.SoldOut, r.Searches, r.Recommendations)
+
+	lastWarmupMu.Lock()
+	lastWarmup = r
+	lastWarmupMu.Unlock()
+}
+
+// warmSearches 最近多かった1ページ目の検索を読み直し、読めた数を返す
+func warmSearches(ctx context.Context, fail func(step string, err error)) int {
+	n := 0
+	for _, v := range searchTraffic.top("chair", warmupSearches) {
+		s, err := parseChairSearch(v)
+		if err != nil {
+			// 検索条件のファイルが変わった場合など。覚えていた検索が使えなくなっただけなので飛ばす
+			continue
+		}
+		if _, _, err := s.fetch(ctx); err != nil {
+			fail("chair searches", err)
+			return n
+		}
+		n++
+	}
+	for _, v := range searchTraffic.top("estate", warmupSearches) {
+		s, err := parseEstateSearch(v)
+		if err != nil {
+			continue
+		}
+		if _, _, err := s.fetch(ctx); err != nil {
+			fail("estate searches", err)
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// getWarmupReport 直近のウォームアップの結果を返す。まだ終わっていなければ404
+func getWarmupReport(c echo.Context) error {
+	lastWarmupMu.Lock()
+	r := lastWarmup
+	lastWarmupMu.Unlock()
+	if r == nil {
+		return notFound("warm-up has not finished yet")
+	}
+	return c.JSON(http.StatusOK, r)
+}
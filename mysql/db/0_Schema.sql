@@ -43,4 +43,83 @@ CREATE TABLE isuumo.chair
 
 CREATE INDEX chair_popularity ON isuumo.chair (popularity desc);
 CREATE INDEX chair_stock ON isuumo.chair (stock);
-CREATE INDEX chair_price ON isuumo.chair (price);
\ No newline at end of file
+CREATE INDEX chair_price ON isuumo.chair (price);
+CREATE TABLE isuumo.idempotency_key
+(
+    client          VARCHAR(64)     NOT NULL,
+    idempotency_key VARCHAR(255)    NOT NULL,
+    fingerprint     CHAR(64)        NOT NULL,
+    status_code     INTEGER         NOT NULL DEFAULT 0,
+    content_type    VARCHAR(128)    NOT NULL DEFAULT '',
+    response_body   MEDIUMBLOB      NOT NULL,
+    created_at      DATETIME(6)     NOT NULL,
+    PRIMARY KEY (client, idempotency_key)
+);
+
+CREATE INDEX idempotency_key_created_at ON isuumo.idempotency_key (created_at);
+
+CREATE TABLE isuumo.chair_order
+(
+    id            BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    chair_id      INTEGER         NOT NULL,
+    email         VARCHAR(255)    NOT NULL,
+    created_at    DATETIME(6)     NOT NULL,
+    canceled_at   DATETIME(6)     NULL,
+    cancel_reason VARCHAR(1024)   NOT NULL DEFAULT ''
+);
+
+CREATE INDEX chair_order_chair_id ON isuumo.chair_order (chair_id);
+
+CREATE TABLE isuumo.chair_restock_subscription
+(
+    id          BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    chair_id    INTEGER         NOT NULL,
+    email       VARCHAR(255)    NOT NULL,
+    created_at  DATETIME(6)     NOT NULL,
+    notified_at DATETIME(6)     NULL,
+    UNIQUE KEY chair_restock_subscription_chair_email (chair_id, email)
+);
+
+CREATE TABLE isuumo.notification_outbox
+(
+    id         BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    kind       VARCHAR(64)     NOT NULL,
+    email      VARCHAR(255)    NOT NULL,
+    payload    TEXT            NOT NULL,
+    created_at DATETIME(6)     NOT NULL
+);
+
+CREATE TABLE isuumo.import_job
+(
+    id             BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    node           VARCHAR(255)    NOT NULL,
+    generation     BIGINT          NOT NULL,
+    target         VARCHAR(16)     NOT NULL,
+    mode           VARCHAR(16)     NOT NULL,
+    format         VARCHAR(16)     NOT NULL,
+    options        TEXT            NOT NULL,
+    file_path      VARCHAR(1024)   NOT NULL,
+    status         VARCHAR(16)     NOT NULL,
+    rows_processed BIGINT          NOT NULL DEFAULT 0,
+    summary        TEXT            NOT NULL,
+    errors         MEDIUMTEXT      NOT NULL,
+    error_count    BIGINT          NOT NULL DEFAULT 0,
+    created_at     DATETIME(6)     NOT NULL,
+    started_at     DATETIME(6)     NULL,
+    finished_at    DATETIME(6)     NULL,
+    duration_ms    BIGINT          NOT NULL DEFAULT 0
+);
+
+CREATE INDEX import_job_node_status ON isuumo.import_job (node, status);
+
+CREATE TABLE isuumo.cache_invalidation
+(
+    id         BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    node       VARCHAR(255)    NOT NULL,
+    target     VARCHAR(16)     NOT NULL,
+    op         VARCHAR(16)     NOT NULL,
+    cache_keys TEXT            NOT NULL,
+    created_at DATETIME(6)     NOT NULL
+);
+
+CREATE INDEX cache_invalidation_created_at ON isuumo.cache_invalidation (created_at);